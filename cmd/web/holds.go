@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// holdReleaser is the part of the repository releaseHolds needs
+type holdReleaser interface {
+	ReleaseExpiredHolds(before time.Time) (int, error)
+}
+
+// releaseHolds cancels the reservations whose deposit was not paid within models.PaymentHold, so
+// their dates can be booked again. It releases straight away and then every interval, until ctx
+// is done.
+func releaseHolds(ctx context.Context, db holdReleaser, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := db.ReleaseExpiredHolds(time.Now().Add(-models.PaymentHold))
+		if err != nil {
+			errorLog.Println("Cannot release unpaid reservations:", err)
+		} else if n > 0 {
+			infoLog.Printf("Released %d reservations whose deposit was not paid", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// releaseRecorder records the cut-off of every release
+type releaseRecorder struct {
+	befores []time.Time
+	err     error
+}
+
+func (p *releaseRecorder) ReleaseExpiredHolds(before time.Time) (int, error) {
+	p.befores = append(p.befores, before)
+	return 1, p.err
+}
+
+func TestReleaseHolds(t *testing.T) {
+	infoLog = log.New(io.Discard, "", 0)
+	errorLog = log.New(io.Discard, "", 0)
+
+	for _, e := range []struct {
+		name string
+		err  error
+	}{
+		{"released", nil},
+		{"database_down", errors.New("database is down")},
+	} {
+		p := &releaseRecorder{err: e.err}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		releaseHolds(ctx, p, time.Minute)
+
+		if len(p.befores) != 1 {
+			t.Errorf("Failed %s: expected one release before stopping, got %d", e.name, len(p.befores))
+			continue
+		}
+		if age := time.Since(p.befores[0]); age < models.PaymentHold || age > models.PaymentHold+time.Minute {
+			t.Errorf("Failed %s: expected to release reservations made %s ago, got %s", e.name, models.PaymentHold, age)
+		}
+	}
+}
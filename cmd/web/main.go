@@ -1,25 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/Ed-cred/bookings/internal/calsync"
 	"github.com/Ed-cred/bookings/internal/config"
 	"github.com/Ed-cred/bookings/internal/driver"
 	"github.com/Ed-cred/bookings/internal/handlers"
 	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/payments"
 	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/Ed-cred/bookings/internal/scheduler"
 	"github.com/alexedwards/scs/v2"
 )
 
-const portNumber = ":8080"
-
 var (
 	app      config.AppConfig
 	session  *scs.SessionManager
@@ -28,56 +36,98 @@ var (
 )
 
 func main() {
-	db, err := run()
+	db, err := run(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Println("Connected to the database")
 
-	defer db.SQL.Close()
-	defer close(app.MailChan)
+	// the background jobs stop on an interrupt or SIGTERM, and so does the server below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the mail worker keeps going until the server has stopped, to send what the last requests queued
 	fmt.Println("Starting email listener...")
-	listenForMail()
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	mailDone := listenForMail(mailCtx, handlers.Repo.DB)
+
+	var jobs sync.WaitGroup
+	if app.CalendarSync > 0 {
+		fmt.Println("Starting calendar sync...")
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			calsync.New(handlers.Repo.DB, infoLog, errorLog).Run(ctx, app.CalendarSync)
+		}()
+	}
+	if app.TrashRetention > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			purgeTrash(ctx, handlers.Repo.DB, app.TrashRetention, time.Hour)
+		}()
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		releaseHolds(ctx, handlers.Repo.DB, 5*time.Minute)
+	}()
+	if app.ReminderDays > 0 || app.ThankYouDays > 0 {
+		s := scheduler.New(handlers.Repo.DB, app.EmailTemplates, app.MailFrom, handlers.Repo.GuestReservationURL, infoLog, errorLog)
+		s.ReminderDays, s.ThankYouDays, s.ReviewURL = app.ReminderDays, app.ThankYouDays, app.ReviewURL
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			s.Run(ctx, time.Hour)
+		}()
+	}
 
-	fmt.Printf("Starting up app on port %v\n", portNumber)
+	fmt.Printf("Starting up app on %v\n", app.Addr)
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    app.Addr,
 		Handler: routes(&app),
 	}
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Fatal(err)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-serverErr:
+		errorLog.Println("Server stopped:", err)
+	case <-ctx.Done():
+		infoLog.Println("Shutting down...")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	stopErr := shutdown(shutdownCtx, srv, stopMail, mailDone, &jobs)
+	cancel()
+	if stopErr != nil {
+		errorLog.Printf("Stopped after %s with %v", app.ShutdownTimeout, stopErr)
 	}
+	db.SQL.Close()
+	if err != nil || stopErr != nil {
+		os.Exit(1)
+	}
+	infoLog.Println("Stopped")
 }
 
-func run() (*driver.DB, error) {
+// run sets the application up from the command-line arguments args, the environment read through
+// lookupEnv and the config file they name, and connects to the database
+func run(args []string, lookupEnv func(string) (string, bool)) (*driver.DB, error) {
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	//read flags
-
-	inProd := flag.Bool("prod", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database username")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port number")
-	dbSSL := flag.String("dbssl", "disable", "Database ssl setting(disable, prefer, require)")
-
-	flag.Parse()
-	if *dbName == "" || *dbUser == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
-	}
 
-	// change to true when in produciton
-	app.InProd = *inProd
+	err := app.Load(args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
 
 	infoLog = log.New(os.Stdout, "Info\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -85,31 +135,51 @@ func run() (*driver.DB, error) {
 	errorLog = log.New(os.Stdout, "Error\t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
+	if len(app.SigningKey) == 0 {
+		// without a configured key, reset links stop working when the app restarts
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		app.SigningKey = key
+		infoLog.Println("No signing key configured, using a random key")
+	}
+
+	switch app.Payment.Provider {
+	case "stripe":
+		app.Payments = payments.NewStripe(app.Payment.SecretKey, app.Payment.WebhookSecret, app.Payment.Currency)
+	case "fake":
+		// Load refuses the fake in production; it accepts every card except payments.FakeTokenDeclined
+		app.Payments = payments.NewFake([]byte(app.Payment.WebhookSecret))
+		infoLog.Println("Using the fake payment provider, no real payments are taken")
+	default:
+		infoLog.Println("No payment provider configured, bookings are taken without a deposit")
+	}
+
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = app.SessionLifetime
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProd
 	app.Session = session
 	// Connect to database
 	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSql(connectionString)
+	db, err := driver.ConnectSql(app.DSN)
 	if err != nil {
-		log.Fatal("Cannot connect to database: ", err)
-		return nil, err
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
-		
-		
 	}
 	app.TemplateCache = tc
-	app.UseCache = *useCache
 	render.NewRenderer(&app)
+	app.EmailTemplates, err = mailer.Load("./email_templates")
+	if err != nil {
+		return nil, err
+	}
 
 	repo := handlers.NewRepository(&app, db)
 	handlers.NewHandlers(repo)
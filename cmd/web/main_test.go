@@ -1,10 +1,14 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
-	if err != nil {
-		t.Error("Failed to run")
+	noEnv := func(string) (string, bool) { return "", false }
+	_, err := run([]string{"-prod=false"}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "db_dsn") {
+		t.Errorf("expected run to fail without database settings, got %v", err)
 	}
-}
\ No newline at end of file
+}
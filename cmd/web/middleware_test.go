@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/go-chi/chi"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -27,3 +33,102 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf("Type is not http.Handler, instead type is %T", v)
 	}
 }
+
+var apiAuthTests = []struct {
+	name          string
+	authorization string
+	permission    models.Permission
+	expStatusCode int
+}{
+	{"missing_token", "", models.PermViewReservations, http.StatusUnauthorized},
+	{"wrong_scheme", "Basic staff-token", models.PermViewReservations, http.StatusUnauthorized},
+	{"unknown_token", "Bearer nope", models.PermViewReservations, http.StatusUnauthorized},
+	{"deactivated_user", "Bearer deactivated-token", models.PermViewReservations, http.StatusUnauthorized},
+	{"auditor_read", "Bearer auditor-token", models.PermViewReservations, http.StatusOK},
+	{"auditor_write", "Bearer auditor-token", models.PermEditReservations, http.StatusForbidden},
+	{"staff_write", "Bearer staff-token", models.PermEditReservations, http.StatusOK},
+	{"staff_delete", "Bearer staff-token", models.PermDeleteReservations, http.StatusForbidden},
+}
+
+func TestAPIAuth(t *testing.T) {
+	for _, e := range apiAuthTests {
+		var handler myHandler
+		h := APIAuth(RequireAPIPermission(e.permission)(&handler))
+
+		req := httptest.NewRequest("GET", "/api/v1/rooms", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected status code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+	}
+}
+
+var feedAuthTests = []struct {
+	name          string
+	room          string
+	query         string
+	authorization string
+	expStatusCode int
+}{
+	{"missing_token", "1", "", "", http.StatusUnauthorized},
+	{"feed_token", "1", "?token=room-1-feed-token", "", http.StatusOK},
+	{"feed_token_other_room", "2", "?token=room-1-feed-token", "", http.StatusUnauthorized},
+	{"api_token_in_query", "1", "?token=auditor-token", "", http.StatusUnauthorized},
+	{"unknown_query_token", "1", "?token=nope", "", http.StatusUnauthorized},
+	{"header_token", "1", "", "Bearer staff-token", http.StatusOK},
+	{"feed_token_in_header", "1", "", "Bearer room-1-feed-token", http.StatusUnauthorized},
+}
+
+func TestFeedAuth(t *testing.T) {
+	for _, e := range feedAuthTests {
+		var handler myHandler
+		h := FeedAuth(RequireAPIPermission(models.PermViewReservations)(&handler))
+
+		req := httptest.NewRequest("GET", "/feeds/rooms/"+e.room+".ics"+e.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.room)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected status code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+	}
+}
+
+var requirePermissionTests = []struct {
+	name          string
+	user          *models.User
+	permission    models.Permission
+	expStatusCode int
+}{
+	{"no_user", nil, models.PermViewReservations, http.StatusSeeOther},
+	{"auditor_view", &models.User{AccessLevel: models.AccessLevelAuditor}, models.PermViewReservations, http.StatusOK},
+	{"auditor_delete", &models.User{AccessLevel: models.AccessLevelAuditor}, models.PermDeleteReservations, http.StatusSeeOther},
+	{"staff_calendar", &models.User{AccessLevel: models.AccessLevelStaff}, models.PermEditCalendar, http.StatusOK},
+	{"owner_delete", &models.User{AccessLevel: models.AccessLevelOwner}, models.PermDeleteReservations, http.StatusOK},
+}
+
+func TestRequirePermission(t *testing.T) {
+	for _, e := range requirePermissionTests {
+		var handler myHandler
+		h := SessionLoad(RequirePermission(e.permission)(&handler))
+
+		req := httptest.NewRequest("GET", "/admin/reservations_all", nil)
+		if e.user != nil {
+			req = req.WithContext(helpers.WithUser(req.Context(), *e.user))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected status code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/Ed-cred/bookings/internal/config"
 	"github.com/Ed-cred/bookings/internal/handlers"
+	"github.com/Ed-cred/bookings/internal/models"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -17,8 +18,11 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Use(SessionLoad)
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	// the first two rooms had their own pages before rooms were stored in the database
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search_availability", handlers.Repo.Availability)
 	mux.Post("/search_availability", handlers.Repo.PostAvailability)
@@ -31,25 +35,136 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/make_reservation", handlers.Repo.Reservation)
 	mux.Post("/make_reservation", handlers.Repo.PostReservation)
 
+	mux.Get("/make_payment", handlers.Repo.Payment)
+	mux.Post("/make_payment", handlers.Repo.PostPayment)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
 	mux.Get("/reservation_summary", handlers.Repo.Summary)
 
+	mux.Get("/reservations/{code}", handlers.Repo.GuestReservation)
+	mux.Post("/reservations/{code}", handlers.Repo.GuestPostReservationDates)
+	mux.Post("/reservations/{code}/cancel", handlers.Repo.GuestCancelReservation)
+	mux.Get("/reservations/{code}/pay", handlers.Repo.GuestPayReservation)
+
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostLogin)
 	mux.Get("/user/logout", handlers.Repo.UserLogout)
 
+	mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", handlers.Repo.ResetPassword)
+	mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations_new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations_all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations_calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations_calendar", handlers.Repo.AdminPostReservationsCalendar)
 
-		mux.Get("/process_reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete_reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermViewReservations))
+			mux.Get("/reservations_new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations_all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations_search", handlers.Repo.AdminSearchReservations)
+			mux.Get("/reservations_calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+			mux.Get("/guests", handlers.Repo.AdminGuests)
+			mux.Get("/guests/{id}/show", handlers.Repo.AdminShowGuest)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermEditCalendar))
+			mux.Post("/reservations_calendar", handlers.Repo.AdminPostReservationsCalendar)
+			mux.Get("/blocks/new", handlers.Repo.AdminNewBlock)
+			mux.Post("/blocks/new", handlers.Repo.AdminPostNewBlock)
+			mux.Get("/blocks/{id}/show", handlers.Repo.AdminShowBlock)
+			mux.Post("/blocks/{id}", handlers.Repo.AdminPostBlock)
+			mux.Get("/delete_block/{id}/do", handlers.Repo.AdminDeleteBlock)
+			mux.Get("/recurring_blocks", handlers.Repo.AdminRecurringBlocks)
+			mux.Get("/recurring_blocks/new", handlers.Repo.AdminNewRecurringBlock)
+			mux.Post("/recurring_blocks/new", handlers.Repo.AdminPostNewRecurringBlock)
+			mux.Get("/recurring_blocks/{id}/show", handlers.Repo.AdminShowRecurringBlock)
+			mux.Post("/recurring_blocks/{id}", handlers.Repo.AdminPostRecurringBlock)
+			mux.Get("/delete_recurring_block/{id}/do", handlers.Repo.AdminDeleteRecurringBlock)
+		})
+
+		mux.With(RequirePermission(models.PermProcessReservations)).Post("/reservations/{src}/{id}/status", handlers.Repo.AdminChangeReservationStatus)
+		mux.With(RequirePermission(models.PermDeleteReservations)).Get("/delete_reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.With(RequirePermission(models.PermDeleteReservations)).Get("/reservations_trash", handlers.Repo.AdminTrash)
+		mux.With(RequirePermission(models.PermDeleteReservations)).Post("/reservations_trash/{id}/restore", handlers.Repo.AdminRestoreReservation)
+		mux.With(RequirePermission(models.PermRefundPayments)).Post("/reservations/{src}/{id}/refund", handlers.Repo.AdminRefundReservation)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/reservations/{src}/{id}", handlers.Repo.AdminPostReservation)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.With(RequirePermission(models.PermMergeGuests)).Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuest)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermManageAPITokens))
+			mux.Get("/api_tokens", handlers.Repo.AdminAPITokens)
+			mux.Post("/api_tokens", handlers.Repo.AdminPostAPIToken)
+			mux.Post("/api_tokens/{id}/revoke", handlers.Repo.AdminRevokeAPIToken)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermManageEmails))
+			mux.Get("/emails", handlers.Repo.AdminFailedEmails)
+			mux.Post("/emails/{id}/resend", handlers.Repo.AdminResendEmail)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermViewAudit))
+			mux.Get("/audit", handlers.Repo.AdminAudit)
+			mux.Get("/audit.csv", handlers.Repo.AdminAuditCSV)
+		})
+
+		mux.Get("/password", handlers.Repo.AdminChangePassword)
+		mux.Post("/password", handlers.Repo.AdminPostChangePassword)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermManageUsers))
+			mux.Get("/users", handlers.Repo.AdminUsers)
+			mux.Get("/users/new", handlers.Repo.AdminNewUser)
+			mux.Post("/users/new", handlers.Repo.AdminPostNewUser)
+			mux.Get("/users/{id}/show", handlers.Repo.AdminShowUser)
+			mux.Post("/users/{id}", handlers.Repo.AdminPostUser)
+			mux.Post("/users/{id}/password", handlers.Repo.AdminPostUserPassword)
+			mux.Post("/users/{id}/deactivate", handlers.Repo.AdminDeactivateUser)
+			mux.Post("/users/{id}/activate", handlers.Repo.AdminActivateUser)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequirePermission(models.PermManageRooms))
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+			mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+			mux.Get("/rooms/{id}/show", handlers.Repo.AdminShowRoom)
+			mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+			mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+			mux.Get("/delete_room_photo/{id}/{photo}/do", handlers.Repo.AdminDeleteRoomPhoto)
+			mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+			mux.Get("/delete_room_rate/{id}/{rate}/do", handlers.Repo.AdminDeleteRoomRate)
+			mux.Post("/rooms/{id}/feeds", handlers.Repo.AdminPostRoomFeed)
+			mux.Post("/rooms/{id}/feeds/{feed}/sync", handlers.Repo.AdminSyncRoomFeed)
+			mux.Post("/rooms/{id}/feeds/{feed}/delete", handlers.Repo.AdminDeleteRoomFeed)
+		})
+	})
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(APIAuth)
+		mux.Use(RequireAPIPermission(models.PermViewReservations))
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/rooms/{id}", handlers.Repo.APIRoom)
+		mux.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+
+		mux.Get("/reservations", handlers.Repo.APIReservations)
+		mux.Get("/reservations/search", handlers.Repo.APISearchReservations)
+		mux.Get("/reservations/{id}", handlers.Repo.APIReservation)
+
+		mux.With(RequireAPIPermission(models.PermEditReservations)).Post("/reservations", handlers.Repo.APICreateReservation)
+		mux.With(RequireAPIPermission(models.PermEditReservations)).Put("/reservations/{id}", handlers.Repo.APIUpdateReservation)
+		mux.With(RequireAPIPermission(models.PermDeleteReservations)).Delete("/reservations/{id}", handlers.Repo.APIDeleteReservation)
+	})
 
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostReservation)
+	mux.Route("/feeds", func(mux chi.Router) {
+		mux.With(FeedAuth, RequireAPIPermission(models.PermViewReservations)).Get("/rooms/{id}.ics", handlers.Repo.RoomCalendarFeed)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -1,55 +1,61 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/outbox"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
-func listenForMail() {
+// mailPollInterval is how often the outbox is checked for emails that are due
+const mailPollInterval = 10 * time.Second
+
+// listenForMail sends the emails in the outbox until ctx is done, then sends the ones that are due
+// once more and closes the returned channel
+func listenForMail(ctx context.Context, store outbox.Store) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
+		defer close(done)
+		outbox.New(store, sendMsg, app.MailMaxAttempts, infoLog, errorLog).Run(ctx, mailPollInterval)
 	}()
+	return done
 }
 
-func sendMsg(m models.MailData) {
+// sendMsg sends one email through the mail server
+func sendMsg(m models.MailData) error {
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
+	server.Host = app.SMTP.Host
+	server.Port = app.SMTP.Port
+	server.Username = app.SMTP.Username
+	server.Password = app.SMTP.Password
 	server.KeepAlive = false
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
 
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
-	}
 	email := mail.NewMSG()
 	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
+	if m.Text == "" {
 		email.SetBody(mail.TextHTML, m.Content)
 	} else {
-		data, err := os.ReadFile(fmt.Sprintf("./email_templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Println(err)
-		}
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]" , m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
+		// mail clients show the last part they can display, so the HTML one comes after the text
+		email.SetBody(mail.TextPlain, m.Text)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	}
+	if email.Error != nil {
+		return email.Error
 	}
 
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
 	err = email.Send(client)
 	if err != nil {
-		errorLog.Println(err)
-	} else {
-		log.Println("Email sent!")
+		return err
 	}
+	log.Println("Email sent!")
+	return nil
 }
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/Ed-cred/bookings/internal/handlers"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/alexedwards/scs/v2"
 )
 
 func TestMain(m *testing.M) {
-
+	app.InfoLog = log.New(os.Stdout, "Info\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "Error\t", log.Ldate|log.Ltime|log.Lshortfile)
+	session = scs.New()
+	app.Session = session
+	handlers.NewHandlers(handlers.NewTestRepository(&app))
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 
@@ -17,4 +27,4 @@ type myHandler struct {
 
 }
 
-func (h *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){}
\ No newline at end of file
+func (h *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// shutdown stops the app before ctx's deadline. The server stops taking requests and waits for
+// the ones in flight, then the mail worker is told to stop with stopMail so it sends the emails
+// those requests queued, and finally the background jobs, which must already have been told to
+// stop, are waited for. It returns an error naming the first step that did not finish in time.
+func shutdown(ctx context.Context, srv *http.Server, stopMail context.CancelFunc, mailDone <-chan struct{}, jobs *sync.WaitGroup) error {
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("requests still running: %w", err)
+	}
+	stopMail()
+	select {
+	case <-mailDone:
+	case <-ctx.Done():
+		return fmt.Errorf("emails still queued: %w", ctx.Err())
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		return fmt.Errorf("background jobs still running: %w", ctx.Err())
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// startServer serves handler on a free local port and returns the server and its URL
+func startServer(t *testing.T, handler http.HandlerFunc) (*http.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(l)
+	return srv, "http://" + l.Addr().String()
+}
+
+// mailQueue stands in for the outbox
+type mailQueue struct {
+	mu     sync.Mutex
+	queued []models.MailData
+	sent   []models.MailData
+}
+
+func (q *mailQueue) add(m models.MailData) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued = append(q.queued, m)
+}
+
+// worker stands in for the mail worker: once stopped, it sends what is queued after send returns
+// and closes the returned channel
+func (q *mailQueue) worker(send func()) (context.CancelFunc, <-chan struct{}) {
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		send()
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.sent, q.queued = append(q.sent, q.queued...), nil
+	}()
+	return stop, done
+}
+
+func TestShutdownDrainsRequestsAndMail(t *testing.T) {
+	var queue mailQueue
+	started := make(chan struct{})
+	srv, url := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			queue.add(models.MailData{To: "guest@example.com"})
+		}
+		w.Write([]byte("booked"))
+	})
+	stopMail, mailDone := queue.worker(func() {})
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	go func() {
+		defer jobs.Done()
+		<-jobCtx.Done()
+	}()
+	stopJobs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := shutdown(ctx, srv, stopMail, mailDone, &jobs)
+	if err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if b := <-body; b != "booked" {
+		t.Errorf("expected the request in flight to finish, got %q", b)
+	}
+	if len(queue.sent) != 3 {
+		t.Errorf("expected the 3 emails queued by the request to be sent, got %d", len(queue.sent))
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected the server to refuse new requests")
+	}
+}
+
+func TestShutdownTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	tests := []struct {
+		name string
+		// setup starts what keeps the shutdown waiting and returns the mail worker
+		setup  func(url string, jobs *sync.WaitGroup) (context.CancelFunc, <-chan struct{})
+		expErr string
+	}{
+		{
+			"request", func(url string, jobs *sync.WaitGroup) (context.CancelFunc, <-chan struct{}) {
+				go http.Get(url)
+				return new(mailQueue).worker(func() {})
+			},
+			"requests still running",
+		},
+		{
+			"mail", func(url string, jobs *sync.WaitGroup) (context.CancelFunc, <-chan struct{}) {
+				// a worker stuck on the mail server
+				return new(mailQueue).worker(func() { <-release })
+			},
+			"emails still queued",
+		},
+		{
+			"job", func(url string, jobs *sync.WaitGroup) (context.CancelFunc, <-chan struct{}) {
+				jobs.Add(1)
+				go func() {
+					defer jobs.Done()
+					<-release
+				}()
+				return new(mailQueue).worker(func() {})
+			},
+			"background jobs still running",
+		},
+	}
+	for _, e := range tests {
+		started := make(chan struct{}, 1)
+		srv, url := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+		})
+		var jobs sync.WaitGroup
+		stopMail, mailDone := e.setup(url, &jobs)
+		if e.name == "request" {
+			<-started
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := shutdown(ctx, srv, stopMail, mailDone, &jobs)
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), e.expErr) {
+			t.Errorf("Failed %s: expected error %q, got %v", e.name, e.expErr, err)
+		}
+	}
+}
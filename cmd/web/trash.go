@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// trashPurger is the part of the repository purgeTrash needs
+type trashPurger interface {
+	PurgeDeletedReservations(before time.Time) (int, error)
+}
+
+// purgeTrash permanently removes the reservations that have been in the trash for longer than
+// retention. It purges straight away and then every interval, until ctx is done.
+func purgeTrash(ctx context.Context, db trashPurger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := db.PurgeDeletedReservations(time.Now().Add(-retention))
+		if err != nil {
+			errorLog.Println("Cannot purge deleted reservations:", err)
+		} else if n > 0 {
+			infoLog.Printf("Purged %d deleted reservations", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
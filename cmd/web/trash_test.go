@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// purgeRecorder records the cut-off of every purge
+type purgeRecorder struct {
+	befores []time.Time
+	err     error
+}
+
+func (p *purgeRecorder) PurgeDeletedReservations(before time.Time) (int, error) {
+	p.befores = append(p.befores, before)
+	return 1, p.err
+}
+
+func TestPurgeTrash(t *testing.T) {
+	infoLog = log.New(io.Discard, "", 0)
+	errorLog = log.New(io.Discard, "", 0)
+
+	for _, e := range []struct {
+		name string
+		err  error
+	}{
+		{"purged", nil},
+		{"database_down", errors.New("database is down")},
+	} {
+		p := &purgeRecorder{err: e.err}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		purgeTrash(ctx, p, 30*24*time.Hour, time.Hour)
+
+		if len(p.befores) != 1 {
+			t.Errorf("Failed %s: expected one purge before stopping, got %d", e.name, len(p.befores))
+			continue
+		}
+		if age := time.Since(p.befores[0]); age < 30*24*time.Hour || age > 30*24*time.Hour+time.Minute {
+			t.Errorf("Failed %s: expected to purge reservations deleted 30 days ago, got %s", e.name, age)
+		}
+	}
+}
@@ -5,16 +5,16 @@ go 1.20
 require (
 	github.com/alexedwards/scs/v2 v2.5.1
 	github.com/go-chi/chi v1.5.4
+	github.com/jackc/pgx/v5 v5.4.2
 	github.com/justinas/nosurf v1.1.1
+	github.com/xhit/go-simple-mail/v2 v2.15.0
+	golang.org/x/crypto v0.9.0
 )
 
 require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.4.2 // indirect
 	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208 // indirect
-	github.com/xhit/go-simple-mail/v2 v2.15.0 // indirect
-	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
 
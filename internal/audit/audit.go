@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// ignoredFields are never recorded: bookkeeping that changes on every save, and secrets
+var ignoredFields = map[string]bool{
+	"ID":        true,
+	"CreatedAt": true,
+	"UpdatedAt": true,
+	"Password":  true,
+	"TokenHash": true,
+}
+
+// Diff returns the fields that differ between before and after, two values of the same struct
+// type, in the order the struct declares them. Either may be nil, for an entity that was created
+// or removed, in which case only the fields that are set on the other are returned. Nested
+// structs and slices, such as a reservation's room, are left out; they are audited on their own.
+// Lists of strings, such as a guest's tags, are recorded comma separated.
+func Diff(before, after interface{}) []models.AuditChange {
+	b, a := structValue(before), structValue(after)
+	switch {
+	case !b.IsValid() && !a.IsValid():
+		return nil
+	case !b.IsValid():
+		b = reflect.Zero(a.Type())
+	case !a.IsValid():
+		a = reflect.Zero(b.Type())
+	}
+
+	var changes []models.AuditChange
+	t := b.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || ignoredFields[f.Name] {
+			continue
+		}
+		from, ok := fieldString(b.Field(i))
+		if !ok {
+			continue
+		}
+		to, _ := fieldString(a.Field(i))
+		if from == to {
+			continue
+		}
+		// a created entity has nothing before, and a removed one nothing after
+		if before == nil {
+			from = ""
+		}
+		if after == nil {
+			to = ""
+		}
+		changes = append(changes, models.AuditChange{Field: fieldName(f.Name), Before: from, After: to})
+	}
+	return changes
+}
+
+// structValue returns the struct v holds, or the zero Value for nil
+func structValue(v interface{}) reflect.Value {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return rv
+}
+
+// fieldString formats a field for the log. It reports false for fields that are not recorded.
+func fieldString(f reflect.Value) (string, bool) {
+	switch v := f.Interface().(type) {
+	case time.Time:
+		return formatTime(v), true
+	case []string:
+		return strings.Join(v, ", "), true
+	}
+	switch f.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface, reflect.Func, reflect.Chan:
+		return "", false
+	}
+	return fmt.Sprint(f.Interface()), true
+}
+
+// formatTime shows dates without a time of day, as they are for stays and blocks
+func formatTime(t time.Time) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// fieldName turns a Go field name into the column style used in the log, e.g. RoomID into room_id
+func fieldName(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
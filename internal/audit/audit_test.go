@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	arrival := time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	res := models.Reservation{
+		ID:        7,
+		RoomID:    1,
+		Status:    models.StatusPending,
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 2),
+		CreatedAt: time.Now(),
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	changed := res
+	changed.FirstName = "Jon"
+	changed.Status = models.StatusConfirmed
+	changed.UpdatedAt = time.Now()
+	changed.Room = models.Room{ID: 2}
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		expected      []models.AuditChange
+	}{
+		{"update", res, changed, []models.AuditChange{
+			{Field: "status", Before: "pending", After: "confirmed"},
+			{Field: "first_name", Before: "John", After: "Jon"},
+		}},
+		{"unchanged", res, res, nil},
+		{"create", nil, models.RoomRate{RoomID: 1, Name: "Festival", StartDate: arrival, Yearly: true}, []models.AuditChange{
+			{Field: "room_id", After: "1"},
+			{Field: "name", After: "Festival"},
+			{Field: "start_date", After: "2050-01-03"},
+			{Field: "yearly", After: "true"},
+		}},
+		{"delete", &models.RecurringBlock{RoomID: 2, Repeat: models.RepeatWeekly, Weekday: time.Monday}, nil, []models.AuditChange{
+			{Field: "room_id", Before: "2", After: ""},
+			{Field: "repeat", Before: "weekly", After: ""},
+			{Field: "weekday", Before: "Monday", After: ""},
+		}},
+		{"zero_values", models.User{Active: true, AccessLevel: 2}, models.User{AccessLevel: 0}, []models.AuditChange{
+			{Field: "access_level", Before: "2", After: "0"},
+			{Field: "active", Before: "true", After: "false"},
+		}},
+		{"tags", models.Guest{Tags: []string{"VIP"}}, models.Guest{Tags: []string{"VIP", "Corporate"}}, []models.AuditChange{
+			{Field: "tags", Before: "VIP", After: "VIP, Corporate"},
+		}},
+		{"secrets", models.User{Password: "old"}, models.User{Password: "new"}, nil},
+		{"nothing", nil, nil, nil},
+	}
+
+	for _, e := range tests {
+		got := Diff(e.before, e.after)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("Failed %s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"FirstName":    "first_name",
+		"RoomID":       "room_id",
+		"URL":          "url",
+		"LastSyncedAt": "last_synced_at",
+		"Status":       "status",
+	}
+	for in, expected := range tests {
+		if got := fieldName(in); got != expected {
+			t.Errorf("fieldName(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
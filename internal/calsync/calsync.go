@@ -0,0 +1,232 @@
+// Package calsync imports the iCalendar feeds of outside booking sites as external bookings,
+// so a room booked elsewhere is no longer offered here
+package calsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/ical"
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// maxFeedSize is the largest feed read from an outside site, in bytes
+const maxFeedSize = 5 << 20
+
+// Store is the part of the repository the syncer needs
+type Store interface {
+	AllCalendarFeeds() ([]models.CalendarFeed, error)
+	UpdateCalendarFeedSync(f models.CalendarFeed) error
+	ExternalRestrictions(feedID int) ([]models.RoomRestriction, error)
+	SaveExternalBooking(r models.RoomRestriction) ([]string, error)
+	DeleteBlockById(id int) error
+}
+
+// Result counts the changes one sync made to a room's restrictions
+type Result struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+}
+
+// Conflict is an imported booking that overlaps reservations made here
+type Conflict struct {
+	UID   string
+	Start time.Time
+	End   time.Time
+	// Reservations are the confirmation codes of the reservations it overlaps
+	Reservations []string
+}
+
+// ConflictError reports the imported bookings that double book the room. They are imported all
+// the same, since the room is taken at the outside site; the reservations have to be sorted out
+// by hand.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	stays := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		stays[i] = fmt.Sprintf("%s (%s to %s) overlaps reservation %s", c.UID,
+			c.Start.Format("2006-01-02"), c.End.Format("2006-01-02"), strings.Join(c.Reservations, ", "))
+	}
+	return "double booked: " + strings.Join(stays, "; ")
+}
+
+// Syncer fetches calendar feeds and reconciles their events with the external bookings
+// imported from them before
+type Syncer struct {
+	Store    Store
+	Client   *http.Client
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	// Now returns the current time; bookings that ended before today are left alone
+	Now func() time.Time
+}
+
+// New returns a syncer that fetches feeds with a 30 second timeout
+func New(store Store, infoLog, errorLog *log.Logger) *Syncer {
+	return &Syncer{
+		Store:    store,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		InfoLog:  infoLog,
+		ErrorLog: errorLog,
+		Now:      time.Now,
+	}
+}
+
+// Run syncs every feed straight away and then every interval, until ctx is done
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.SyncAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every feed. A feed that fails is recorded and logged without stopping the others.
+func (s *Syncer) SyncAll() {
+	feeds, err := s.Store.AllCalendarFeeds()
+	if err != nil {
+		s.ErrorLog.Println("Cannot load calendar feeds:", err)
+		return
+	}
+	for _, f := range feeds {
+		res, err := s.SyncFeed(f)
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			s.ErrorLog.Printf("Calendar feed %d (%s) synced: %v", f.ID, f.Name, err)
+			continue
+		}
+		if err != nil {
+			s.ErrorLog.Printf("Calendar feed %d (%s) failed to sync: %v", f.ID, f.Name, err)
+			continue
+		}
+		if res != (Result{}) {
+			s.InfoLog.Printf("Calendar feed %d (%s) synced: %d added, %d moved, %d removed",
+				f.ID, f.Name, res.Inserted, res.Updated, res.Deleted)
+		}
+	}
+}
+
+// SyncFeed fetches a feed and brings its external bookings up to date: new events are inserted,
+// moved events get their new dates and events that were cancelled or are no longer published are
+// deleted. The outcome is recorded on the feed. If the feed cannot be fetched or read, nothing is
+// changed, so an outage at the outside site never frees up rooms that are booked there. Bookings
+// inserted or moved onto reservations made here are returned as a *ConflictError.
+func (s *Syncer) SyncFeed(f models.CalendarFeed) (Result, error) {
+	var res Result
+	f.LastSyncedAt = s.Now()
+	events, err := s.fetch(f.URL)
+	if err == nil {
+		res, f.EventCount, err = s.reconcile(f, events)
+	}
+	f.LastError = ""
+	if err != nil {
+		f.LastError = err.Error()
+	}
+	if dbErr := s.Store.UpdateCalendarFeedSync(f); dbErr != nil && err == nil {
+		err = dbErr
+	}
+	return res, err
+}
+
+func (s *Syncer) fetch(url string) ([]ical.Event, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ical.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// reconcile applies the events of a feed to the bookings imported from it before and returns
+// the changes made and the number of current events, with a *ConflictError once every event is
+// applied if any double booked the room
+func (s *Syncer) reconcile(f models.CalendarFeed, events []ical.Event) (Result, int, error) {
+	var res Result
+	existing, err := s.Store.ExternalRestrictions(f.ID)
+	if err != nil {
+		return res, 0, err
+	}
+	byUID := make(map[string]models.RoomRestriction, len(existing))
+	for _, r := range existing {
+		byUID[r.ExternalUID] = r
+	}
+
+	now := s.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	seen := make(map[string]bool, len(events))
+	count := 0
+	var conflicts []Conflict
+	for _, e := range events {
+		if e.Status == ical.StatusCancelled || seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+		// sites stop publishing past stays; the history is kept, but not added to
+		if !e.End.After(today) {
+			continue
+		}
+		count++
+		var clashes []string
+		r, ok := byUID[e.UID]
+		switch {
+		case !ok:
+			clashes, err = s.Store.SaveExternalBooking(models.RoomRestriction{
+				RoomID:        f.RoomID,
+				RestrictionID: models.RestrictionExternalBooking,
+				StartDate:     e.Start,
+				EndDate:       e.End,
+				FeedID:        f.ID,
+				ExternalUID:   e.UID,
+			})
+			res.Inserted++
+		case !r.StartDate.Equal(e.Start) || !r.EndDate.Equal(e.End):
+			r.StartDate, r.EndDate = e.Start, e.End
+			clashes, err = s.Store.SaveExternalBooking(r)
+			res.Updated++
+		}
+		if err != nil {
+			return res, count, err
+		}
+		if len(clashes) > 0 {
+			conflicts = append(conflicts, Conflict{UID: e.UID, Start: e.Start, End: e.End, Reservations: clashes})
+		}
+	}
+
+	for uid, r := range byUID {
+		if seen[uid] || !r.EndDate.After(today) {
+			continue
+		}
+		err = s.Store.DeleteBlockById(r.ID)
+		if err != nil {
+			return res, count, err
+		}
+		res.Deleted++
+	}
+	if len(conflicts) > 0 {
+		return res, count, &ConflictError{Conflicts: conflicts}
+	}
+	return res, count, nil
+}
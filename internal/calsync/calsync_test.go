@@ -0,0 +1,291 @@
+package calsync
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// memStore keeps restrictions and feeds in memory, and the reservations made here by their
+// confirmation codes
+type memStore struct {
+	feeds        []models.CalendarFeed
+	restrictions map[int]models.RoomRestriction
+	reservations map[string]models.Reservation
+	nextID       int
+}
+
+func newMemStore(feeds ...models.CalendarFeed) *memStore {
+	return &memStore{feeds: feeds, restrictions: make(map[int]models.RoomRestriction)}
+}
+
+func (m *memStore) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	return m.feeds, nil
+}
+
+func (m *memStore) UpdateCalendarFeedSync(f models.CalendarFeed) error {
+	for i := range m.feeds {
+		if m.feeds[i].ID == f.ID {
+			m.feeds[i] = f
+		}
+	}
+	return nil
+}
+
+func (m *memStore) ExternalRestrictions(feedID int) ([]models.RoomRestriction, error) {
+	var rs []models.RoomRestriction
+	for _, r := range m.restrictions {
+		if r.FeedID == feedID {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (m *memStore) SaveExternalBooking(r models.RoomRestriction) ([]string, error) {
+	if r.ID == 0 {
+		m.nextID++
+		r.ID = m.nextID
+	}
+	m.restrictions[r.ID] = r
+	var codes []string
+	for code, res := range m.reservations {
+		if res.RoomID == r.RoomID && r.StartDate.Before(res.EndDate) && r.EndDate.After(res.StartDate) {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+func (m *memStore) DeleteBlockById(id int) error {
+	delete(m.restrictions, id)
+	return nil
+}
+
+// byUID returns the stored restriction imported from the event uid
+func (m *memStore) byUID(uid string) (models.RoomRestriction, bool) {
+	for _, r := range m.restrictions {
+		if r.ExternalUID == uid {
+			return r, true
+		}
+	}
+	return models.RoomRestriction{}, false
+}
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func event(uid, start, end, status string) string {
+	e := "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART;VALUE=DATE:" + start + "\r\nDTEND;VALUE=DATE:" + end + "\r\n"
+	if status != "" {
+		e += "STATUS:" + status + "\r\n"
+	}
+	return e + "END:VEVENT\r\n"
+}
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func newTestSyncer(store Store) *Syncer {
+	s := New(store, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	s.Now = func() time.Time { return time.Date(2050, 1, 5, 12, 0, 0, 0, time.UTC) }
+	return s
+}
+
+func TestSyncFeed(t *testing.T) {
+	feed := calendar(
+		event("stay-a", "20500110", "20500112", ""),
+		event("stay-b", "20500201", "20500205", ""),
+		event("gone-soon", "20500301", "20500302", "CANCELLED"),
+		event("last-year", "20490101", "20490103", ""),
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		io.WriteString(w, feed)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(models.CalendarFeed{ID: 7, RoomID: 2, URL: srv.URL})
+	s := newTestSyncer(store)
+
+	// the first sync imports the current events only
+	res, err := s.SyncFeed(store.feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Inserted: 2}) {
+		t.Errorf("first sync: expected 2 inserts, got %+v", res)
+	}
+	a, ok := store.byUID("stay-a")
+	if !ok || a.RoomID != 2 || a.FeedID != 7 || a.RestrictionID != models.RestrictionExternalBooking ||
+		!a.StartDate.Equal(day(2050, 1, 10)) || !a.EndDate.Equal(day(2050, 1, 12)) {
+		t.Errorf("first sync: unexpected restriction for stay-a %+v", a)
+	}
+	if store.feeds[0].EventCount != 2 || store.feeds[0].LastError != "" || store.feeds[0].LastSyncedAt.IsZero() {
+		t.Errorf("first sync: unexpected feed status %+v", store.feeds[0])
+	}
+
+	// syncing an unchanged feed changes nothing
+	res, err = s.SyncFeed(store.feeds[0])
+	if err != nil || res != (Result{}) {
+		t.Errorf("unchanged sync: expected no changes, got %+v, %v", res, err)
+	}
+
+	// stay-a moves, stay-b is cancelled and stay-c is new
+	feed = calendar(
+		event("stay-a", "20500111", "20500114", ""),
+		event("stay-b", "20500201", "20500205", "CANCELLED"),
+		event("stay-c", "20500401", "20500403", ""),
+	)
+	res, err = s.SyncFeed(store.feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Inserted: 1, Updated: 1, Deleted: 1}) {
+		t.Errorf("changed sync: expected one of each change, got %+v", res)
+	}
+	if moved, _ := store.byUID("stay-a"); moved.ID != a.ID || !moved.StartDate.Equal(day(2050, 1, 11)) || !moved.EndDate.Equal(day(2050, 1, 14)) {
+		t.Errorf("changed sync: expected stay-a to be moved in place, got %+v", moved)
+	}
+	if _, ok := store.byUID("stay-b"); ok {
+		t.Error("changed sync: expected the cancelled stay-b to be deleted")
+	}
+
+	// an event that disappears from the feed is deleted too
+	feed = calendar(event("stay-c", "20500401", "20500403", ""))
+	res, err = s.SyncFeed(store.feeds[0])
+	if err != nil || res != (Result{Deleted: 1}) {
+		t.Errorf("removed sync: expected one delete, got %+v, %v", res, err)
+	}
+	if len(store.restrictions) != 1 {
+		t.Errorf("removed sync: expected only stay-c to be left, got %d restrictions", len(store.restrictions))
+	}
+}
+
+func TestSyncFeedDoubleBooking(t *testing.T) {
+	feed := calendar(
+		event("stay-a", "20500111", "20500113", ""),
+		event("stay-b", "20500201", "20500203", ""),
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, feed)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(models.CalendarFeed{ID: 7, RoomID: 2, URL: srv.URL})
+	store.reservations = map[string]models.Reservation{
+		"ABCD2345": {RoomID: 2, StartDate: day(2050, 1, 10), EndDate: day(2050, 1, 12)},
+		"EFGH6789": {RoomID: 3, StartDate: day(2050, 2, 1), EndDate: day(2050, 2, 3)},
+	}
+	s := newTestSyncer(store)
+
+	// stay-a overlaps a reservation in the same room; both stays are imported all the same
+	res, err := s.SyncFeed(store.feeds[0])
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+	if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].UID != "stay-a" || len(conflict.Conflicts[0].Reservations) != 1 ||
+		conflict.Conflicts[0].Reservations[0] != "ABCD2345" {
+		t.Errorf("expected stay-a to clash with ABCD2345, got %+v", conflict.Conflicts)
+	}
+	if res != (Result{Inserted: 2}) || len(store.restrictions) != 2 {
+		t.Errorf("expected both stays imported, got %+v and %d restrictions", res, len(store.restrictions))
+	}
+	if status := store.feeds[0].LastError; !strings.Contains(status, "double booked") || !strings.Contains(status, "ABCD2345") {
+		t.Errorf("expected the double booking on the feed's status, got %q", status)
+	}
+
+	// once stay-a moves off the reservation and stay-b onto one, only stay-b is reported
+	feed = calendar(
+		event("stay-a", "20500115", "20500117", ""),
+		event("stay-b", "20500109", "20500111", ""),
+	)
+	_, err = s.SyncFeed(store.feeds[0])
+	if !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 || conflict.Conflicts[0].UID != "stay-b" {
+		t.Errorf("expected the moved stay-b to clash, got %v", err)
+	}
+
+	// and the status clears when nothing overlaps any more
+	feed = calendar(event("stay-a", "20500115", "20500117", ""))
+	_, err = s.SyncFeed(store.feeds[0])
+	if err != nil || store.feeds[0].LastError != "" {
+		t.Errorf("expected a clean sync, got %v and status %q", err, store.feeds[0].LastError)
+	}
+}
+
+func TestSyncFeedFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"not_found", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }},
+		{"html_page", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "<html>Sign in</html>") }},
+		{"truncated", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\n")
+		}},
+	}
+
+	for _, e := range tests {
+		srv := httptest.NewServer(e.handler)
+		store := newMemStore(models.CalendarFeed{ID: 1, RoomID: 1, URL: srv.URL})
+		store.restrictions[1] = models.RoomRestriction{ID: 1, FeedID: 1, ExternalUID: "booked", StartDate: day(2050, 2, 1), EndDate: day(2050, 2, 3)}
+		store.nextID = 1
+
+		_, err := newTestSyncer(store).SyncFeed(store.feeds[0])
+		srv.Close()
+		if err == nil {
+			t.Errorf("Failed %s: expected an error", e.name)
+		}
+		if store.feeds[0].LastError == "" {
+			t.Errorf("Failed %s: expected the error to be recorded on the feed", e.name)
+		}
+		if _, ok := store.restrictions[1]; !ok {
+			t.Errorf("Failed %s: expected the imported booking to be kept", e.name)
+		}
+	}
+}
+
+// failingStore fails to load feeds
+type failingStore struct{ memStore }
+
+func (f *failingStore) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	return nil, errors.New("database is down")
+}
+
+func TestSyncAll(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/broken.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, calendar(event("stay", "20500110", "20500112", "")))
+	}))
+	defer srv.Close()
+
+	store := newMemStore(
+		models.CalendarFeed{ID: 1, RoomID: 1, URL: srv.URL + "/broken.ics"},
+		models.CalendarFeed{ID: 2, RoomID: 2, URL: srv.URL + "/ok.ics"},
+	)
+	newTestSyncer(store).SyncAll()
+	if requests != 2 {
+		t.Errorf("expected both feeds to be fetched, got %d requests", requests)
+	}
+	if store.feeds[0].LastError == "" || store.feeds[1].LastError != "" || store.feeds[1].EventCount != 1 {
+		t.Errorf("expected the first feed to fail and the second to sync, got %+v", store.feeds)
+	}
+
+	// a store that cannot list feeds is logged, not fatal
+	newTestSyncer(&failingStore{}).SyncAll()
+}
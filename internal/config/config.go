@@ -3,8 +3,10 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
-	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/payments"
 	"github.com/alexedwards/scs/v2"
 )
 
@@ -16,5 +18,56 @@ type AppConfig struct {
 	Session       *scs.SessionManager
 	ErrorLog      *log.Logger
 	InfoLog       *log.Logger
-	MailChan      chan models.MailData
+	BaseURL       string
+	SigningKey    []byte
+	Payments      payments.Gateway
+	// TrashRetention is how long deleted reservations are kept before they are purged; 0 keeps them
+	TrashRetention time.Duration
+	// Addr is the address the web server listens on, e.g. :8080
+	Addr            string
+	SessionLifetime time.Duration
+	// DSN is the database connection string
+	DSN  string
+	SMTP SMTPConfig
+	// Payment is the provider deposits are taken through; with none, bookings need no deposit
+	Payment PaymentConfig
+	// MailFrom sends the emails to guests and StaffMailFrom those to staff and the owner
+	MailFrom      string
+	StaffMailFrom string
+	// OwnerEmail is told about new, changed and cancelled bookings
+	OwnerEmail string
+	// MailMaxAttempts is how many times an email is tried before it is left as failed
+	MailMaxAttempts int
+	// CalendarSync is how often external calendar feeds are imported; 0 turns syncing off
+	CalendarSync time.Duration
+	// ReminderDays is how many days before arrival guests are reminded of their stay, and
+	// ThankYouDays how many days after departure they are thanked for it; 0 turns either off
+	ReminderDays int
+	ThankYouDays int
+	// ReviewURL is where the thank-you email asks guests to review their stay, if set
+	ReviewURL string
+	// ShutdownTimeout bounds how long stopping the app waits for requests, emails and background jobs
+	ShutdownTimeout time.Duration
+	// EmailTemplates writes the emails the app sends
+	EmailTemplates *mailer.Templates
+}
+
+// SMTPConfig is the mail server the emails are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// PaymentConfig is the provider deposits are taken through
+type PaymentConfig struct {
+	// Provider is stripe, fake for development, or empty to take no deposits
+	Provider       string
+	SecretKey      string
+	PublishableKey string
+	// WebhookSecret verifies the webhooks the provider sends
+	WebhookSecret string
+	// Currency is the ISO code deposits are charged in, e.g. usd
+	Currency string
 }
@@ -0,0 +1,336 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable Load reads, e.g. BOOKINGS_DB_DSN
+const EnvPrefix = "BOOKINGS_"
+
+// setting ties a command-line flag to its key in the config file. The environment variable is
+// EnvPrefix followed by the key in upper case.
+type setting struct {
+	flag string
+	key  string
+}
+
+// settings lists every value Load reads, in the order -help shows them
+var settings = []setting{
+	{"prod", "prod"},
+	{"cache", "cache"},
+	{"addr", "addr"},
+	{"url", "url"},
+	{"sessionlifetime", "session_lifetime"},
+	{"dsn", "db_dsn"},
+	{"dbhost", "db_host"},
+	{"dbport", "db_port"},
+	{"dbname", "db_name"},
+	{"dbuser", "db_user"},
+	{"dbpass", "db_password"},
+	{"dbssl", "db_sslmode"},
+	{"smtphost", "smtp_host"},
+	{"smtpport", "smtp_port"},
+	{"smtpuser", "smtp_username"},
+	{"smtppass", "smtp_password"},
+	{"mailfrom", "mail_from"},
+	{"staffmailfrom", "staff_mail_from"},
+	{"owneremail", "owner_email"},
+	{"mailattempts", "mail_max_attempts"},
+	{"signingkey", "signing_key"},
+	{"payments", "payment_provider"},
+	{"paymentkey", "payment_secret_key"},
+	{"paymentpublickey", "payment_publishable_key"},
+	{"webhooksecret", "payment_webhook_secret"},
+	{"currency", "payment_currency"},
+	{"calendarsync", "calendar_sync"},
+	{"trashretention", "trash_retention"},
+	{"reminderdays", "reminder_days"},
+	{"thankyoudays", "thank_you_days"},
+	{"reviewurl", "review_url"},
+	{"shutdowntimeout", "shutdown_timeout"},
+}
+
+// EnvName returns the environment variable for a config file key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// Load reads the settings of app from the command-line arguments args, the environment through
+// lookupEnv, and the config file named by -config or BOOKINGS_CONFIG, in that order of priority,
+// falling back on defaults for the development setup. It returns flag.ErrHelp for -help and an
+// error naming every invalid setting otherwise.
+func (app *AppConfig) Load(args []string, lookupEnv func(string) (string, bool)) error {
+	var db struct{ host, port, name, user, password, sslmode string }
+
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Config file, read before the environment and the flags")
+	fs.BoolVar(&app.InProd, "prod", true, "Application is in production")
+	fs.BoolVar(&app.UseCache, "cache", true, "Use template cache")
+	fs.StringVar(&app.Addr, "addr", ":8080", "Address the web server listens on")
+	fs.StringVar(&app.BaseURL, "url", "", "Public URL of the site, used for links in emails (default http://localhost and the port of -addr)")
+	fs.DurationVar(&app.SessionLifetime, "sessionlifetime", 24*time.Hour, "How long a login lasts")
+	fs.StringVar(&app.DSN, "dsn", "", "Database connection string, used instead of the -db flags when set")
+	fs.StringVar(&db.host, "dbhost", "localhost", "Database host")
+	fs.StringVar(&db.port, "dbport", "5432", "Database port number")
+	fs.StringVar(&db.name, "dbname", "", "Database name")
+	fs.StringVar(&db.user, "dbuser", "", "Database username")
+	fs.StringVar(&db.password, "dbpass", "", "Database password; prefer "+EnvName("db_password")+" or the config file, flags show up in ps")
+	fs.StringVar(&db.sslmode, "dbssl", "disable", "Database ssl setting(disable, prefer, require)")
+	fs.StringVar(&app.SMTP.Host, "smtphost", "localhost", "Mail server host")
+	fs.IntVar(&app.SMTP.Port, "smtpport", 1025, "Mail server port")
+	fs.StringVar(&app.SMTP.Username, "smtpuser", "", "Mail server username, if it needs one")
+	fs.StringVar(&app.SMTP.Password, "smtppass", "", "Mail server password")
+	fs.StringVar(&app.MailFrom, "mailfrom", "me@here.com", "Sender of the emails to guests")
+	fs.StringVar(&app.StaffMailFrom, "staffmailfrom", "", "Sender of the emails to staff (default -mailfrom)")
+	fs.StringVar(&app.OwnerEmail, "owneremail", "property@owner.com", "Where new bookings and guest changes are reported")
+	fs.IntVar(&app.MailMaxAttempts, "mailattempts", 8, "How many times an email is tried before it is left as failed")
+	signingKey := fs.String("signingkey", "", "Secret used to sign password reset links")
+	fs.StringVar(&app.Payment.Provider, "payments", "", "Payment provider deposits are taken through (stripe, or fake outside production), none to take no deposits")
+	fs.StringVar(&app.Payment.SecretKey, "paymentkey", "", "Secret API key of the payment provider; prefer "+EnvName("payment_secret_key")+" or the config file")
+	fs.StringVar(&app.Payment.PublishableKey, "paymentpublickey", "", "Publishable key the checkout form uses")
+	fs.StringVar(&app.Payment.WebhookSecret, "webhooksecret", "", "Secret the payment provider signs its webhooks with")
+	fs.StringVar(&app.Payment.Currency, "currency", "usd", "Currency deposits are charged in")
+	fs.DurationVar(&app.CalendarSync, "calendarsync", 30*time.Minute, "How often to import external calendar feeds, 0 to turn off")
+	fs.DurationVar(&app.TrashRetention, "trashretention", 30*24*time.Hour, "How long deleted reservations are kept, 0 to keep them")
+	fs.IntVar(&app.ReminderDays, "reminderdays", 3, "How many days before arrival guests are reminded of their stay, 0 to turn off")
+	fs.IntVar(&app.ThankYouDays, "thankyoudays", 1, "How many days after departure guests are thanked for their stay, 0 to turn off")
+	fs.StringVar(&app.ReviewURL, "reviewurl", "", "Where guests are asked to review their stay in the thank-you email, if anywhere")
+	fs.DurationVar(&app.ShutdownTimeout, "shutdowntimeout", 30*time.Second, "How long to wait for requests, queued emails and background jobs to finish when stopping")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	fromFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { fromFlags[f.Name] = true })
+
+	path := *configFile
+	if v, ok := lookupEnv(EnvName("config")); ok && path == "" {
+		path = v
+	}
+	fileValues := make(map[string]string)
+	if path != "" {
+		fileValues, err = readConfigFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		fileValue, inFile := fileValues[s.key]
+		delete(fileValues, s.key)
+		if fromFlags[s.flag] {
+			continue
+		}
+		source := EnvName(s.key)
+		v, ok := lookupEnv(source)
+		if !ok {
+			v, ok = fileValue, inFile
+			source = fmt.Sprintf("%s in %s", s.key, path)
+		}
+		if ok {
+			if err := fs.Set(s.flag, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not valid", source, v))
+			}
+		}
+	}
+	unknown := make([]string, 0, len(fileValues))
+	for key := range fileValues {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s: unknown setting %s", path, key))
+	}
+
+	if app.DSN == "" && (db.name != "" || db.user != "") {
+		app.DSN = fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
+			dsnValue(db.host), dsnValue(db.port), dsnValue(db.name), dsnValue(db.user), dsnValue(db.password), dsnValue(db.sslmode))
+	}
+	app.SigningKey = nil
+	if *signingKey != "" {
+		app.SigningKey = []byte(*signingKey)
+	}
+	if app.StaffMailFrom == "" {
+		app.StaffMailFrom = app.MailFrom
+	}
+	if app.BaseURL == "" {
+		port := "8080"
+		if _, p, err := net.SplitHostPort(app.Addr); err == nil && p != "" {
+			port = p
+		}
+		app.BaseURL = "http://localhost:" + port
+	}
+	app.BaseURL = strings.TrimSuffix(app.BaseURL, "/")
+
+	problems = append(problems, app.validate()...)
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// dsnValue quotes a value for a keyword=value connection string
+func dsnValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(v) + "'"
+}
+
+// validate returns a description of every setting that cannot work
+func (app *AppConfig) validate() []string {
+	var problems []string
+	if _, _, err := net.SplitHostPort(app.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("addr: %q is not a host and port such as :8080", app.Addr))
+	}
+	if u, err := url.Parse(app.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("url: %q is not an http or https URL", app.BaseURL))
+	}
+	if app.SessionLifetime <= 0 {
+		problems = append(problems, "session_lifetime: must be longer than 0")
+	}
+	if app.DSN == "" {
+		problems = append(problems, "db_dsn: the database is not set, give db_dsn or db_name and db_user")
+	}
+	if app.SMTP.Host == "" {
+		problems = append(problems, "smtp_host: the mail server is not set")
+	}
+	if app.SMTP.Port < 1 || app.SMTP.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("smtp_port: %d is not a port number", app.SMTP.Port))
+	}
+	if app.MailMaxAttempts < 1 {
+		problems = append(problems, "mail_max_attempts: must be at least 1")
+	}
+	for key, address := range map[string]string{"mail_from": app.MailFrom, "staff_mail_from": app.StaffMailFrom, "owner_email": app.OwnerEmail} {
+		if _, err := mail.ParseAddress(address); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not an email address", key, address))
+		}
+	}
+	switch app.Payment.Provider {
+	case "":
+	case "stripe":
+		if app.Payment.SecretKey == "" || app.Payment.PublishableKey == "" {
+			problems = append(problems, "payment_secret_key: stripe needs payment_secret_key and payment_publishable_key")
+		}
+	case "fake":
+		if app.InProd {
+			problems = append(problems, "payment_provider: the fake provider takes no money and cannot be used with prod")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("payment_provider: %q is not stripe or fake", app.Payment.Provider))
+	}
+	if app.Payment.Provider != "" && app.Payment.WebhookSecret == "" {
+		problems = append(problems, "payment_webhook_secret: needed to verify the payment provider's webhooks")
+	}
+	if len(app.Payment.Currency) != 3 {
+		problems = append(problems, fmt.Sprintf("payment_currency: %q is not a three-letter currency code", app.Payment.Currency))
+	}
+	if app.CalendarSync < 0 {
+		problems = append(problems, "calendar_sync: cannot be negative")
+	}
+	if app.TrashRetention < 0 {
+		problems = append(problems, "trash_retention: cannot be negative")
+	}
+	if app.ReminderDays < 0 {
+		problems = append(problems, "reminder_days: cannot be negative")
+	}
+	if app.ThankYouDays < 0 {
+		problems = append(problems, "thank_you_days: cannot be negative")
+	}
+	if u, err := url.Parse(app.ReviewURL); app.ReviewURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		problems = append(problems, fmt.Sprintf("review_url: %q is not an http or https URL", app.ReviewURL))
+	}
+	if app.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown_timeout: must be longer than 0")
+	}
+	return problems
+}
+
+// readConfigFile reads the key = value pairs of a config file written in a subset of TOML. Values
+// are strings in double or single quotes, or bare numbers and booleans. Keys under a [table]
+// header are prefixed with the table's name, so host under [smtp] is smtp_host. Lines starting
+// with # are comments.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	table := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		value, err := configValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		if table != "" {
+			key = table + "_" + key
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	return values, nil
+}
+
+// configValue unquotes a value from the config file, dropping a comment after it
+func configValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		// find the closing quote, skipping escaped ones
+		for i := 1; i < len(raw); i++ {
+			switch raw[i] {
+			case '\\':
+				i++
+			case '"':
+				if rest := strings.TrimSpace(raw[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+					return "", errors.New("unexpected text after the value")
+				}
+				return strconv.Unquote(raw[:i+1])
+			}
+		}
+		return "", errors.New("missing closing quote")
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", errors.New("missing closing quote")
+		}
+		if rest := strings.TrimSpace(raw[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", errors.New("unexpected text after the value")
+		}
+		return raw[1 : end+1], nil
+	}
+	if i := strings.Index(raw, "#"); i >= 0 {
+		raw = strings.TrimSpace(raw[:i])
+	}
+	if raw == "" {
+		return "", errors.New("missing value")
+	}
+	return raw, nil
+}
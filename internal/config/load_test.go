@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a lookupEnv function reading from vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bookings.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	var app AppConfig
+	err := app.Load([]string{"-dbname=bookings", "-dbuser=postgres"}, env(map[string]string{"BOOKINGS_DB_PASSWORD": `it's \ secret`}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Addr != ":8080" || app.BaseURL != "http://localhost:8080" || app.SessionLifetime != 24*time.Hour {
+		t.Errorf("unexpected server settings: %q, %q, %s", app.Addr, app.BaseURL, app.SessionLifetime)
+	}
+	if app.DSN != `host='localhost' port='5432' dbname='bookings' user='postgres' password='it\'s \\ secret' sslmode='disable'` {
+		t.Errorf("unexpected DSN %q", app.DSN)
+	}
+	if app.SMTP != (SMTPConfig{Host: "localhost", Port: 1025}) {
+		t.Errorf("unexpected SMTP settings %+v", app.SMTP)
+	}
+	if app.MailFrom != "me@here.com" || app.StaffMailFrom != "me@here.com" || app.OwnerEmail != "property@owner.com" {
+		t.Errorf("unexpected addresses %q, %q, %q", app.MailFrom, app.StaffMailFrom, app.OwnerEmail)
+	}
+	if !app.InProd || !app.UseCache || app.SigningKey != nil || app.CalendarSync != 30*time.Minute || app.TrashRetention != 720*time.Hour || app.ShutdownTimeout != 30*time.Second || app.MailMaxAttempts != 8 ||
+		app.ReminderDays != 3 || app.ThankYouDays != 1 || app.ReviewURL != "" || app.Payment != (PaymentConfig{Currency: "usd"}) {
+		t.Errorf("unexpected settings %+v", app)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeConfig(t, `
+# settings for the test
+addr = ":9000"
+session_lifetime = "2h"
+url = "https://example.com/"
+owner_email = 'owner@example.com' # the owner reads these
+prod = false
+
+[db]
+dsn = "postgres://file@db/bookings"
+
+[smtp]
+host = "smtp.example.com"
+port = 587
+username = "mailer"
+password = "p#ss \"quoted\""
+
+[payment]
+provider = "stripe"
+publishable_key = "pk_file"
+webhook_secret = "whsec_file"
+currency = "eur"
+`)
+	vars := map[string]string{
+		"BOOKINGS_CONFIG":             path,
+		"BOOKINGS_ADDR":               ":9100",
+		"BOOKINGS_DB_DSN":             "postgres://env@db/bookings",
+		"BOOKINGS_MAIL_FROM":          "hello@example.com",
+		"BOOKINGS_SIGNING_KEY":        "secret",
+		"BOOKINGS_CALENDAR_SYNC":      "0",
+		"BOOKINGS_PAYMENT_SECRET_KEY": "sk_env",
+	}
+	var app AppConfig
+	err := app.Load([]string{"-addr=:9200", "-staffmailfrom=staff@example.com"}, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		got, exp interface{}
+	}{
+		{"flag over env and file", app.Addr, ":9200"},
+		{"env over file", app.DSN, "postgres://env@db/bookings"},
+		{"env only", app.MailFrom, "hello@example.com"},
+		{"flag only", app.StaffMailFrom, "staff@example.com"},
+		{"file only", app.OwnerEmail, "owner@example.com"},
+		{"file duration", app.SessionLifetime, 2 * time.Hour},
+		{"file bool", app.InProd, false},
+		{"url without trailing slash", app.BaseURL, "https://example.com"},
+		{"smtp table", app.SMTP, SMTPConfig{Host: "smtp.example.com", Port: 587, Username: "mailer", Password: `p#ss "quoted"`}},
+		{"signing key", string(app.SigningKey), "secret"},
+		{"env turns sync off", app.CalendarSync, time.Duration(0)},
+		{"payment table", app.Payment, PaymentConfig{Provider: "stripe", SecretKey: "sk_env", PublishableKey: "pk_file", WebhookSecret: "whsec_file", Currency: "eur"}},
+	}
+	for _, e := range tests {
+		if e.got != e.exp {
+			t.Errorf("Failed %s: expected %v, got %v", e.name, e.exp, e.got)
+		}
+	}
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	path := writeConfig(t, "db_dsn = \"postgres://flag@db/bookings\"\n")
+	var app AppConfig
+	err := app.Load([]string{"-config", path}, env(map[string]string{"BOOKINGS_CONFIG": "missing.toml"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.DSN != "postgres://flag@db/bookings" {
+		t.Errorf("expected -config to win over BOOKINGS_CONFIG, got DSN %q", app.DSN)
+	}
+}
+
+var loadErrorTests = []struct {
+	name   string
+	args   []string
+	vars   map[string]string
+	file   string
+	expErr []string
+}{
+	{"no_database", nil, nil, "", []string{"db_dsn"}},
+	{"bad_addr", []string{"-dsn=x", "-addr=8080"}, nil, "", []string{"addr:"}},
+	{"bad_url", []string{"-dsn=x", "-url=example.com"}, nil, "", []string{"url:"}},
+	{"bad_lifetime", []string{"-dsn=x", "-sessionlifetime=0s"}, nil, "", []string{"session_lifetime"}},
+	{"bad_port", []string{"-dsn=x"}, map[string]string{"BOOKINGS_SMTP_PORT": "70000"}, "", []string{"smtp_port"}},
+	{"bad_env_value", []string{"-dsn=x"}, map[string]string{"BOOKINGS_SMTP_PORT": "twenty"}, "", []string{"BOOKINGS_SMTP_PORT", "twenty"}},
+	{"bad_email", []string{"-dsn=x", "-owneremail=owner"}, nil, "", []string{"owner_email"}},
+	{"negative_duration", []string{"-dsn=x", "-trashretention=-1h"}, nil, "", []string{"trash_retention"}},
+	{"negative_reminder", []string{"-dsn=x", "-reminderdays=-1"}, nil, "", []string{"reminder_days"}},
+	{"bad_review_url", []string{"-dsn=x"}, nil, "review_url = \"review us\"\n", []string{"review_url"}},
+	{"unknown_provider", []string{"-dsn=x", "-payments=paypal", "-webhooksecret=s"}, nil, "", []string{"payment_provider", "paypal"}},
+	{"fake_provider_in_prod", []string{"-dsn=x", "-payments=fake", "-webhooksecret=s"}, nil, "", []string{"payment_provider: the fake provider"}},
+	{"stripe_without_keys", []string{"-dsn=x", "-payments=stripe", "-webhooksecret=s"}, nil, "", []string{"payment_secret_key"}},
+	{"no_webhook_secret", []string{"-dsn=x", "-prod=false"}, nil, "[payment]\nprovider = \"fake\"\n", []string{"payment_webhook_secret"}},
+	{"bad_currency", []string{"-dsn=x", "-currency=dollars"}, nil, "", []string{"payment_currency"}},
+	{"no_mail_attempts", []string{"-dsn=x", "-mailattempts=0"}, nil, "", []string{"mail_max_attempts"}},
+	{"no_shutdown_timeout", []string{"-dsn=x"}, map[string]string{"BOOKINGS_SHUTDOWN_TIMEOUT": "0s"}, "", []string{"shutdown_timeout"}},
+	{"every_problem", []string{"-mailfrom=nobody"}, nil, "", []string{"db_dsn", "mail_from", "staff_mail_from"}},
+	{"unknown_key", []string{"-dsn=x"}, nil, "colour = \"red\"\n", []string{"unknown setting colour"}},
+	{"bad_file_value", []string{"-dsn=x"}, nil, "[smtp]\nport = lots\n", []string{"smtp_port in", "lots"}},
+	{"unclosed_quote", []string{"-dsn=x"}, nil, "addr = \":80\n", []string{":1: missing closing quote"}},
+	{"no_value", []string{"-dsn=x"}, nil, "\naddr\n", []string{":2: expected key = value"}},
+	{"missing_file", []string{"-dsn=x", "-config=missing.toml"}, nil, "", []string{"cannot read config file"}},
+	{"unknown_flag", []string{"-colour=red"}, nil, "", []string{"flag provided but not defined"}},
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, e := range loadErrorTests {
+		args := e.args
+		if e.file != "" {
+			args = append(args, "-config", writeConfig(t, e.file))
+		}
+		var app AppConfig
+		err := app.Load(args, env(e.vars))
+		if err == nil {
+			t.Errorf("Failed %s: expected an error", e.name)
+			continue
+		}
+		for _, want := range e.expErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Failed %s: expected error to contain %q, got %q", e.name, want, err)
+			}
+		}
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	var app AppConfig
+	err := app.Load([]string{"-help"}, env(nil))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+}
@@ -57,6 +57,13 @@ func (f *Form) IsEmail(field string) {
 	}
 }
 
+// Matches checks that a field has the same value as another field, e.g. a password confirmation
+func (f *Form) Matches(field, other string) {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "The values do not match")
+	}
+}
+
 // returns true if there are no errors, otherwise false
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
@@ -97,3 +97,24 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("Valid email adress returned error")
 	}
 }
+
+func TestForm_Matches(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("password", "secret123")
+	postedData.Add("password_confirm", "secret123")
+	form := New(postedData)
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		t.Error("Got an error for matching fields")
+	}
+
+	postedData.Set("password_confirm", "secret321")
+	form = New(postedData)
+	form.Matches("password_confirm", "password")
+	if form.Valid() {
+		t.Error("Got valid form for fields that do not match")
+	}
+	if form.Errors.Get("password_confirm") == "" {
+		t.Error("Expected an error on password_confirm")
+	}
+}
@@ -0,0 +1,560 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/pricing"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+const apiDateLayout = "2006-01-02"
+
+// apiEnvelope wraps every response body sent by the /api/v1 endpoints
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Meta  *apiPage    `json:"meta,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiPage describes the page of a paginated list
+type apiPage struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Pages   int `json:"pages"`
+}
+
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+type apiRoom struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	Slug             string `json:"slug"`
+	Description      string `json:"description"`
+	MaxOccupancy     int    `json:"max_occupancy"`
+	BaseRate         int    `json:"base_rate"`
+	WeekendSurcharge int    `json:"weekend_surcharge"`
+	MinNights        int    `json:"min_nights"`
+	Active           bool   `json:"active"`
+}
+
+type apiAvailability struct {
+	RoomID     int    `json:"room_id"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+	Available  bool   `json:"available"`
+	TotalPrice int    `json:"total_price,omitempty"`
+	MinNights  int    `json:"min_nights,omitempty"`
+}
+
+type apiReservation struct {
+	ID               int       `json:"id"`
+	ConfirmationCode string    `json:"confirmation_code"`
+	RoomID           int       `json:"room_id"`
+	RoomName         string    `json:"room_name"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	Phone            string    `json:"phone"`
+	StartDate        string    `json:"start_date"`
+	EndDate          string    `json:"end_date"`
+	TotalPrice       int       `json:"total_price"`
+	PaymentStatus    string    `json:"payment_status"`
+	AmountPaid       int       `json:"amount_paid"`
+	Status           string    `json:"status"`
+	Processed        bool      `json:"processed"`
+	Cancelled        bool      `json:"cancelled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	// PaymentURL is where the guest pays the deposit of a reservation just created, if one is due
+	PaymentURL string `json:"payment_url,omitempty"`
+}
+
+// apiReservationInput is the request body accepted when creating or updating a reservation
+type apiReservationInput struct {
+	RoomID    int    `json:"room_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func newAPIRoom(rm models.Room) apiRoom {
+	return apiRoom{
+		ID:               rm.ID,
+		Name:             rm.RoomName,
+		Slug:             rm.Slug,
+		Description:      rm.Description,
+		MaxOccupancy:     rm.MaxOccupancy,
+		BaseRate:         rm.BaseRate,
+		WeekendSurcharge: rm.WeekendSurcharge,
+		MinNights:        rm.MinNights,
+		Active:           rm.Active,
+	}
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:               res.ID,
+		ConfirmationCode: res.ConfirmationCode,
+		RoomID:           res.RoomID,
+		RoomName:         res.Room.RoomName,
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		StartDate:        res.StartDate.Format(apiDateLayout),
+		EndDate:          res.EndDate.Format(apiDateLayout),
+		TotalPrice:       res.TotalPrice,
+		PaymentStatus:    res.PaymentStatus,
+		AmountPaid:       res.AmountPaid,
+		Status:           res.Status,
+		Processed:        res.Status != models.StatusPending,
+		Cancelled:        res.Cancelled(),
+		CreatedAt:        res.CreatedAt,
+		UpdatedAt:        res.UpdatedAt,
+	}
+}
+
+// writeJSON sends data wrapped in the standard envelope with the given status code
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	writeEnvelope(w, status, apiEnvelope{Data: data})
+}
+
+// writeJSONPage sends one page of a list, with the page's meta
+func writeJSONPage(w http.ResponseWriter, data interface{}, page *apiPage) {
+	writeEnvelope(w, http.StatusOK, apiEnvelope{Data: data, Meta: page})
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, envelope apiEnvelope) {
+	out, err := json.MarshalIndent(envelope, "", "     ")
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, "unable to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// ErrorJSON sends an error envelope with the given status code and message
+func ErrorJSON(w http.ResponseWriter, status int, message string) {
+	writeErrorEnvelope(w, &apiError{Status: status, Message: message})
+}
+
+// validationErrorJSON sends a 422 error envelope listing the invalid fields
+func validationErrorJSON(w http.ResponseWriter, form *forms.Form) {
+	writeErrorEnvelope(w, &apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "validation failed",
+		Fields:  form.Errors,
+	})
+}
+
+func writeErrorEnvelope(w http.ResponseWriter, e *apiError) {
+	out, _ := json.MarshalIndent(apiEnvelope{Error: e}, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(out)
+}
+
+// serverErrorJSON logs err and sends a generic 500 error envelope
+func (rep *Repository) serverErrorJSON(w http.ResponseWriter, err error) {
+	rep.App.ErrorLog.Println(err)
+	ErrorJSON(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// parseDateRange reads the start and end query parameters and checks that end is after start
+func parseDateRange(q url.Values) (time.Time, time.Time, error) {
+	start, err := time.Parse(apiDateLayout, q.Get("start"))
+	if err != nil {
+		return start, start, errors.New("start must be a date in the format YYYY-MM-DD")
+	}
+	end, err := time.Parse(apiDateLayout, q.Get("end"))
+	if err != nil {
+		return start, end, errors.New("end must be a date in the format YYYY-MM-DD")
+	}
+	if !end.After(start) {
+		return start, end, errors.New("end must be after start")
+	}
+	return start, end, nil
+}
+
+// validateReservationInput runs the same checks as the reservation form against a JSON body
+func validateReservationInput(in apiReservationInput, withDates bool) *forms.Form {
+	values := url.Values{}
+	values.Set("first_name", in.FirstName)
+	values.Set("last_name", in.LastName)
+	values.Set("email", in.Email)
+	values.Set("phone", in.Phone)
+	form := forms.New(values)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	if withDates {
+		if in.RoomID <= 0 {
+			form.Errors.Add("room_id", "This field cannot be blank")
+		}
+		start, err := time.Parse(apiDateLayout, in.StartDate)
+		if err != nil {
+			form.Errors.Add("start_date", "Must be a date in the format YYYY-MM-DD")
+		}
+		end, err := time.Parse(apiDateLayout, in.EndDate)
+		if err != nil {
+			form.Errors.Add("end_date", "Must be a date in the format YYYY-MM-DD")
+		} else if !end.After(start) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+	return form
+}
+
+// urlParamID reads a numeric id from the named chi URL parameter
+func urlParamID(r *http.Request, name string) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return id, nil
+}
+
+// APIRooms returns every room
+func (rep *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := rep.DB.AllRooms()
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	out := make([]apiRoom, 0, len(rooms))
+	for _, rm := range rooms {
+		out = append(out, newAPIRoom(rm))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIRoom returns a single room by id
+func (rep *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	room, err := rep.DB.GetRoomById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorJSON(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIRoom(room))
+}
+
+// APIAvailability returns the rooms that are free for the start and end query parameters
+func (rep *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rooms, err := rep.DB.SearchAvailabilityAllRooms(start, end)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	out := make([]apiRoom, 0, len(rooms))
+	for _, rm := range rooms {
+		out = append(out, newAPIRoom(rm))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIRoomAvailability reports whether a single room is free for the start and end query parameters
+func (rep *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start, end, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	available, err := rep.DB.SearchAvailabilityByRoomID(start, end, id)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	out := apiAvailability{
+		RoomID:    id,
+		StartDate: start.Format(apiDateLayout),
+		EndDate:   end.Format(apiDateLayout),
+		Available: available,
+	}
+	if available {
+		room, err := rep.DB.GetRoomById(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			ErrorJSON(w, http.StatusNotFound, "room not found")
+			return
+		}
+		if err != nil {
+			rep.serverErrorJSON(w, err)
+			return
+		}
+		q, err := rep.quote(room, start, end)
+		var minStay pricing.MinStayError
+		if errors.As(err, &minStay) {
+			out.Available = false
+			out.MinNights = minStay.MinNights
+		} else if err != nil {
+			rep.serverErrorJSON(w, err)
+			return
+		}
+		out.TotalPrice = q.Total
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// The reservation list has apiReservationsPerPage reservations on a page, unless per_page asks for
+// another number up to apiReservationsMaxPerPage
+const (
+	apiReservationsPerPage    = 25
+	apiReservationsMaxPerPage = 100
+)
+
+// APIReservations returns a page of the reservations, latest stays first. The page and per_page
+// query parameters choose the page, like the admin reservation lists.
+func (rep *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	filter := models.ReservationFilter{
+		Sort:    models.SortStartDate,
+		Desc:    true,
+		Page:    1,
+		PerPage: apiReservationsPerPage,
+	}
+	q := r.URL.Query()
+	var err error
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil || filter.Page < 1 {
+			ErrorJSON(w, http.StatusBadRequest, "page must be a positive number")
+			return
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if filter.PerPage, err = strconv.Atoi(v); err != nil || filter.PerPage < 1 || filter.PerPage > apiReservationsMaxPerPage {
+			ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("per_page must be from 1 to %d", apiReservationsMaxPerPage))
+			return
+		}
+	}
+	page, err := rep.DB.SearchReservations(filter)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	out := make([]apiReservation, 0, len(page.Reservations))
+	for _, res := range page.Reservations {
+		out = append(out, newAPIReservation(res))
+	}
+	writeJSONPage(w, out, &apiPage{Page: page.Page, PerPage: page.PerPage, Total: page.Total, Pages: page.Pages()})
+}
+
+// APISearchReservations returns the reservations whose guest matches the q query parameter, best
+// matches first
+func (rep *Repository) APISearchReservations(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(q) < guestSearchMinLength {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("q must be at least %d characters", guestSearchMinLength))
+		return
+	}
+	reservations, err := rep.DB.SearchGuests(q, guestSearchLimit)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	out := make([]apiReservation, 0, len(reservations))
+	for _, res := range reservations {
+		out = append(out, newAPIReservation(res))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIReservation returns a single reservation by id
+func (rep *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := rep.DB.FetchReservationById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APICreateReservation books a room for the dates in the request body
+func (rep *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var in apiReservationInput
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "request body must be valid JSON")
+		return
+	}
+	form := validateReservationInput(in, true)
+	if !form.Valid() {
+		validationErrorJSON(w, form)
+		return
+	}
+	start, _ := time.Parse(apiDateLayout, in.StartDate)
+	end, _ := time.Parse(apiDateLayout, in.EndDate)
+	res := models.Reservation{
+		RoomID:    in.RoomID,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Email:     in.Email,
+		Phone:     in.Phone,
+		StartDate: start,
+		EndDate:   end,
+	}
+	room, err := rep.DB.GetRoomById(in.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("room_id", "Unknown room")
+		validationErrorJSON(w, form)
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	q, err := rep.quote(room, start, end)
+	var minStay pricing.MinStayError
+	if errors.As(err, &minStay) {
+		form.Errors.Add("end_date", fmt.Sprintf("Stays arriving on this date must be at least %d nights", minStay.MinNights))
+		validationErrorJSON(w, form)
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	res.TotalPrice = q.Total
+	res, err = rep.book(res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		ErrorJSON(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("room_id", "Unknown room")
+		validationErrorJSON(w, form)
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+
+	created, err := rep.DB.FetchReservationById(res.ID)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityReservation, res.ID, nil, created)
+	out := newAPIReservation(created)
+	if res.AwaitingPayment() {
+		out.PaymentURL = rep.App.BaseURL + guestReservationPath(res) + "/pay"
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", res.ID))
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// APIUpdateReservation replaces the guest details of an existing reservation
+func (rep *Repository) APIUpdateReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in apiReservationInput
+	err = json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "request body must be valid JSON")
+		return
+	}
+	form := validateReservationInput(in, false)
+	if !form.Valid() {
+		validationErrorJSON(w, form)
+		return
+	}
+	res, err := rep.DB.FetchReservationById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	before := res
+	res.FirstName = in.FirstName
+	res.LastName = in.LastName
+	res.Email = in.Email
+	res.Phone = in.Phone
+	err = rep.DB.UpdateReservation(res)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityReservation, res.ID, before, res)
+	writeJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APIDeleteReservation moves a reservation to the trash, which frees its dates
+func (rep *Repository) APIDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := rep.DB.FetchReservationById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return
+	}
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	u, _ := helpers.AuthUser(r)
+	err = rep.DB.DeleteReservation(id, u.ID)
+	if err != nil {
+		rep.serverErrorJSON(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityReservation, id, res, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var apiTests = []struct {
+	name               string
+	url                string
+	method             string
+	body               string
+	expectedStatusCode int
+	expectError        bool
+}{
+	{"rooms", "/api/v1/rooms", "GET", "", http.StatusOK, false},
+	{"room", "/api/v1/rooms/1", "GET", "", http.StatusOK, false},
+	{"room_not_found", "/api/v1/rooms/9", "GET", "", http.StatusNotFound, true},
+	{"room_bad_id", "/api/v1/rooms/abc", "GET", "", http.StatusBadRequest, true},
+	{"room_availability", "/api/v1/rooms/1/availability?start=2050-01-01&end=2050-01-02", "GET", "", http.StatusOK, false},
+	{"room_availability_bad_dates", "/api/v1/rooms/1/availability?start=2050-01-02&end=2050-01-01", "GET", "", http.StatusBadRequest, true},
+	{"room_availability_db_error", "/api/v1/rooms/1000/availability?start=2050-01-01&end=2050-01-02", "GET", "", http.StatusInternalServerError, true},
+	{"availability", "/api/v1/availability?start=2050-01-01&end=2050-01-02", "GET", "", http.StatusOK, false},
+	{"availability_missing_dates", "/api/v1/availability", "GET", "", http.StatusBadRequest, true},
+	{"availability_db_error", "/api/v1/availability?start=2000-01-01&end=2000-01-02", "GET", "", http.StatusInternalServerError, true},
+	{"reservations", "/api/v1/reservations", "GET", "", http.StatusOK, false},
+	{"reservations_bad_page", "/api/v1/reservations?page=0", "GET", "", http.StatusBadRequest, true},
+	{"reservations_per_page_too_large", "/api/v1/reservations?per_page=1000", "GET", "", http.StatusBadRequest, true},
+	{"reservation", "/api/v1/reservations/10", "GET", "", http.StatusOK, false},
+	{"search_reservations", "/api/v1/reservations/search?q=jane", "GET", "", http.StatusOK, false},
+	{"search_reservations_too_short", "/api/v1/reservations/search?q=j", "GET", "", http.StatusBadRequest, true},
+	{"search_reservations_db_error", "/api/v1/reservations/search?q=fail", "GET", "", http.StatusInternalServerError, true},
+	{"reservation_not_found", "/api/v1/reservations/2000", "GET", "", http.StatusNotFound, true},
+	{
+		"create_reservation", "/api/v1/reservations", "POST",
+		`{"room_id":1,"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02"}`,
+		http.StatusCreated, false,
+	},
+	{
+		"create_reservation_invalid", "/api/v1/reservations", "POST",
+		`{"room_id":1,"first_name":"J","last_name":"Smith","email":"john","start_date":"2050-01-01","end_date":"2050-01-02"}`,
+		http.StatusUnprocessableEntity, true,
+	},
+	{
+		"create_reservation_bad_json", "/api/v1/reservations", "POST",
+		`{"room_id":`,
+		http.StatusBadRequest, true,
+	},
+	{
+		"create_reservation_unavailable", "/api/v1/reservations", "POST",
+		`{"room_id":5,"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02"}`,
+		http.StatusConflict, true,
+	},
+	{
+		"create_reservation_db_error", "/api/v1/reservations", "POST",
+		`{"room_id":4,"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02"}`,
+		http.StatusInternalServerError, true,
+	},
+	{
+		"update_reservation", "/api/v1/reservations/10", "PUT",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","phone":"555-555-5555"}`,
+		http.StatusOK, false,
+	},
+	{
+		"update_reservation_not_found", "/api/v1/reservations/2000", "PUT",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusNotFound, true,
+	},
+	{"delete_reservation", "/api/v1/reservations/10", "DELETE", "", http.StatusNoContent, false},
+	{"delete_reservation_not_found", "/api/v1/reservations/2000", "DELETE", "", http.StatusNotFound, true},
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("For %s, expected status code %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusNoContent {
+			continue
+		}
+
+		var env apiEnvelope
+		err := json.Unmarshal(rr.Body.Bytes(), &env)
+		if err != nil {
+			t.Errorf("For %s, failed to parse json: %v", e.name, err)
+			continue
+		}
+		if e.expectError && (env.Error == nil || env.Error.Status != e.expectedStatusCode) {
+			t.Errorf("For %s, expected an error envelope with status %d, got %s", e.name, e.expectedStatusCode, rr.Body.String())
+		}
+		if !e.expectError && env.Error != nil {
+			t.Errorf("For %s, got unexpected error envelope %s", e.name, rr.Body.String())
+		}
+	}
+}
+
+func TestAPIReservationsPages(t *testing.T) {
+	routes := getRoutes()
+	// the test repo has 30 reservations
+	tests := []struct {
+		name    string
+		query   string
+		expLen  int
+		expMeta apiPage
+	}{
+		{"first_page", "", 25, apiPage{Page: 1, PerPage: 25, Total: 30, Pages: 2}},
+		{"last_page", "?page=2", 5, apiPage{Page: 2, PerPage: 25, Total: 30, Pages: 2}},
+		{"per_page", "?page=3&per_page=10", 10, apiPage{Page: 3, PerPage: 10, Total: 30, Pages: 3}},
+		{"past_the_end", "?page=4&per_page=10", 0, apiPage{Page: 4, PerPage: 10, Total: 30, Pages: 3}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/reservations"+e.query, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		var env struct {
+			Data []apiReservation `json:"data"`
+			Meta apiPage          `json:"meta"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &env)
+		if err != nil {
+			t.Errorf("Failed %s: failed to parse json: %v", e.name, err)
+			continue
+		}
+		if len(env.Data) != e.expLen {
+			t.Errorf("Failed %s: expected %d reservations, got %d", e.name, e.expLen, len(env.Data))
+		}
+		if env.Meta != e.expMeta {
+			t.Errorf("Failed %s: expected meta %+v, got %+v", e.name, e.expMeta, env.Meta)
+		}
+	}
+}
+
+func TestAPICreateReservationDeposit(t *testing.T) {
+	body := `{"room_id":1,"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02"}`
+	tests := []struct {
+		name      string
+		payments  bool
+		expPayURL bool
+		expBooked int
+	}{
+		// the guest and the owner only hear of the booking once the deposit is paid
+		{"deposit_due", true, true, 0},
+		{"no_payment_provider", false, false, 2},
+	}
+
+	for _, e := range tests {
+		if e.payments {
+			useFakePayments(t)
+		} else {
+			withoutPayments(t)
+		}
+		spy := &outboxSpy{DbRepo: Repo.DB}
+		Repo.DB = spy
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		getRoutes().ServeHTTP(rr, req)
+		Repo.DB = spy.DbRepo
+
+		if rr.Code != http.StatusCreated {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, http.StatusCreated, rr.Code)
+			continue
+		}
+		var env struct {
+			Data apiReservation `json:"data"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &env)
+		if err != nil {
+			t.Errorf("Failed %s: failed to parse json: %v", e.name, err)
+			continue
+		}
+		if hasURL := strings.HasSuffix(env.Data.PaymentURL, "/pay"); hasURL != e.expPayURL {
+			t.Errorf("Failed %s: expected a payment url %v, got %q", e.name, e.expPayURL, env.Data.PaymentURL)
+		}
+		if len(spy.booked) != e.expBooked {
+			t.Errorf("Failed %s: expected %d emails booked with the reservation, got %d", e.name, e.expBooked, len(spy.booked))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/audit"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+)
+
+// auditPageLimit is the most entries shown on the audit page; the CSV export has them all
+const auditPageLimit = 200
+
+// audit records that the logged in user did action to an entity, with the fields that changed
+// between before and after. Either may be nil for an entity that was created or removed. The
+// change has already been made by then, so an entry that cannot be saved is only logged.
+func (rep *Repository) audit(r *http.Request, action, entityType string, entityID int, before, after interface{}) {
+	userID := rep.App.Session.GetInt(r.Context(), "user_id")
+	if u, ok := helpers.AuthUser(r); ok && userID == 0 {
+		// API requests are authenticated by token rather than by the session
+		userID = u.ID
+	}
+	err := rep.DB.InsertAuditEntry(models.AuditEntry{
+		UserID:     userID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    audit.Diff(before, after),
+	})
+	if err != nil {
+		rep.App.ErrorLog.Printf("could not record %s of %s %d: %s", action, entityType, entityID, err)
+	}
+}
+
+// AdminAudit shows the audit log, filtered by the q, user, entity, entity_id, from and to query
+// parameters
+func (rep *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	filter, ok := auditFilterFromQuery(r.URL.Query())
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	filter.Limit = auditPageLimit
+	entries, err := rep.DB.AuditEntries(filter)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	users, err := rep.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["users"] = users
+	data["entities"] = models.AuditEntities
+	stringMap := make(map[string]string)
+	for _, key := range []string{"q", "entity", "entity_id", "from", "to"} {
+		stringMap[key] = r.URL.Query().Get(key)
+	}
+	stringMap["export"] = "/admin/audit.csv?" + r.URL.RawQuery
+	intMap := make(map[string]int)
+	intMap["user"] = filter.UserID
+	intMap["limit"] = auditPageLimit
+	render.Template(w, "admin_audit.page.tmpl", r, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// AdminAuditCSV exports the audit log as CSV, taking the same filters as AdminAudit. Every change
+// is a row of its own, so the file can be sorted and filtered in a spreadsheet.
+func (rep *Repository) AdminAuditCSV(w http.ResponseWriter, r *http.Request) {
+	filter, ok := auditFilterFromQuery(r.URL.Query())
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	entries, err := rep.DB.AuditEntries(filter)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "user_id", "user", "action", "entity", "entity_id", "field", "before", "after"})
+	for _, e := range entries {
+		row := []string{
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(e.UserID),
+			csvSafe(strings.TrimSpace(e.User.FirstName + " " + e.User.LastName)),
+			e.Action,
+			e.EntityType,
+			strconv.Itoa(e.EntityID),
+		}
+		if len(e.Changes) == 0 {
+			cw.Write(append(row, "", "", ""))
+			continue
+		}
+		for _, c := range e.Changes {
+			cw.Write(append(row, c.Field, csvSafe(c.Before), csvSafe(c.After)))
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		rep.App.ErrorLog.Println(err)
+	}
+}
+
+// csvSafe stops a value typed by a guest or user, such as a name, from being run as a formula
+// when the export is opened in a spreadsheet
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// auditFilterFromQuery reads the audit log filters from the query string. It reports false if one
+// of them is malformed.
+func auditFilterFromQuery(q url.Values) (models.AuditFilter, bool) {
+	filter := models.AuditFilter{
+		Query:      strings.TrimSpace(q.Get("q")),
+		EntityType: q.Get("entity"),
+	}
+	var err error
+	if v := q.Get("user"); v != "" {
+		if filter.UserID, err = strconv.Atoi(v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("entity_id"); v != "" {
+		if filter.EntityID, err = strconv.Atoi(v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse("2006-01-02", v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse("2006-01-02", v); err != nil {
+			return filter, false
+		}
+	}
+	return filter, true
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/repository"
+)
+
+// auditSpy keeps the audit entries written through it, passing everything else to the test repo
+type auditSpy struct {
+	repository.DbRepo
+	entries []models.AuditEntry
+}
+
+func (s *auditSpy) InsertAuditEntry(e models.AuditEntry) error {
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+var auditedActionTests = []struct {
+	name       string
+	method     string
+	url        string
+	postedData url.Values
+	// expAction is "" when nothing may be recorded
+	expAction string
+	expEntity string
+	expID     int
+	expChange models.AuditChange
+}{
+	{
+		"edit_reservation", "POST", "/admin/reservations/all/13",
+		url.Values{"first_name": {"Jane"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
+		models.AuditUpdate, models.EntityReservation, 13, models.AuditChange{Field: "first_name", Before: "John", After: "Jane"},
+	},
+	{
+		"change_status", "POST", "/admin/reservations/all/5/status", url.Values{"status": {"confirmed"}},
+		models.AuditStatusChange, models.EntityReservation, 5, models.AuditChange{Field: "status", Before: "pending", After: "confirmed"},
+	},
+	{
+		"change_status_not_allowed", "POST", "/admin/reservations/all/5/status", url.Values{"status": {"checked_out"}},
+		"", "", 0, models.AuditChange{},
+	},
+	{
+		"delete_reservation", "GET", "/admin/delete_reservation/all/13/do", nil,
+		models.AuditDelete, models.EntityReservation, 13, models.AuditChange{Field: "first_name", Before: "John"},
+	},
+	{"restore_reservation", "POST", "/admin/reservations_trash/14/restore", url.Values{}, models.AuditRestore, models.EntityReservation, 14, models.AuditChange{}},
+	{"resend_email", "POST", "/admin/emails/1/resend", url.Values{}, models.AuditResend, models.EntityEmail, 1, models.AuditChange{}},
+	{"resend_email_unknown", "POST", "/admin/emails/99/resend", url.Values{}, "", "", 0, models.AuditChange{}},
+	{
+		"add_block", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-03-01"}, "block_end": {"2050-03-02"}, "note": {"Renovation"}},
+		models.AuditCreate, models.EntityBlock, 3, models.AuditChange{Field: "note", After: "Renovation"},
+	},
+	{
+		"add_block_taken", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2060-03-01"}, "block_end": {"2060-03-02"}},
+		"", "", 0, models.AuditChange{},
+	},
+	{
+		"move_block", "POST", "/admin/blocks/2",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-01-10"}, "block_end": {"2050-01-14"}, "note": {"Painting"}},
+		models.AuditUpdate, models.EntityBlock, 2, models.AuditChange{Field: "end_date", Before: "2050-01-13", After: "2050-01-15"},
+	},
+	{"delete_block", "GET", "/admin/delete_block/2/do", nil, models.AuditDelete, models.EntityBlock, 2, models.AuditChange{Field: "note", Before: "Painting"}},
+	{
+		"delete_recurring_block", "GET", "/admin/delete_recurring_block/1/do", nil,
+		models.AuditDelete, models.EntityRecurringBlock, 1, models.AuditChange{Field: "note", Before: "Deep clean"},
+	},
+	{
+		"deactivate_user", "POST", "/admin/users/2/deactivate", url.Values{},
+		models.AuditDeactivate, models.EntityUser, 2, models.AuditChange{Field: "active", Before: "true", After: "false"},
+	},
+	{"revoke_api_token", "POST", "/admin/api_tokens/4/revoke", url.Values{}, models.AuditRevoke, models.EntityAPIToken, 4, models.AuditChange{}},
+}
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	spy := &auditSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	routes := getRoutes()
+	for _, e := range auditedActionTests {
+		spy.entries = nil
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		routes.ServeHTTP(httptest.NewRecorder(), req)
+
+		if e.expAction == "" {
+			if len(spy.entries) != 0 {
+				t.Errorf("Failed %s: expected nothing to be audited, got %+v", e.name, spy.entries)
+			}
+			continue
+		}
+		if len(spy.entries) != 1 {
+			t.Errorf("Failed %s: expected one audit entry, got %d", e.name, len(spy.entries))
+			continue
+		}
+		got := spy.entries[0]
+		if got.UserID != 1 || got.Action != e.expAction || got.EntityType != e.expEntity || got.EntityID != e.expID {
+			t.Errorf("Failed %s: expected user 1 to %s %s %d, got %+v", e.name, e.expAction, e.expEntity, e.expID, got)
+		}
+		if e.expChange.Field == "" {
+			continue
+		}
+		found := false
+		for _, c := range got.Changes {
+			if c == e.expChange {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Failed %s: expected change %+v, got %+v", e.name, e.expChange, got.Changes)
+		}
+	}
+}
+
+var adminAuditTests = []struct {
+	name          string
+	url           string
+	expStatusCode int
+	expHTML       []string
+	notExpHTML    []string
+}{
+	{"all", "/admin/audit", http.StatusOK, []string{"reservation 10", "Jon, Jr.", "block 2", "Front Desk"}, nil},
+	{"search", "/admin/audit?q=painting", http.StatusOK, []string{"Painting"}, []string{"Jon, Jr."}},
+	{"by_entity", "/admin/audit?entity=reservation", http.StatusOK, []string{"reservation 10"}, []string{"block 2"}},
+	{"by_user", "/admin/audit?user=2", http.StatusOK, []string{"block 2"}, []string{"reservation 10"}},
+	{"export_keeps_filters", "/admin/audit?q=painting&entity=block", http.StatusOK, []string{"/admin/audit.csv?q=painting&amp;entity=block"}, nil},
+	{"bad_date", "/admin/audit?from=yesterday", http.StatusBadRequest, nil, nil},
+	{"bad_user", "/admin/audit?user=me", http.StatusBadRequest, nil, nil},
+}
+
+func TestAdminAudit(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range adminAuditTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		for _, want := range e.expHTML {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Failed %s: expected page to contain %q", e.name, want)
+			}
+		}
+		for _, unwanted := range e.notExpHTML {
+			if strings.Contains(rr.Body.String(), unwanted) {
+				t.Errorf("Failed %s: expected page not to contain %q", e.name, unwanted)
+			}
+		}
+	}
+}
+
+func TestAdminAuditCSV(t *testing.T) {
+	routes := getRoutes()
+	req, _ := http.NewRequest("GET", "/admin/audit.csv?entity=reservation", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected a text/csv response, got %s", ct)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV, got %s", err)
+	}
+	expected := [][]string{
+		{"time", "user_id", "user", "action", "entity", "entity_id", "field", "before", "after"},
+		{"2050-01-02T10:00:00Z", "1", "Admin User", "update", "reservation", "10", "first_name", "John", "Jon, Jr."},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d rows, got %v", len(expected), records)
+	}
+	for i := range expected {
+		if strings.Join(records[i], "|") != strings.Join(expected[i], "|") {
+			t.Errorf("row %d: expected %v, got %v", i, expected[i], records[i])
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/audit.csv?to=soon", nil)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected code %d for a bad date, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := map[string]string{
+		"John":              "John",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"":                  "",
+	}
+	for in, expected := range tests {
+		if got := csvSafe(in); got != expected {
+			t.Errorf("csvSafe(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// calendarDayLayout formats the days of the reservations calendar in form field names and maps
+const calendarDayLayout = "2006-01-2"
+
+// calendarCell is one cell of a room's row on the reservations calendar. An owner block covers
+// all of its days in the month with a single cell; every other cell is one day. Blocks expanded
+// from a recurring block have no ID and are edited through their rule instead.
+type calendarCell struct {
+	// Date is the first day of the month covered by the cell
+	Date          string
+	Span          int
+	ReservationID int
+	External      bool
+	Block         models.RoomRestriction
+	// BlockKey is the start date of the block, which may be in an earlier month. It names the
+	// block's checkbox and its entry in the block map.
+	BlockKey string
+}
+
+// calendarCells lays out a room's restrictions for the month from first to last. It also returns
+// the block map AdminPostReservationsCalendar uses to find the blocks that were unticked.
+func calendarCells(first, last time.Time, restrictions []models.RoomRestriction) ([]calendarCell, map[string]int) {
+	blockMap := make(map[string]int)
+	index := make(map[string]int)
+	var days []calendarCell
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		index[d.Format(calendarDayLayout)] = len(days)
+		blockMap[d.Format(calendarDayLayout)] = 0
+		days = append(days, calendarCell{Date: d.Format(calendarDayLayout), Span: 1})
+	}
+
+	// mark calls f for the index of every day of the month from start to end
+	mark := func(start, end time.Time, f func(i int)) {
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			if i, ok := index[d.Format(calendarDayLayout)]; ok {
+				f(i)
+			}
+		}
+	}
+	// blocks holds the block covering each day: its ID, or minus its rule's ID if it is recurring
+	blocks := make([]int, len(days))
+	for _, y := range restrictions {
+		switch {
+		case y.RestrictionID == models.RestrictionExternalBooking:
+			// booked on an outside site; kept out of the block map so it cannot be unticked
+			mark(y.StartDate, y.EndDate, func(i int) { days[i].External = true })
+		case y.ReservationID != 0:
+			// the check-out day is shown as reserved too
+			mark(y.StartDate, y.EndDate.AddDate(0, 0, 1), func(i int) { days[i].ReservationID = y.ReservationID })
+		case y.RecurringBlockID != 0:
+			// kept out of the block map too; removing it means changing the rule
+			mark(y.StartDate, y.EndDate, func(i int) {
+				if blocks[i] == 0 {
+					days[i].Block = y
+					blocks[i] = -y.RecurringBlockID
+				}
+			})
+		default:
+			key := y.StartDate.Format(calendarDayLayout)
+			blockMap[key] = y.ID
+			mark(y.StartDate, y.EndDate, func(i int) {
+				days[i].Block = y
+				days[i].BlockKey = key
+				blocks[i] = y.ID
+			})
+		}
+	}
+
+	var cells []calendarCell
+	for i := 0; i < len(days); i++ {
+		cell := days[i]
+		if cell.ReservationID == 0 && !cell.External && blocks[i] != 0 {
+			for i+1 < len(days) && blocks[i+1] == blocks[i] && days[i+1].ReservationID == 0 && !days[i+1].External {
+				cell.Span++
+				i++
+			}
+		}
+		cells = append(cells, cell)
+	}
+	return cells, blockMap
+}
+
+// AdminNewBlock shows the form for blocking a room for a range of nights. The room_id and start
+// query parameters fill in the form, e.g. when coming from a day on the calendar.
+func (rep *Repository) AdminNewBlock(w http.ResponseWriter, r *http.Request) {
+	block := models.RoomRestriction{RestrictionID: models.RestrictionOwnerBlock}
+	block.RoomID, _ = strconv.Atoi(r.URL.Query().Get("room_id"))
+	stringMap := make(map[string]string)
+	if start, err := time.Parse("2006-01-02", r.URL.Query().Get("start")); err == nil {
+		stringMap["block_start"] = start.Format("2006-01-02")
+		stringMap["block_end"] = start.Format("2006-01-02")
+	}
+	rep.renderBlockForm(w, r, block, forms.New(nil), stringMap)
+}
+
+// AdminPostNewBlock blocks a room for a range of nights
+func (rep *Repository) AdminPostNewBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	block := blockFromForm(form)
+	if !form.Valid() {
+		rep.renderBlockForm(w, r, block, form, nil)
+		return
+	}
+	block.ID, err = rep.DB.InsertBlockForRoom(block.RoomID, block.StartDate, block.EndDate, block.Note)
+	if rep.blockSaveFailed(w, r, err, block, form) {
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityBlock, block.ID, nil, block)
+	rep.App.Session.Put(r.Context(), "flash", "Room blocked!")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminShowBlock shows the form for changing an owner block
+func (rep *Repository) AdminShowBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := rep.adminBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	rep.renderBlockForm(w, r, block, forms.New(nil), nil)
+}
+
+// AdminPostBlock changes the room, nights or note of an owner block
+func (rep *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	existing, ok := rep.adminBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	block := blockFromForm(form)
+	block.ID = existing.ID
+	if !form.Valid() {
+		rep.renderBlockForm(w, r, block, form, nil)
+		return
+	}
+	err = rep.DB.UpdateBlock(block)
+	if rep.blockSaveFailed(w, r, err, block, form) {
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityBlock, block.ID, existing, block)
+	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminDeleteBlock removes an owner block
+func (rep *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := rep.adminBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := rep.DB.DeleteBlockById(block.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityBlock, block.ID, block, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Block removed!")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// blockSaveFailed handles the error from saving a block. If there was one, the response has
+// been written and it returns true.
+func (rep *Repository) blockSaveFailed(w http.ResponseWriter, r *http.Request, err error, block models.RoomRestriction, form *forms.Form) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrRoomUnavailable):
+		form.Errors.Add("block_start", "The room is already reserved or blocked for some of these nights")
+		rep.renderBlockForm(w, r, block, form, nil)
+	case errors.Is(err, sql.ErrNoRows):
+		form.Errors.Add("room_id", "Choose a room")
+		rep.renderBlockForm(w, r, block, form, nil)
+	default:
+		helpers.ServerError(w, err)
+	}
+	return true
+}
+
+// blockFromForm reads the posted block form, adding an error to the form for every invalid field.
+// The form asks for the last night blocked; the block itself ends the morning after.
+func blockFromForm(form *forms.Form) models.RoomRestriction {
+	form.Required("room_id", "block_start", "block_end")
+	block := models.RoomRestriction{
+		RestrictionID: models.RestrictionOwnerBlock,
+		Note:          strings.TrimSpace(form.Get("note")),
+	}
+	var err error
+	block.RoomID, err = strconv.Atoi(form.Get("room_id"))
+	if err != nil || block.RoomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	block.StartDate, err = time.Parse("2006-01-02", form.Get("block_start"))
+	if err != nil {
+		form.Errors.Add("block_start", "Use the format YYYY-MM-DD")
+	}
+	last, err := time.Parse("2006-01-02", form.Get("block_end"))
+	if err != nil {
+		form.Errors.Add("block_end", "Use the format YYYY-MM-DD")
+	} else if last.Before(block.StartDate) {
+		form.Errors.Add("block_end", "The last night cannot be before the first")
+	}
+	block.EndDate = last.AddDate(0, 0, 1)
+	return block
+}
+
+// adminBlockFromURL loads the owner block for the {id} URL parameter. If it cannot, the response
+// has already been written and ok is false.
+func (rep *Repository) adminBlockFromURL(w http.ResponseWriter, r *http.Request) (models.RoomRestriction, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.RoomRestriction{}, false
+	}
+	block, err := rep.DB.GetBlockById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "Block not found")
+		http.Redirect(w, r, "/admin/reservations_calendar", http.StatusSeeOther)
+		return block, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return block, false
+	}
+	return block, true
+}
+
+// renderBlockForm shows the block form. Dates typed by the user are kept as typed so they can be
+// corrected; stringMap may carry defaults for them.
+func (rep *Repository) renderBlockForm(w http.ResponseWriter, r *http.Request, block models.RoomRestriction, form *forms.Form, stringMap map[string]string) {
+	rooms, err := rep.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if stringMap == nil {
+		stringMap = make(map[string]string)
+	}
+	if !block.StartDate.IsZero() {
+		stringMap["block_start"] = block.StartDate.Format("2006-01-02")
+		stringMap["block_end"] = block.EndDate.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	for _, field := range []string{"block_start", "block_end"} {
+		if form.Has(field) || form.Errors.Get(field) != "" {
+			stringMap[field] = form.Get(field)
+		}
+	}
+	data := make(map[string]interface{})
+	data["block"] = block
+	data["rooms"] = rooms
+	render.Template(w, "admin_block.page.tmpl", r, &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// blockCalendarURL is the reservations calendar for the month a block starts in
+func blockCalendarURL(block models.RoomRestriction) string {
+	return fmt.Sprintf("/admin/reservations_calendar?y=%d&m=%02d", block.StartDate.Year(), block.StartDate.Month())
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+var blockTests = []struct {
+	name          string
+	method        string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expHTML       string
+}{
+	{"new_block", "GET", "/admin/blocks/new?room_id=1&start=2050-01-10", nil, http.StatusOK, "", `value='2050-01-10'`},
+	{"show_block", "GET", "/admin/blocks/2/show", nil, http.StatusOK, "", "Painting"},
+	{"show_block_last_night", "GET", "/admin/blocks/2/show", nil, http.StatusOK, "", `value='2050-01-12'`},
+	{"show_block_unknown", "GET", "/admin/blocks/99/show", nil, http.StatusSeeOther, "/admin/reservations_calendar", ""},
+	{"show_block_bad_id", "GET", "/admin/blocks/abc/show", nil, http.StatusBadRequest, "", ""},
+	{
+		"create_block", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-02-01"}, "block_end": {"2050-02-14"}, "note": {"Renovation"}},
+		http.StatusSeeOther, "/admin/reservations_calendar?y=2050&m=02", "",
+	},
+	{
+		"create_block_one_night", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-02-01"}, "block_end": {"2050-02-01"}},
+		http.StatusSeeOther, "/admin/reservations_calendar?y=2050&m=02", "",
+	},
+	{
+		"create_block_end_before_start", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-02-14"}, "block_end": {"2050-02-01"}},
+		http.StatusOK, "", "cannot be before the first",
+	},
+	{
+		"create_block_bad_date", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"February"}, "block_end": {"2050-02-01"}},
+		http.StatusOK, "", "YYYY-MM-DD",
+	},
+	{
+		"create_block_no_room", "POST", "/admin/blocks/new",
+		url.Values{"block_start": {"2050-02-01"}, "block_end": {"2050-02-14"}},
+		http.StatusOK, "", "Choose a room",
+	},
+	{
+		"create_block_overlap", "POST", "/admin/blocks/new",
+		url.Values{"room_id": {"1"}, "block_start": {"2060-02-01"}, "block_end": {"2060-02-14"}},
+		http.StatusOK, "", "already reserved or blocked",
+	},
+	{
+		"update_block", "POST", "/admin/blocks/2",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-03-01"}, "block_end": {"2050-03-20"}, "note": {"Longer works"}},
+		http.StatusSeeOther, "/admin/reservations_calendar?y=2050&m=03", "",
+	},
+	{
+		"update_block_overlap", "POST", "/admin/blocks/2",
+		url.Values{"room_id": {"1"}, "block_start": {"2060-03-01"}, "block_end": {"2060-03-20"}},
+		http.StatusOK, "", "already reserved or blocked",
+	},
+	{
+		"update_block_unknown", "POST", "/admin/blocks/99",
+		url.Values{"room_id": {"1"}, "block_start": {"2050-03-01"}, "block_end": {"2050-03-20"}},
+		http.StatusSeeOther, "/admin/reservations_calendar", "",
+	},
+	{"delete_block", "GET", "/admin/delete_block/2/do", nil, http.StatusSeeOther, "/admin/reservations_calendar?y=2050&m=01", ""},
+	{"delete_block_unknown", "GET", "/admin/delete_block/99/do", nil, http.StatusSeeOther, "/admin/reservations_calendar", ""},
+}
+
+func TestBlocks(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range blockTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
+
+func TestCalendarCells(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) }
+	restrictions := []models.RoomRestriction{
+		// a renovation carried over from December
+		{ID: 1, RestrictionID: models.RestrictionOwnerBlock, StartDate: day(-2), EndDate: day(3), Note: "Renovation"},
+		{ID: 2, RestrictionID: models.RestrictionReservation, ReservationID: 7, StartDate: day(5), EndDate: day(7)},
+		{ID: 3, RestrictionID: models.RestrictionOwnerBlock, StartDate: day(10), EndDate: day(11)},
+		{ID: 4, RestrictionID: models.RestrictionExternalBooking, StartDate: day(20), EndDate: day(22)},
+		// a block running into February
+		{ID: 5, RestrictionID: models.RestrictionOwnerBlock, StartDate: day(30), EndDate: day(35)},
+	}
+	cells, blockMap := calendarCells(day(1), day(31), restrictions)
+
+	days := 0
+	for _, c := range cells {
+		days += c.Span
+	}
+	if days != 31 {
+		t.Errorf("expected the cells to cover 31 days, got %d", days)
+	}
+
+	// the first cell spans two days, so from the 3rd on, day n is cells[n-2]
+	tests := []struct {
+		name     string
+		cell     calendarCell
+		expDate  string
+		expSpan  int
+		expBlock int
+	}{
+		{"block_from_last_month", cells[0], "2050-01-1", 2, 1},
+		{"free_day", cells[1], "2050-01-3", 1, 0},
+		{"reservation", cells[3], "2050-01-5", 1, 0},
+		{"one_night_block", cells[8], "2050-01-10", 1, 3},
+		{"block_into_next_month", cells[len(cells)-1], "2050-01-30", 2, 5},
+	}
+	for _, e := range tests {
+		if e.cell.Date != e.expDate || e.cell.Span != e.expSpan || e.cell.Block.ID != e.expBlock {
+			t.Errorf("Failed %s: expected %s spanning %d with block %d, got %s spanning %d with block %d", e.name,
+				e.expDate, e.expSpan, e.expBlock, e.cell.Date, e.cell.Span, e.cell.Block.ID)
+		}
+	}
+	if cells[3].ReservationID != 7 || cells[5].ReservationID != 7 {
+		t.Errorf("expected the 5th to the 7th to show reservation 7, got %d and %d", cells[3].ReservationID, cells[5].ReservationID)
+	}
+	if !cells[18].External || !cells[19].External || cells[20].External {
+		t.Errorf("expected only the 20th and 21st to be external bookings, got %s to %s", cells[18].Date, cells[20].Date)
+	}
+	if cells[0].BlockKey != "2049-12-29" || blockMap["2049-12-29"] != 1 || blockMap["2050-01-10"] != 3 || blockMap["2050-01-11"] != 0 {
+		t.Errorf("expected blocks to be keyed by their first night, got %s and %v", cells[0].BlockKey, blockMap)
+	}
+}
+
+func TestCalendarCellsRecurring(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) }
+	restrictions := []models.RoomRestriction{
+		{RestrictionID: models.RestrictionOwnerBlock, RecurringBlockID: 4, StartDate: day(3), EndDate: day(4), Note: "Deep clean"},
+		{RestrictionID: models.RestrictionOwnerBlock, RecurringBlockID: 5, StartDate: day(4), EndDate: day(7)},
+		// a one-off block on a night the rule closes anyway wins, so it can still be removed
+		{ID: 6, RestrictionID: models.RestrictionOwnerBlock, StartDate: day(10), EndDate: day(11)},
+		{RestrictionID: models.RestrictionOwnerBlock, RecurringBlockID: 4, StartDate: day(10), EndDate: day(11)},
+	}
+	cells, blockMap := calendarCells(day(1), day(31), restrictions)
+
+	// the 3rd is a cell of its own, then the 4th to the 6th are one cell, so from the 7th on day n is cells[n-3]
+	if c := cells[2]; c.Span != 1 || c.Block.RecurringBlockID != 4 || c.BlockKey != "" {
+		t.Errorf("expected the 3rd to be a one night recurring block, got %+v", c)
+	}
+	if c := cells[3]; c.Date != "2050-01-4" || c.Span != 3 || c.Block.RecurringBlockID != 5 {
+		t.Errorf("expected the 4th to the 6th to be one recurring block, got %+v", c)
+	}
+	if c := cells[7]; c.Date != "2050-01-10" || c.Block.ID != 6 {
+		t.Errorf("expected the one-off block on the 10th to be shown, got %+v", c)
+	}
+	if blockMap["2050-01-3"] != 0 || blockMap["2050-01-4"] != 0 || blockMap["2050-01-10"] != 6 {
+		t.Errorf("expected recurring blocks to be kept out of the block map, got %v", blockMap)
+	}
+}
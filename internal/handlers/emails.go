@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// queueEmail writes e from its templates and puts it in the outbox for the mail worker. The change
+// it tells about has already been made, so a failure is logged rather than shown.
+func (rep *Repository) queueEmail(to, from string, e mailer.Email) {
+	msg, err := rep.App.EmailTemplates.Render(to, from, e)
+	if err != nil {
+		rep.App.ErrorLog.Printf("Cannot write the %s email to %s: %v", e.Template(), to, err)
+		return
+	}
+	err = rep.DB.QueueEmail(msg)
+	if err != nil {
+		rep.App.ErrorLog.Printf("Cannot queue the email %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
+
+// AdminFailedEmails lists the emails the mail worker gave up on
+func (rep *Repository) AdminFailedEmails(w http.ResponseWriter, r *http.Request) {
+	emails, err := rep.DB.FailedEmails()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["emails"] = emails
+	intMap := make(map[string]int)
+	intMap["max_attempts"] = rep.App.MailMaxAttempts
+	render.Template(w, "admin_emails.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminResendEmail queues a failed email again, to be tried as many times as a new one
+func (rep *Repository) AdminResendEmail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := rep.DB.ResendEmail(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		rep.App.Session.Put(r.Context(), "error", "Email not found, or it is no longer failed")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		rep.audit(r, models.AuditResend, models.EntityEmail, id, nil, nil)
+		rep.App.Session.Put(r.Context(), "flash", "Email queued to be sent again!")
+	}
+	http.Redirect(w, r, "/admin/emails", http.StatusSeeOther)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/repository"
+)
+
+// outboxSpy keeps the emails queued through it, passing everything else to the test repo. booked
+// holds the emails queued with a booking, queued the ones queued on their own.
+type outboxSpy struct {
+	repository.DbRepo
+	booked []models.MailData
+	queued []models.MailData
+}
+
+func (s *outboxSpy) BookReservation(res models.Reservation, emails []models.MailData) (int, error) {
+	id, err := s.DbRepo.BookReservation(res, emails)
+	if err == nil {
+		s.booked = append(s.booked, emails...)
+	}
+	return id, err
+}
+
+func (s *outboxSpy) QueueEmail(m models.MailData) error {
+	s.queued = append(s.queued, m)
+	return nil
+}
+
+func TestPostReservationQueuesEmailsWithBooking(t *testing.T) {
+	// without a deposit to pay the booking is confirmed straight away
+	withoutPayments(t)
+	spy := &outboxSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	postedData := url.Values{"first_name": {"<b>John</b>"}, "last_name": {"Smith"}, "email": {"john@smith.com"}}
+	for _, roomID := range []int{1, 5} {
+		req, _ := http.NewRequest("POST", "/make_reservation", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{
+			RoomID:    roomID,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		})
+		req = req.WithContext(ctx)
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// room 5 was taken in the meantime, so only the first booking queued anything
+	if len(spy.booked) != 2 || len(spy.queued) != 0 {
+		t.Fatalf("expected 2 emails queued with the booking, got %d with it and %d on their own", len(spy.booked), len(spy.queued))
+	}
+	guest, owner := spy.booked[0], spy.booked[1]
+	if guest.To != "john@smith.com" || guest.From != "me@here.com" || guest.Subject != "Reservation confirmation" || !strings.Contains(guest.Content, "/reservations/") {
+		t.Errorf("unexpected confirmation %+v", guest)
+	}
+	if strings.Contains(guest.Content, "<b>John") || !strings.Contains(guest.Text, "Dear <b>John</b>") {
+		t.Errorf("expected the guest's name escaped in the HTML version only, got %+v", guest)
+	}
+	if owner.To != "property@owner.com" || owner.Subject != "New Reservation" {
+		t.Errorf("unexpected owner notification %+v", owner)
+	}
+}
+
+func TestPostReservationWithDepositQueuesNothing(t *testing.T) {
+	spy := &outboxSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}}
+	req, _ := http.NewRequest("POST", "/make_reservation", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	req = req.WithContext(ctx)
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(httptest.NewRecorder(), req)
+
+	// the dates are held, but the guest and the owner only hear of it once the deposit is paid
+	if res := session.Get(ctx, "reservation").(models.Reservation); res.ID == 0 || !res.AwaitingPayment() {
+		t.Fatalf("expected a reservation awaiting its deposit, got %+v", res)
+	}
+	if len(spy.booked) != 0 || len(spy.queued) != 0 {
+		t.Errorf("expected no emails before the deposit is paid, got %d with the booking and %d on their own", len(spy.booked), len(spy.queued))
+	}
+}
+
+func TestPostForgotPasswordQueuesEmail(t *testing.T) {
+	spy := &outboxSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	for _, email := range []string{"me@sosmart.com", "nobody@sosmart.com"} {
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(url.Values{"email": {email}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+		http.HandlerFunc(Repo.PostForgotPassword).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(spy.queued) != 1 {
+		t.Fatalf("expected one reset email for the known address, got %+v", spy.queued)
+	}
+	if m := spy.queued[0]; m.To != "me@sosmart.com" || m.Subject != "Reset your password" {
+		t.Errorf("unexpected reset email %+v", m)
+	}
+}
+
+func TestGuestPostReservationDatesQueuesEmails(t *testing.T) {
+	spy := &outboxSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	postedData := url.Values{"start": {"2050-02-01"}, "end": {"2050-02-03"}}
+	for _, code := range []string{"UPCOMING", "PAID"} {
+		req, _ := http.NewRequest("POST", "/reservations/"+code, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+		getRoutes().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// the paid reservation keeps its dates, so only the first change queued anything
+	if len(spy.queued) != 2 {
+		t.Fatalf("expected 2 emails for the change, got %+v", spy.queued)
+	}
+	guest, owner := spy.queued[0], spy.queued[1]
+	if guest.To != "john@smith.com" || guest.Subject != "Reservation changed" || !strings.Contains(guest.Text, "/reservations/UPCOMING") {
+		t.Errorf("unexpected guest email %+v", guest)
+	}
+	if owner.To != "property@owner.com" || !strings.Contains(owner.Text, "by the guest") {
+		t.Errorf("unexpected owner email %+v", owner)
+	}
+}
+
+func TestAdminFailedEmails(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/emails", nil)
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, got %d", http.StatusOK, rr.Code)
+	}
+	for _, want := range []string{"j@smith.com", "Reservation confirmation", "connection refused", "/admin/emails/1/resend", "after 8 tries"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected page to contain %q", want)
+		}
+	}
+}
+
+var resendEmailTests = []struct {
+	name     string
+	url      string
+	expFlash string
+	expError string
+}{
+	{"resend", "/admin/emails/1/resend", "Email queued to be sent again!", ""},
+	{"unknown", "/admin/emails/99/resend", "", "Email not found"},
+	{"bad_id", "/admin/emails/abc/resend", "", "Email not found"},
+}
+
+func TestAdminResendEmail(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range resendEmailTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if location, _ := rr.Result().Location(); location.String() != "/admin/emails" {
+			t.Errorf("Failed %s: expected redirect to /admin/emails, got %s", e.name, location.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expFlash {
+			t.Errorf("Failed %s: expected flash %q, got %q", e.name, e.expFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); !strings.Contains(msg, e.expError) || (e.expError == "" && msg != "") {
+			t.Errorf("Failed %s: expected error %q, got %q", e.name, e.expError, msg)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/calsync"
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/ical"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// The room calendar feed covers stays from feedMonthsBack months ago to feedMonthsAhead months ahead
+const (
+	feedMonthsBack  = 3
+	feedMonthsAhead = 18
+)
+
+const feedProdID = "-//Fort Dowry//Bookings//EN"
+
+// RoomCalendarFeed publishes the reservations and blocks of a room as an iCalendar feed,
+// so staff can subscribe to room occupancy from their calendar apps
+func (rep *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	room, err := rep.DB.GetRoomById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	restrictions, err := rep.DB.FetchRestrictionsForRoomByDay(room.ID, today.AddDate(0, -feedMonthsBack, 0), today.AddDate(0, feedMonthsAhead, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: feedProdID,
+		Name:   room.RoomName,
+	}
+	domain := rep.feedDomain()
+	for _, rr := range restrictions {
+		cal.Events = append(cal.Events, restrictionEvent(rr, domain))
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	err = cal.Encode(w)
+	if err != nil {
+		rep.App.ErrorLog.Println(err)
+	}
+}
+
+// restrictionEvent turns a room restriction into a calendar event. A reservation's UID comes from
+// the reservation, so the event survives its dates being changed; blocks use the restriction id,
+// and nights closed by a recurring block use the rule and the first night.
+func restrictionEvent(rr models.RoomRestriction, domain string) ical.Event {
+	e := ical.Event{
+		Start:        rr.StartDate,
+		End:          rr.EndDate,
+		Created:      rr.CreatedAt,
+		LastModified: rr.UpdatedAt,
+	}
+	if rr.RestrictionID == models.RestrictionExternalBooking {
+		e.UID = fmt.Sprintf("external-%d@%s", rr.ID, domain)
+		e.Summary = "Booked elsewhere"
+		e.Categories = []string{"External Booking"}
+		return e
+	}
+	if rr.ReservationID != 0 {
+		e.UID = fmt.Sprintf("reservation-%d@%s", rr.ReservationID, domain)
+		e.Summary = fmt.Sprintf("Reserved: %s %s", rr.Reservation.FirstName, rr.Reservation.LastName)
+		e.Description = "Confirmation code " + rr.Reservation.ConfirmationCode
+		e.Categories = []string{"Reservation"}
+		return e
+	}
+	e.UID = fmt.Sprintf("block-%d@%s", rr.ID, domain)
+	if rr.RecurringBlockID != 0 {
+		e.UID = fmt.Sprintf("recurring-%d-%s@%s", rr.RecurringBlockID, rr.StartDate.Format("20060102"), domain)
+	}
+	e.Summary = "Owner block"
+	e.Description = rr.Note
+	e.Categories = []string{"Owner Block"}
+	return e
+}
+
+// feedDomain is the right-hand side of event UIDs, so they are unique across sites
+func (rep *Repository) feedDomain() string {
+	u, err := url.Parse(rep.App.BaseURL)
+	if err != nil || u.Hostname() == "" {
+		return "bookings"
+	}
+	return u.Hostname()
+}
+
+// AdminPostRoomFeed subscribes a room to the calendar feed of an outside booking site and
+// imports it straight away
+func (rep *Repository) AdminPostRoomFeed(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("feed_name", "feed_url")
+	if !form.Valid() {
+		rep.App.Session.Put(r.Context(), "error", "The calendar needs a name and a URL")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	u, err := url.Parse(form.Get("feed_url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		rep.App.Session.Put(r.Context(), "error", "The calendar URL must start with http:// or https://")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+
+	feed := models.CalendarFeed{RoomID: room.ID, Name: form.Get("feed_name"), URL: u.String()}
+	feed.ID, err = rep.DB.InsertCalendarFeed(feed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityCalendarFeed, feed.ID, nil, feed)
+	rep.syncFeed(r, feed, "Calendar added")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminSyncRoomFeed imports a room's calendar feed now instead of waiting for the next sync
+func (rep *Repository) AdminSyncRoomFeed(w http.ResponseWriter, r *http.Request) {
+	feed, showURL, ok := rep.adminFeedFromURL(w, r)
+	if !ok {
+		return
+	}
+	rep.syncFeed(r, feed, "Calendar synced")
+	rep.audit(r, models.AuditSync, models.EntityCalendarFeed, feed.ID, nil, nil)
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomFeed unsubscribes a room from a calendar feed. The bookings imported from it
+// are deleted with it.
+func (rep *Repository) AdminDeleteRoomFeed(w http.ResponseWriter, r *http.Request) {
+	feed, showURL, ok := rep.adminFeedFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := rep.DB.DeleteCalendarFeed(feed.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityCalendarFeed, feed.ID, feed, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Calendar removed!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// syncFeed imports a feed and reports the outcome to the user
+func (rep *Repository) syncFeed(r *http.Request, feed models.CalendarFeed, done string) {
+	res, err := calsync.New(rep.DB, rep.App.InfoLog, rep.App.ErrorLog).SyncFeed(feed)
+	var conflict *calsync.ConflictError
+	if errors.As(err, &conflict) {
+		rep.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s: %d added, %d moved, %d removed, but the room is %v",
+			done, res.Inserted, res.Updated, res.Deleted, err))
+		return
+	}
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s, but it could not be imported: %v", done, err))
+		return
+	}
+	rep.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s: %d added, %d moved, %d removed",
+		done, res.Inserted, res.Updated, res.Deleted))
+}
+
+// adminFeedFromURL loads the calendar feed for the {feed} URL parameter, which must belong to the
+// room in {id}. If it cannot, the response has already been written and ok is false.
+func (rep *Repository) adminFeedFromURL(w http.ResponseWriter, r *http.Request) (models.CalendarFeed, string, bool) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return models.CalendarFeed{}, "", false
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+	feedID, _ := strconv.Atoi(chi.URLParam(r, "feed"))
+	feed, err := rep.DB.GetCalendarFeedById(feedID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && feed.RoomID != room.ID) {
+		rep.App.Session.Put(r.Context(), "error", "Calendar not found")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return feed, showURL, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return feed, showURL, false
+	}
+	return feed, showURL, true
+}
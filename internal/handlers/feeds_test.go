@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRoomCalendarFeed(t *testing.T) {
+	routes := getRoutes()
+	tests := []struct {
+		name          string
+		url           string
+		expStatusCode int
+		expICS        []string
+	}{
+		{"room_with_bookings", "/feeds/rooms/1.ics", http.StatusOK, []string{
+			"X-WR-CALNAME:General's Quarters\r\n",
+			"UID:reservation-10@localhost\r\n",
+			"SUMMARY:Reserved: John Smith\r\n",
+			"DESCRIPTION:Confirmation code UPCOMING\r\n",
+			"UID:block-2@localhost\r\n",
+			"SUMMARY:Owner block\r\n",
+			"DESCRIPTION:Painting\r\n",
+			"LAST-MODIFIED:20500102T100000Z\r\n",
+			"CATEGORIES:Owner Block\r\n",
+		}},
+		{"recurring_blocks", "/feeds/rooms/2.ics", http.StatusOK, []string{
+			"UID:recurring-1-",
+			"DESCRIPTION:Deep clean\r\n",
+			"CATEGORIES:Owner Block\r\n",
+		}},
+		{"empty_room", "/feeds/rooms/3.ics", http.StatusOK, []string{"X-WR-CALNAME:Room 3\r\n"}},
+		{"unknown_room", "/feeds/rooms/9.ics", http.StatusNotFound, nil},
+		{"invalid_id", "/feeds/rooms/abc.ics", http.StatusBadRequest, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+			t.Errorf("Failed %s: expected a text/calendar response, got %s", e.name, ct)
+		}
+		for _, want := range e.expICS {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Failed %s: expected feed to contain %q, got\n%s", e.name, want, rr.Body.String())
+			}
+		}
+	}
+
+	// polling the feed again must give the same events, so clients update rather than duplicate them
+	first, second := httptest.NewRecorder(), httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/feeds/rooms/1.ics", nil)
+	routes.ServeHTTP(first, req)
+	routes.ServeHTTP(second, req)
+	if first.Body.String() != second.Body.String() {
+		t.Error("expected the feed to be identical between polls")
+	}
+}
+
+func TestRoomFeedSubscriptions(t *testing.T) {
+	// a local stand-in for an outside booking site
+	fetched := 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		io.WriteString(w, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n")
+	}))
+	defer site.Close()
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		postedData    url.Values
+		expStatusCode int
+		expLocation   string
+		expHTML       string
+		expFetched    int
+	}{
+		{"show_feeds", "GET", "/admin/rooms/1/show", nil, http.StatusOK, "", "unexpected status 404 Not Found", 0},
+		{"dashboard_status", "GET", "/admin/dashboard", nil, http.StatusOK, "", "2 upcoming bookings", 0},
+		{
+			"add_feed", "POST", "/admin/rooms/1/feeds",
+			url.Values{"feed_name": {"Outside"}, "feed_url": {site.URL + "/room.ics"}},
+			http.StatusSeeOther, "/admin/rooms/1/show", "", 1,
+		},
+		{
+			"add_feed_bad_url", "POST", "/admin/rooms/1/feeds",
+			url.Values{"feed_name": {"Outside"}, "feed_url": {"ftp://example.com/room.ics"}},
+			http.StatusSeeOther, "/admin/rooms/1/show", "", 0,
+		},
+		{
+			"add_feed_no_name", "POST", "/admin/rooms/1/feeds",
+			url.Values{"feed_url": {site.URL}},
+			http.StatusSeeOther, "/admin/rooms/1/show", "", 0,
+		},
+		{"sync_feed_unreachable", "POST", "/admin/rooms/1/feeds/1/sync", nil, http.StatusSeeOther, "/admin/rooms/1/show", "", 0},
+		{"sync_feed_other_room", "POST", "/admin/rooms/3/feeds/1/sync", nil, http.StatusSeeOther, "/admin/rooms/3/show", "", 0},
+		{"delete_feed", "POST", "/admin/rooms/1/feeds/2/delete", nil, http.StatusSeeOther, "/admin/rooms/1/show", "", 0},
+		{"delete_feed_unknown", "POST", "/admin/rooms/1/feeds/99/delete", nil, http.StatusSeeOther, "/admin/rooms/1/show", "", 0},
+	}
+
+	routes := getRoutes()
+	for _, e := range tests {
+		fetched = 0
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+		if fetched != e.expFetched {
+			t.Errorf("Failed %s: expected the outside site to be fetched %d times, got %d", e.name, e.expFetched, fetched)
+		}
+	}
+}
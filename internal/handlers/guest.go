@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/pricing"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// GuestReservation shows a reservation to the guest who holds its confirmation code
+func (rep *Repository) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	rep.renderGuestReservation(w, r, res, forms.New(nil))
+}
+
+// GuestPostReservationDates moves the guest's reservation to new dates if the room is free
+func (rep *Repository) GuestPostReservationDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !guestCanChange(res) {
+		rep.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed online")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	if !guestCanMove(res) {
+		// the deposit was taken for the old price, so the owner settles the difference by hand
+		rep.App.Session.Put(r.Context(), "error", "The deposit for this reservation has been paid, please contact us to change its dates")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Please enter a date as YYYY-MM-DD")
+	}
+	end, err := time.Parse(layout, form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Please enter a date as YYYY-MM-DD")
+	}
+	if form.Valid() && !end.After(start) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+	if form.Valid() && start.Before(today()) {
+		form.Errors.Add("start", "Arrival cannot be in the past")
+	}
+	if !form.Valid() {
+		rep.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	room, err := rep.DB.GetRoomById(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	q, err := rep.quote(room, start, end)
+	var minStay pricing.MinStayError
+	if errors.As(err, &minStay) {
+		form.Errors.Add("end", fmt.Sprintf("Stays arriving on this date must be at least %d nights", minStay.MinNights))
+		rep.renderGuestReservation(w, r, res, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = rep.DB.ChangeReservationDates(res.ID, start, end, q.Total)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		rep.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for those dates")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rep.queueEmail(res.Email, rep.App.MailFrom, mailer.DatesChanged{
+		Reservation: res,
+		NewStart:    start,
+		NewEnd:      end,
+		NewTotal:    q.Total,
+		ManageURL:   rep.GuestReservationURL(res),
+	})
+	rep.queueEmail(rep.App.OwnerEmail, rep.App.StaffMailFrom, mailer.DatesChanged{
+		Reservation: res,
+		NewStart:    start,
+		NewEnd:      end,
+		NewTotal:    q.Total,
+	})
+	rep.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+}
+
+// GuestCancelReservation cancels the guest's reservation, frees the room and notifies the owner
+func (rep *Repository) GuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !guestCanChange(res) {
+		rep.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	err := rep.DB.UpdateReservationStatus(res.ID, models.StatusCancelled, 0)
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		rep.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	flash := "Your reservation has been cancelled"
+	cancellation := mailer.Cancellation{Reservation: res}
+	if res.PaymentStatus == models.PaymentPaid {
+		err = rep.refundDeposit(res)
+		if err != nil {
+			// the dates are already free, so the owner settles the deposit by hand
+			rep.App.ErrorLog.Println(err)
+			cancellation.RefundError = err.Error()
+		} else {
+			flash = fmt.Sprintf("Your reservation has been cancelled and your deposit of %s refunded", render.FormatMoney(res.AmountPaid))
+			cancellation.Refunded = true
+		}
+	}
+
+	rep.queueEmail(rep.App.OwnerEmail, rep.App.StaffMailFrom, cancellation)
+	rep.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+}
+
+// GuestPayReservation takes the guest to the deposit form of a reservation that is still waiting
+// for it, such as one booked for them through the API
+func (rep *Repository) GuestPayReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !res.AwaitingPayment() || res.Cancelled() {
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	rep.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make_payment", http.StatusSeeOther)
+}
+
+// guestReservationFromURL loads the reservation for the {code} URL parameter. If it cannot,
+// the response has already been written and ok is false.
+func (rep *Repository) guestReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	code := strings.ToUpper(strings.TrimSpace(chi.URLParam(r, "code")))
+	res, err := rep.DB.FetchReservationByCode(code)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "We could not find a reservation with that confirmation code")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+func (rep *Repository) renderGuestReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	if guestCanChange(res) {
+		stringMap["can_change"] = "1"
+	}
+	if guestCanMove(res) {
+		stringMap["can_move"] = "1"
+	}
+	render.Template(w, "guest_reservation.page.tmpl", r, &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// guestCanChange reports whether the guest may still change or cancel a reservation online.
+// Once the stay has started, or once the reservation could no longer be cancelled, guests have to
+// contact the property.
+func guestCanChange(res models.Reservation) bool {
+	return models.CanTransition(res.Status, models.StatusCancelled) && res.StartDate.After(today())
+}
+
+// guestCanMove reports whether the guest may still move the reservation to other dates, which
+// they cannot once a deposit has been taken for its price
+func guestCanMove(res models.Reservation) bool {
+	switch res.PaymentStatus {
+	case models.PaymentProcessing, models.PaymentAuthorized, models.PaymentPaid:
+		return false
+	}
+	return guestCanChange(res)
+}
+
+// today returns the start of the current day
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func guestReservationPath(res models.Reservation) string {
+	return "/reservations/" + res.ConfirmationCode
+}
+
+// GuestReservationURL is the absolute link to the guest's reservation, for use in emails
+func (rep *Repository) GuestReservationURL(res models.Reservation) string {
+	return rep.App.BaseURL + guestReservationPath(res)
+}
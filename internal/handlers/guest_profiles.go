@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// guestListLimit is the most guests the guest list shows; searching narrows it down
+const guestListLimit = 100
+
+// AdminGuests lists the guests, or those whose name, email, phone or tags contain the q query
+// parameter
+func (rep *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	guests, err := rep.DB.ListGuests(q, guestListLimit)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["guests"] = guests
+	stringMap := make(map[string]string)
+	stringMap["q"] = q
+	intMap := make(map[string]int)
+	intMap["limit"] = guestListLimit
+	render.Template(w, "admin_guests.page.tmpl", r, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// AdminShowGuest shows a guest's details and stay history, with the guests that may be
+// duplicates of them. A guest merged into another one leads to that guest.
+func (rep *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	guest, ok := rep.adminGuestFromURL(w, r)
+	if !ok {
+		return
+	}
+	if guest.MergedInto != 0 {
+		http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d/show", guest.MergedInto), http.StatusSeeOther)
+		return
+	}
+	rep.renderGuest(w, r, guest, forms.New(nil))
+}
+
+// AdminPostGuest saves the name, phone, notes and tags of a guest
+func (rep *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	existing, ok := rep.adminGuestFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	guest := existing
+	guest.FirstName = strings.TrimSpace(form.Get("first_name"))
+	guest.LastName = strings.TrimSpace(form.Get("last_name"))
+	guest.Phone = strings.TrimSpace(form.Get("phone"))
+	guest.Notes = strings.TrimSpace(form.Get("notes"))
+	guest.Tags = models.ParseTags(form.Get("tags"))
+	if !form.Valid() {
+		rep.renderGuest(w, r, guest, form)
+		return
+	}
+	err = rep.DB.UpdateGuest(guest)
+	if errors.Is(err, sql.ErrNoRows) {
+		// merged into another guest since the page was loaded
+		rep.App.Session.Put(r.Context(), "error", "This guest has been merged into another one, so the changes were not saved")
+		http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d/show", guest.ID), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityGuest, guest.ID, existing, guest)
+	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d/show", guest.ID), http.StatusSeeOther)
+}
+
+// AdminMergeGuest merges the guest given by the merge_id form field into the guest in the URL.
+// The duplicate's reservations move over, its notes and tags are added, and later bookings made
+// with its email go to the guest that is kept.
+func (rep *Repository) AdminMergeGuest(w http.ResponseWriter, r *http.Request) {
+	keep, ok := rep.adminGuestFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	back := fmt.Sprintf("/admin/guests/%d/show", keep.ID)
+	mergeID, err := strconv.Atoi(strings.TrimSpace(r.Form.Get("merge_id")))
+	if err != nil || mergeID == keep.ID {
+		rep.App.Session.Put(r.Context(), "error", "Choose another guest to merge into this one")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	dup, err := rep.DB.GetGuestById(mergeID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err == nil {
+		err = rep.DB.MergeGuests(keep.ID, mergeID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", fmt.Sprintf("Guest %d not found, or already merged into another guest", mergeID))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	merged := dup
+	merged.MergedInto = keep.ID
+	rep.audit(r, models.AuditMerge, models.EntityGuest, dup.ID, dup, merged)
+	rep.audit(r, models.AuditUpdate, models.EntityGuest, keep.ID, keep, keep.Merge(dup))
+	rep.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Merged %s %s (%s) into this guest!", dup.FirstName, dup.LastName, dup.Email))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// adminGuestFromURL loads the guest for the {id} URL parameter. If it cannot, the response has
+// already been written and ok is false.
+func (rep *Repository) adminGuestFromURL(w http.ResponseWriter, r *http.Request) (models.Guest, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Guest{}, false
+	}
+	guest, err := rep.DB.GetGuestById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "Guest not found")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return guest, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return guest, false
+	}
+	return guest, true
+}
+
+// renderGuest shows a guest's page with the form for their details
+func (rep *Repository) renderGuest(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	reservations, err := rep.DB.GuestReservations(guest.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	duplicates, err := rep.DB.GuestDuplicates(guest)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["reservations"] = reservations
+	data["duplicates"] = duplicates
+	data["suggested_tags"] = models.SuggestedTags
+	stringMap := make(map[string]string)
+	stringMap["tags"] = strings.Join(guest.Tags, ", ")
+	intMap := make(map[string]int)
+	intMap["stays"], intMap["nights"] = models.StayTotals(reservations)
+	render.Template(w, "admin_guest.page.tmpl", r, &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
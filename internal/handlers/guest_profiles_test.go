@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+var guestPageTests = []struct {
+	name          string
+	url           string
+	expStatusCode int
+	expLocation   string
+	expHTML       []string
+	notExpHTML    []string
+}{
+	{"list", "/admin/guests", http.StatusOK, "", []string{"Smith, John", "Doe, Jane", "Smith, Jon", ">VIP<"}, []string{"j@smith.com"}},
+	{"search", "/admin/guests?q=doe", http.StatusOK, "", []string{"Doe, Jane"}, []string{"Smith, John"}},
+	{"search_fails", "/admin/guests?q=fail", http.StatusInternalServerError, "", nil, nil},
+	{
+		"show", "/admin/guests/1/show", http.StatusOK, "",
+		[]string{"Stays</strong>: 20, for 20 nights", "Late arrival", "/admin/reservations/all/29/show"},
+		[]string{"Jane"},
+	},
+	{"show_no_stays", "/admin/guests/3/show", http.StatusOK, "", []string{"No reservations yet."}, nil},
+	{"show_merged", "/admin/guests/4/show", http.StatusSeeOther, "/admin/guests/1/show", nil, nil},
+	{"show_unknown", "/admin/guests/99/show", http.StatusSeeOther, "/admin/guests", nil, nil},
+	{"show_bad_id", "/admin/guests/abc/show", http.StatusBadRequest, "", nil, nil},
+}
+
+func TestAdminGuestPages(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range guestPageTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		for _, want := range e.expHTML {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Failed %s: expected page to contain %q", e.name, want)
+			}
+		}
+		for _, unwanted := range e.notExpHTML {
+			if strings.Contains(rr.Body.String(), unwanted) {
+				t.Errorf("Failed %s: expected page not to contain %q", e.name, unwanted)
+			}
+		}
+	}
+}
+
+var guestPermissionTests = []struct {
+	name        string
+	accessLevel int
+	expHTML     []string
+	notExpHTML  []string
+}{
+	{"owner", models.AccessLevelOwner, []string{`value="Save"`, "jon@smith.com", "Merge into this guest"}, nil},
+	{"staff", models.AccessLevelStaff, []string{`value="Save"`}, []string{"Merge into this guest"}},
+	{"auditor", models.AccessLevelAuditor, nil, []string{`value="Save"`, "Merge into this guest"}},
+}
+
+func TestAdminShowGuestPermissions(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range guestPermissionTests {
+		req, _ := http.NewRequest("GET", "/admin/guests/1/show", nil)
+		req = req.WithContext(helpers.WithUser(req.Context(), models.User{ID: 1, AccessLevel: e.accessLevel}))
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		html := rr.Body.String()
+		for _, x := range e.expHTML {
+			if !strings.Contains(html, x) {
+				t.Errorf("Failed %s: expected page to contain %s", e.name, x)
+			}
+		}
+		for _, x := range e.notExpHTML {
+			if strings.Contains(html, x) {
+				t.Errorf("Failed %s: expected page not to contain %s", e.name, x)
+			}
+		}
+	}
+}
+
+var guestPostTests = []struct {
+	name          string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expFlash      string
+	expError      string
+	expHTML       string
+}{
+	{
+		"update", "/admin/guests/1",
+		url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "tags": {"VIP, Corporate"}, "notes": {"Late arrival"}},
+		http.StatusSeeOther, "/admin/guests/1/show", "Changes saved!", "", "",
+	},
+	{
+		"update_missing_name", "/admin/guests/1",
+		url.Values{"first_name": {""}, "last_name": {"Smith"}},
+		http.StatusOK, "", "", "", "This field cannot be blank",
+	},
+	{"update_unknown", "/admin/guests/99", url.Values{"first_name": {"A"}, "last_name": {"B"}}, http.StatusSeeOther, "/admin/guests", "", "Guest not found", ""},
+	{"merge", "/admin/guests/1/merge", url.Values{"merge_id": {"3"}}, http.StatusSeeOther, "/admin/guests/1/show", "Merged Jon Smith (jon@smith.com) into this guest!", "", ""},
+	{"merge_itself", "/admin/guests/1/merge", url.Values{"merge_id": {"1"}}, http.StatusSeeOther, "/admin/guests/1/show", "", "Choose another guest", ""},
+	{"merge_no_id", "/admin/guests/1/merge", url.Values{"merge_id": {"abc"}}, http.StatusSeeOther, "/admin/guests/1/show", "", "Choose another guest", ""},
+	{"merge_unknown", "/admin/guests/1/merge", url.Values{"merge_id": {"99"}}, http.StatusSeeOther, "/admin/guests/1/show", "", "Guest 99 not found", ""},
+	{"merge_already_merged", "/admin/guests/1/merge", url.Values{"merge_id": {"4"}}, http.StatusSeeOther, "/admin/guests/1/show", "", "already merged", ""},
+}
+
+func TestAdminPostGuest(t *testing.T) {
+	spy := &auditSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	routes := getRoutes()
+	for _, e := range guestPostTests {
+		spy.entries = nil
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %q", e.name, e.expHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expFlash {
+			t.Errorf("Failed %s: expected flash %q, got %q", e.name, e.expFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); !strings.Contains(msg, e.expError) || (e.expError == "" && msg != "") {
+			t.Errorf("Failed %s: expected error %q, got %q", e.name, e.expError, msg)
+		}
+		if e.expFlash == "" && len(spy.entries) != 0 {
+			t.Errorf("Failed %s: expected nothing to be audited, got %+v", e.name, spy.entries)
+		}
+	}
+}
+
+func TestAdminMergeGuestIsAudited(t *testing.T) {
+	spy := &auditSpy{DbRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DbRepo }()
+
+	req, _ := http.NewRequest("POST", "/admin/guests/1/merge", strings.NewReader("merge_id=3"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	getRoutes().ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(spy.entries) != 2 {
+		t.Fatalf("expected the duplicate and the kept guest to be audited, got %+v", spy.entries)
+	}
+	dup, keep := spy.entries[0], spy.entries[1]
+	if dup.Action != models.AuditMerge || dup.EntityType != models.EntityGuest || dup.EntityID != 3 {
+		t.Errorf("expected guest 3 to be recorded as merged, got %+v", dup)
+	}
+	if len(dup.Changes) != 1 || dup.Changes[0] != (models.AuditChange{Field: "merged_into", Before: "0", After: "1"}) {
+		t.Errorf("expected merged_into to change from 0 to 1, got %+v", dup.Changes)
+	}
+	if keep.Action != models.AuditUpdate || keep.EntityID != 1 {
+		t.Errorf("expected guest 1 to be recorded as updated, got %+v", keep)
+	}
+}
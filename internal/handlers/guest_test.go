@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var guestTests = []struct {
+	name          string
+	method        string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expHTML       string
+}{
+	{"view", "GET", "/reservations/UPCOMING", nil, http.StatusOK, "", "Change dates"},
+	{"view_lower_case", "GET", "/reservations/upcoming", nil, http.StatusOK, "", "UPCOMING"},
+	{"view_started", "GET", "/reservations/STARTED", nil, http.StatusOK, "", "already started"},
+	{"view_cancelled", "GET", "/reservations/CANCELLED", nil, http.StatusOK, "", "was cancelled"},
+	{"view_paid", "GET", "/reservations/PAID", nil, http.StatusOK, "", "Your deposit has been paid"},
+	{"view_unknown", "GET", "/reservations/NOPE", nil, http.StatusSeeOther, "/", ""},
+	{
+		"change_dates", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"2050-02-01"}, "end": {"2050-02-03"}},
+		http.StatusSeeOther, "/reservations/UPCOMING", "",
+	},
+	{
+		"change_dates_unavailable", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"2060-02-01"}, "end": {"2060-02-03"}},
+		http.StatusSeeOther, "/reservations/UPCOMING", "",
+	},
+	{
+		"change_dates_end_before_start", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"2050-02-03"}, "end": {"2050-02-01"}},
+		http.StatusOK, "", "Departure must be after arrival",
+	},
+	{
+		"change_dates_in_past", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"2000-02-01"}, "end": {"2000-02-03"}},
+		http.StatusOK, "", "Arrival cannot be in the past",
+	},
+	{
+		"change_dates_invalid", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"invalid"}, "end": {"2050-02-03"}},
+		http.StatusOK, "", "Please enter a date",
+	},
+	{
+		"change_dates_below_min_stay", "POST", "/reservations/UPCOMING",
+		url.Values{"start": {"2050-07-02"}, "end": {"2050-07-03"}},
+		http.StatusOK, "", "at least 3 nights",
+	},
+	{
+		"change_dates_started", "POST", "/reservations/STARTED",
+		url.Values{"start": {"2050-02-01"}, "end": {"2050-02-03"}},
+		http.StatusSeeOther, "/reservations/STARTED", "",
+	},
+	{
+		"change_dates_paid", "POST", "/reservations/PAID",
+		url.Values{"start": {"2050-02-01"}, "end": {"2050-02-03"}},
+		http.StatusSeeOther, "/reservations/PAID", "",
+	},
+	{"cancel", "POST", "/reservations/UPCOMING/cancel", url.Values{}, http.StatusSeeOther, "/reservations/UPCOMING", ""},
+	{"cancel_cancelled", "POST", "/reservations/CANCELLED/cancel", url.Values{}, http.StatusSeeOther, "/reservations/CANCELLED", ""},
+	{"cancel_unknown", "POST", "/reservations/NOPE/cancel", url.Values{}, http.StatusSeeOther, "/", ""},
+	{"pay", "GET", "/reservations/UNPAID/pay", nil, http.StatusSeeOther, "/make_payment", ""},
+	{"pay_nothing_due", "GET", "/reservations/UPCOMING/pay", nil, http.StatusSeeOther, "/reservations/UPCOMING", ""},
+	{"pay_unknown", "GET", "/reservations/NOPE/pay", nil, http.StatusSeeOther, "/", ""},
+}
+
+func TestGuestReservation(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range guestTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
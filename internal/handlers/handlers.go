@@ -1,22 +1,29 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Ed-cred/bookings/internal/config"
 	"github.com/Ed-cred/bookings/internal/driver"
 	"github.com/Ed-cred/bookings/internal/forms"
 	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/pricing"
 	"github.com/Ed-cred/bookings/internal/render"
 	"github.com/Ed-cred/bookings/internal/repository"
 	"github.com/Ed-cred/bookings/internal/repository/dbrepo"
+	"github.com/Ed-cred/bookings/internal/tokens"
 	"github.com/go-chi/chi"
 )
 
@@ -53,14 +60,6 @@ func (rep *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, "home.page.tmpl", r, &models.TemplateData{})
 }
 
-func (rep *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "generals.page.tmpl", r, &models.TemplateData{})
-}
-
-func (rep *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "majors.page.tmpl", r, &models.TemplateData{})
-}
-
 func (rep *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, "search_availability.page.tmpl", r, &models.TemplateData{})
 }
@@ -124,6 +123,8 @@ type jsonResponse struct {
 	RoomId    string `json:"room_id"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	Total     int    `json:"total"`
+	Price     string `json:"price"`
 }
 
 // AvailabilityJSON handler for on page post request and sends JSON response
@@ -185,6 +186,22 @@ func (rep *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request)
 		EndDate:   ed,
 		RoomId:    strconv.Itoa(roomID),
 	}
+	if available {
+		room, err := rep.DB.GetRoomById(roomID)
+		if err == nil {
+			var q pricing.Quote
+			q, err = rep.quote(room, startDate, endDate)
+			resp.Total = q.Total
+			resp.Price = render.FormatMoney(q.Total)
+		}
+		var minStay pricing.MinStayError
+		if errors.As(err, &minStay) {
+			resp.Ok = false
+			resp.Message = minStayMessage(minStay)
+		} else if err != nil {
+			resp.Ok = false
+		}
+	}
 	out, _ := json.MarshalIndent(resp, "", "     ")
 
 	w.Header().Set("Content-Type", "application/json")
@@ -209,6 +226,19 @@ func (rep *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res.Room.RoomName = room.RoomName
+	q, err := rep.quote(room, res.StartDate, res.EndDate)
+	var minStay pricing.MinStayError
+	if errors.As(err, &minStay) {
+		rep.App.Session.Put(r.Context(), "error", minStayMessage(minStay))
+		http.Redirect(w, r, "/search_availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", "can't calculate the price of the stay")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	res.TotalPrice = q.Total
 	rep.App.Session.Put(r.Context(), "reservation", res)
 	sd := res.StartDate.Format("2006-01-02")
 	ed := res.EndDate.Format("2006-01-02")
@@ -217,6 +247,7 @@ func (rep *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap["end_date"] = ed
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = q
 	render.Template(w, "make_reservation.page.tmpl", r, &models.TemplateData{
 		Form:      forms.New(nil),
 		Data:      data,
@@ -262,56 +293,103 @@ func (rep *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	newReservationID, err := rep.DB.InsertReservation(reservation)
+	// price the stay again with the current rates, the guest may have kept the form open a while
+	room, err := rep.DB.GetRoomById(reservation.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for the selected dates")
+		http.Redirect(w, r, "/search_availability", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		rep.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		rep.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	q, err := rep.quote(room, reservation.StartDate, reservation.EndDate)
+	var minStay pricing.MinStayError
+	if errors.As(err, &minStay) {
+		rep.App.Session.Put(r.Context(), "error", minStayMessage(minStay))
+		http.Redirect(w, r, "/search_availability", http.StatusSeeOther)
+		return
 	}
-	err = rep.DB.InsertRoomRestriction(restriction)
 	if err != nil {
-		rep.App.Session.Put(r.Context(), "error", "can't insert room restriction!")
+		rep.App.Session.Put(r.Context(), "error", "can't calculate the price of the stay")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	// send notification
-
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation confirmation</strong><br>
-		Dear %s, <br>
-		This is a confirmation for your reservation from %s to %s for the %s room.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), reservation.Room.RoomName)
+	reservation.TotalPrice = q.Total
+	reservation, err = rep.book(reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		rep.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for the selected dates")
+		http.Redirect(w, r, "/search_availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	rep.App.Session.Put(r.Context(), "reservation", reservation)
+	if reservation.AwaitingPayment() {
+		http.Redirect(w, r, "/make_payment", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/reservation_summary", http.StatusSeeOther)
+}
 
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+// book gives a priced reservation its confirmation code and books it, for guests on the site and
+// clients of the API alike. A stay that needs a deposit only holds its dates until PostPayment
+// takes it; any other is confirmed to the guest and the owner in the transaction that books it.
+// The reservation is returned with its id.
+func (rep *Repository) book(res models.Reservation) (models.Reservation, error) {
+	if rep.depositDue(res.TotalPrice) > 0 {
+		res.PaymentStatus = models.PaymentPending
 	}
-	rep.App.MailChan <- msg
+	var err error
+	res.ConfirmationCode, err = tokens.NewCode()
+	if err != nil {
+		return res, err
+	}
+	var emails []models.MailData
+	if !res.AwaitingPayment() {
+		emails, err = rep.bookingEmails(res)
+		if err != nil {
+			return res, err
+		}
+	}
+	res.ID, err = rep.DB.BookReservation(res, emails)
+	return res, err
+}
 
-	htmlMessage = fmt.Sprintf(`
-		<strong>New Reservation</strong><br>
-		A reservation has been made from %s to %s for the %s room.
-	`, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), reservation.Room.RoomName)
+// bookingEmails writes the confirmation to the guest and the notification to the owner of a new booking
+func (rep *Repository) bookingEmails(res models.Reservation) ([]models.MailData, error) {
+	confirmation, err := rep.App.EmailTemplates.Render(res.Email, rep.App.MailFrom, mailer.Confirmation{
+		Reservation: res,
+		ManageURL:   rep.GuestReservationURL(res),
+	})
+	if err != nil {
+		return nil, err
+	}
+	notification, err := rep.App.EmailTemplates.Render(rep.App.OwnerEmail, rep.App.StaffMailFrom, mailer.OwnerNotification{
+		Reservation: res,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []models.MailData{confirmation, notification}, nil
+}
 
-	msg = models.MailData{
-		To:      "property@owner.com",
-		From:    "me@here.com",
-		Subject: "New Reservation",
-		Content: htmlMessage,
+// quote prices a stay in room with the room's current rate overrides
+func (rep *Repository) quote(room models.Room, start, end time.Time) (pricing.Quote, error) {
+	rates, err := rep.DB.RoomRates(room.ID)
+	if err != nil {
+		return pricing.Quote{}, err
 	}
-	rep.App.MailChan <- msg
-	rep.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation_summary", http.StatusSeeOther)
+	return pricing.NewQuote(room, rates, start, end)
+}
+
+func minStayMessage(e pricing.MinStayError) string {
+	return fmt.Sprintf("Sorry, stays arriving on these dates must be at least %d nights", e.MinNights)
 }
 
 func (rep *Repository) About(w http.ResponseWriter, r *http.Request) {
@@ -414,6 +492,11 @@ func (rep *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id, _, err := rep.DB.Authenticate(email, password)
+	if errors.Is(err, repository.ErrUserInactive) {
+		rep.App.Session.Put(r.Context(), "error", "This account has been deactivated")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		rep.App.Session.Put(r.Context(), "error", "invalid login credentials")
@@ -433,37 +516,365 @@ func (rep *Repository) UserLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (rep *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "admin_dashboard.page.tmpl", r, &models.TemplateData{})
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ForgotPassword shows the form to request a password reset link
+func (rep *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, "forgot_password.page.tmpl", r, &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
-func (rep *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	newReservations, err := rep.DB.AllNewReservations()
+// PostForgotPassword emails a password reset link to the user with the posted email address.
+// The response is the same whether or not the address belongs to a user.
+func (rep *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
-		rep.App.Session.Put(r.Context(), "error", "could not fetch reservations from database")
 		return
 	}
-	data := make(map[string]interface{})
-	data["reservations"] = newReservations
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, "forgot_password.page.tmpl", r, &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+	u, err := rep.DB.GetUserByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err == nil && u.Active {
+		token := tokens.NewSigned(rep.App.SigningKey, u.ID, time.Now().Add(passwordResetTTL), u.Password)
+		link := fmt.Sprintf("%s/user/reset-password?token=%s", rep.App.BaseURL, url.QueryEscape(token))
+		rep.queueEmail(u.Email, rep.App.StaffMailFrom, mailer.PasswordReset{User: u, ResetURL: link})
+	}
+	rep.App.Session.Put(r.Context(), "flash", "If that address belongs to an account, a reset link is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form to choose a new password for a valid reset link
+func (rep *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := rep.userForResetToken(token)
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+	render.Template(w, "reset_password.page.tmpl", r, &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostResetPassword stores the new password. The link stops working once the password has changed.
+func (rep *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	token := r.Form.Get("token")
+	u, err := rep.userForResetToken(token)
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+		render.Template(w, "reset_password.page.tmpl", r, &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+	err = rep.DB.UpdatePassword(u.ID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.App.Session.Put(r.Context(), "flash", "Password changed, you can now log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userForResetToken returns the active user a reset token was issued for. The token is signed
+// over the user's current password hash, so it can only be used once.
+func (rep *Repository) userForResetToken(token string) (models.User, error) {
+	s, err := tokens.ParseSigned(token)
+	if err != nil {
+		return models.User{}, err
+	}
+	u, err := rep.DB.GetUserById(s.UserID)
+	if err != nil {
+		return u, err
+	}
+	if !u.Active {
+		return u, repository.ErrUserInactive
+	}
+	err = s.Verify(rep.App.SigningKey, u.Password, time.Now())
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
 
-	render.Template(w, "admin_new_reservations.page.tmpl", r, &models.TemplateData{
+func (rep *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	feeds, err := rep.DB.AllCalendarFeeds()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["feeds"] = feeds
+	render.Template(w, "admin_dashboard.page.tmpl", r, &models.TemplateData{
 		Data: data,
 	})
 }
 
+// AdminNewReservations lists the reservations that are still pending, taking the same query
+// parameters as AdminAllReservations apart from status
+func (rep *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Del("status")
+	rep.renderReservationList(w, r, "admin_new_reservations.page.tmpl", "new", q, models.StatusPending)
+}
+
+// AdminAllReservations lists the reservations a page at a time. The q, room, status, from and to
+// query parameters filter the list, sort and dir order it, and page picks the page.
 func (rep *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := rep.DB.AllReservations()
+	rep.renderReservationList(w, r, "admin_all_reservations.page.tmpl", "all", r.URL.Query(), "")
+}
+
+// renderReservationList shows the page of reservations selected by the query string q, limited to
+// status if it is not empty. src names the list, as in the /admin/reservations_{src} path.
+func (rep *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, tmpl, src string, q url.Values, status string) {
+	filter, ok := reservationFilterFromQuery(q)
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if status != "" {
+		filter.Status = status
+	}
+	page, err := rep.DB.SearchReservations(filter)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := rep.DB.AllRooms()
 	if err != nil {
 		helpers.ServerError(w, err)
-		rep.App.Session.Put(r.Context(), "error", "could not fetch reservations from database")
 		return
 	}
 	data := make(map[string]interface{})
+	data["list"] = reservationList{
+		ReservationPage: page,
+		Filter:          filter,
+		Src:             src,
+		query:           q,
+	}
+	data["rooms"] = rooms
+	data["statuses"] = models.ReservationStatuses
+	stringMap := make(map[string]string)
+	for _, key := range []string{"q", "from", "to"} {
+		stringMap[key] = q.Get(key)
+	}
+	stringMap["status"] = filter.Status
+
+	render.Template(w, tmpl, r, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// reservationsPerPage is the number of reservations on a page of the admin lists
+const reservationsPerPage = 25
+
+// reservationFilterFromQuery reads the filters, sort order and page of a reservation list from the
+// query string. It reports false if one of them is malformed. Dates sort latest first and names
+// from A to Z unless dir says otherwise.
+func reservationFilterFromQuery(q url.Values) (models.ReservationFilter, bool) {
+	filter := models.ReservationFilter{
+		Query:   strings.TrimSpace(q.Get("q")),
+		Status:  q.Get("status"),
+		Sort:    q.Get("sort"),
+		Page:    1,
+		PerPage: reservationsPerPage,
+	}
+	if filter.Status != "" && !models.ValidStatus(filter.Status) {
+		return filter, false
+	}
+	if filter.Sort == "" {
+		filter.Sort = models.SortStartDate
+	}
+	if !models.ValidReservationSort(filter.Sort) {
+		return filter, false
+	}
+	switch q.Get("dir") {
+	case "":
+		filter.Desc = filter.Sort != models.SortGuestName
+	case "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, false
+	}
+	var err error
+	if v := q.Get("room"); v != "" {
+		if filter.RoomID, err = strconv.Atoi(v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse("2006-01-02", v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse("2006-01-02", v); err != nil {
+			return filter, false
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil || filter.Page < 1 {
+			return filter, false
+		}
+	}
+	return filter, true
+}
+
+// reservationList is a page of an admin reservation list, with the links for sorting it and moving
+// between pages. The links keep the filters of the current page.
+type reservationList struct {
+	models.ReservationPage
+	Filter models.ReservationFilter
+	// Src names the list in links to a reservation, so the reservation page can return to it
+	Src   string
+	query url.Values
+}
+
+// link returns the list's own URL with the query string q. Apart from links to other pages, q
+// should leave out the page, so that changing the filters or order starts again at the top.
+func (l reservationList) link(q url.Values) string {
+	if len(q) == 0 {
+		return fmt.Sprintf("/admin/reservations_%s", l.Src)
+	}
+	return fmt.Sprintf("/admin/reservations_%s?%s", l.Src, q.Encode())
+}
+
+// values returns a copy of the list's query string without the page
+func (l reservationList) values() url.Values {
+	q := url.Values{}
+	for k, v := range l.query {
+		q[k] = v
+	}
+	q.Del("page")
+	return q
+}
+
+// PageURL links to page n of the list
+func (l reservationList) PageURL(n int) string {
+	q := l.values()
+	q.Set("page", strconv.Itoa(n))
+	return l.link(q)
+}
+
+// PrevURL links to the page before this one
+func (l reservationList) PrevURL() string {
+	return l.PageURL(l.Page - 1)
+}
+
+// NextURL links to the page after this one
+func (l reservationList) NextURL() string {
+	return l.PageURL(l.Page + 1)
+}
+
+// SortURL links to the list sorted by sort. A list already sorted by it is reversed; otherwise
+// dates start with the latest and names with A.
+func (l reservationList) SortURL(sort string) string {
+	q := l.values()
+	desc := sort != models.SortGuestName
+	if l.Filter.Sort == sort {
+		desc = !l.Filter.Desc
+	}
+	q.Set("sort", sort)
+	q.Set("dir", "asc")
+	if desc {
+		q.Set("dir", "desc")
+	}
+	return l.link(q)
+}
+
+// SortMark shows whether the list is sorted by sort, and which way
+func (l reservationList) SortMark(sort string) string {
+	switch {
+	case l.Filter.Sort != sort:
+		return ""
+	case l.Filter.Desc:
+		return "▼"
+	}
+	return "▲"
+}
+
+// StatusURL links to the list showing only status, or every status if status is empty
+func (l reservationList) StatusURL(status string) string {
+	q := l.values()
+	q.Del("status")
+	if status != "" {
+		q.Set("status", status)
+	}
+	return l.link(q)
+}
+
+// guestSearchLimit is the most reservations a guest search returns
+const guestSearchLimit = 50
+
+// guestSearchMinLength is the fewest characters a guest search needs. Shorter ones match nearly
+// every guest.
+const guestSearchMinLength = 2
+
+// AdminSearchReservations finds the reservations whose guest's name, email or phone matches the q
+// query parameter, best matches first
+func (rep *Repository) AdminSearchReservations(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	var reservations []models.Reservation
+	searched := utf8.RuneCountInString(q) >= guestSearchMinLength
+	if searched {
+		var err error
+		reservations, err = rep.DB.SearchGuests(q, guestSearchLimit)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	stringMap := make(map[string]string)
+	stringMap["q"] = q
+	intMap := make(map[string]int)
+	intMap["limit"] = guestSearchLimit
+	intMap["min_length"] = guestSearchMinLength
+	if searched {
+		intMap["searched"] = 1
+	}
 
-	render.Template(w, "admin_all_reservations.page.tmpl", r, &models.TemplateData{
-		Data: data,
+	render.Template(w, "admin_reservations_search.page.tmpl", r, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
@@ -519,13 +930,6 @@ func (rep *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.
 	}
 	data["rooms"] = rooms
 	for _, x := range rooms {
-		// create maps to store reservation data
-		resMap := make(map[string]int)
-		blockMap := make(map[string]int)
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			resMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
 		// get all restrictions for the current room
 		restrictions, err := rep.DB.FetchRestrictionsForRoomByDay(x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
@@ -533,19 +937,8 @@ func (rep *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.
 			rep.App.Session.Put(r.Context(), "error", "could not fetch room restrictions from database")
 			return
 		}
-		for _, y := range restrictions {
-			if y.ReservationID != 0 {
-				// it's a reservation yay!!
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					resMap[d.Format("2006-01-2")] = y.ReservationID
-				}
-			} else {
-				// admin block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = resMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		cells, blockMap := calendarCells(firstOfMonth, lastOfMonth, restrictions)
+		data[fmt.Sprintf("cells_%d", x.ID)] = cells
 
 		rep.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 
@@ -578,8 +971,14 @@ func (rep *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Reque
 		helpers.ServerError(w, err)
 		return
 	}
+	history, err := rep.DB.ReservationStatusHistory(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["status_changes"] = history
 	render.Template(w, "admin_reservations_show.page.tmpl", r, &models.TemplateData{
 		Data:      data,
 		StringMap: stringMap,
@@ -610,6 +1009,7 @@ func (rep *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Reque
 		helpers.ServerError(w, err)
 		return
 	}
+	before := res
 
 	res.FirstName = r.Form.Get("first_name")
 	res.LastName = r.Form.Get("last_name")
@@ -621,6 +1021,7 @@ func (rep *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Reque
 		helpers.ServerError(w, err)
 		return
 	}
+	rep.audit(r, models.AuditUpdate, models.EntityReservation, res.ID, before, res)
 
 	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
 	if year == "" || month == "" {
@@ -630,17 +1031,37 @@ func (rep *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-func (rep *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminChangeReservationStatus moves a reservation to the posted status on behalf of the logged in
+// user
+func (rep *Repository) AdminChangeReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-	err := rep.DB.UpdateProcessedReservation(id, 1)
-	if err != nil {
+	status := r.Form.Get("status")
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+	res, err := rep.DB.FetchReservationById(id)
+	if err == nil {
+		err = rep.DB.UpdateReservationStatus(id, status, rep.App.Session.GetInt(r.Context(), "user_id"))
+	}
+	switch {
+	case errors.Is(err, repository.ErrInvalidTransition):
+		rep.App.Session.Put(r.Context(), "error", fmt.Sprintf("The reservation cannot be marked %s from its current status", strings.ToLower(models.StatusLabel(status))))
+	case errors.Is(err, sql.ErrNoRows):
+		rep.App.Session.Put(r.Context(), "error", "Reservation not found")
+	case err != nil:
 		helpers.ServerError(w, err)
 		return
+	default:
+		changed := res
+		changed.Status = status
+		rep.audit(r, models.AuditStatusChange, models.EntityReservation, id, res, changed)
+		rep.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked %s!", strings.ToLower(models.StatusLabel(status))))
 	}
-	rep.App.Session.Put(r.Context(), "flash", "Processed reservation!")
 	if year == "" || month == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations_%s", src), http.StatusSeeOther)
 	} else {
@@ -648,6 +1069,8 @@ func (rep *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// AdminDeleteReservation moves a reservation to the trash, from where it can be restored until
+// it is purged
 func (rep *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
@@ -655,12 +1078,20 @@ func (rep *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Req
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	err := rep.DB.DeleteReservation(id)
-	if err != nil {
+	res, err := rep.DB.FetchReservationById(id)
+	if err == nil {
+		err = rep.DB.DeleteReservation(id, rep.App.Session.GetInt(r.Context(), "user_id"))
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		rep.App.Session.Put(r.Context(), "error", "Reservation not found")
+	case err != nil:
 		helpers.ServerError(w, err)
 		return
+	default:
+		rep.audit(r, models.AuditDelete, models.EntityReservation, id, res, nil)
+		rep.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash!")
 	}
-	rep.App.Session.Put(r.Context(), "flash", "Reservation has been deleted!")
 	if year == "" || month == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations_%s", src), http.StatusSeeOther)
 	} else {
@@ -668,6 +1099,44 @@ func (rep *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// AdminTrash lists the deleted reservations that have not been purged yet
+func (rep *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	reservations, err := rep.DB.DeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	intMap := make(map[string]int)
+	intMap["retention_days"] = int(rep.App.TrashRetention.Hours() / 24)
+
+	render.Template(w, "admin_reservations_trash.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminRestoreReservation takes a reservation out of the trash, unless its dates have been taken
+// in the meantime
+func (rep *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := rep.DB.RestoreReservation(id)
+	switch {
+	case errors.Is(err, repository.ErrRoomUnavailable):
+		rep.App.Session.Put(r.Context(), "error", "The room has been booked or blocked for some of these nights since, so the reservation cannot be restored")
+	case errors.Is(err, sql.ErrNoRows):
+		rep.App.Session.Put(r.Context(), "error", "Reservation not found in the trash")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		rep.audit(r, models.AuditRestore, models.EntityReservation, id, nil, nil)
+		rep.App.Session.Put(r.Context(), "flash", "Reservation restored!")
+	}
+	http.Redirect(w, r, "/admin/reservations_trash", http.StatusSeeOther)
+}
+
 func (rep *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -694,11 +1163,21 @@ func (rep *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *h
 				// that are not in the form post data
 				if val > 0 {
 					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						err := rep.DB.DeleteBlockById(value)
+						block, err := rep.DB.GetBlockById(value)
+						if errors.Is(err, sql.ErrNoRows) {
+							// removed since the calendar was shown
+							continue
+						}
 						if err != nil {
 							helpers.ServerError(w, err)
 							return
 						}
+						err = rep.DB.DeleteBlockById(value)
+						if err != nil {
+							helpers.ServerError(w, err)
+							return
+						}
+						rep.audit(r, models.AuditDelete, models.EntityBlock, block.ID, block, nil)
 					}
 				}
 			}
@@ -713,15 +1192,387 @@ func (rep *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *h
 				helpers.ServerError(w, err)
 				return
 			}
-			t, _ := time.Parse("2006-01-2", exp[3])
-			err = rep.DB.InsertBlockForRoom(roomId, t)
+			t, _ := time.Parse(calendarDayLayout, exp[3])
+			block := models.RoomRestriction{
+				RoomID:        roomId,
+				RestrictionID: models.RestrictionOwnerBlock,
+				StartDate:     t,
+				EndDate:       t.AddDate(0, 0, 1),
+			}
+			block.ID, err = rep.DB.InsertBlockForRoom(block.RoomID, block.StartDate, block.EndDate, "")
+			if errors.Is(err, repository.ErrRoomUnavailable) {
+				// booked since the calendar was shown
+				rep.App.Session.Put(r.Context(), "error", fmt.Sprintf("The room was no longer free on %s", t.Format("2006-01-02")))
+				continue
+			}
 			if err != nil {
 				helpers.ServerError(w, err)
 				return
 			}
+			rep.audit(r, models.AuditCreate, models.EntityBlock, block.ID, nil, block)
 		}
 	}
 
 	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations_calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 }
+
+// AdminAPITokens lists the API tokens that have been issued
+func (rep *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	rep.renderAPITokens(w, r, forms.New(nil), "", "")
+}
+
+// AdminPostAPIToken issues a new API token for the logged in user and shows it once. Given a room,
+// it issues a feed token that only reads that room's calendar feed, and shows the feed's URL.
+func (rep *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !helpers.IsAuthenticated(r) {
+		rep.App.Session.Put(r.Context(), "error", "Please log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	roomID := 0
+	if form.Has("room_id") {
+		roomID, err = strconv.Atoi(form.Get("room_id"))
+		if err == nil {
+			_, err = rep.DB.GetRoomById(roomID)
+		}
+		if errors.Is(err, sql.ErrNoRows) || roomID <= 0 {
+			form.Errors.Add("room_id", "No such room")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		rep.renderAPITokens(w, r, form, "", "")
+		return
+	}
+	plain, hash, err := tokens.New()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	token := models.APIToken{
+		UserID:    rep.App.Session.GetInt(r.Context(), "user_id"),
+		RoomID:    roomID,
+		Name:      form.Get("name"),
+		TokenHash: hash,
+	}
+	token.ID, err = rep.DB.InsertAPIToken(token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityAPIToken, token.ID, nil, token)
+	rep.App.Session.Put(r.Context(), "flash", "API token created!")
+	feedURL := ""
+	if roomID != 0 {
+		feedURL = fmt.Sprintf("%s/feeds/rooms/%d.ics?token=%s", rep.App.BaseURL, roomID, url.QueryEscape(plain))
+	}
+	rep.renderAPITokens(w, r, forms.New(nil), plain, feedURL)
+}
+
+// AdminRevokeAPIToken revokes an API token so it can no longer be used
+func (rep *Repository) AdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := rep.DB.RevokeAPIToken(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditRevoke, models.EntityAPIToken, id, nil, nil)
+	rep.App.Session.Put(r.Context(), "flash", "API token revoked!")
+	http.Redirect(w, r, "/admin/api_tokens", http.StatusSeeOther)
+}
+
+func (rep *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form, newToken, newFeedURL string) {
+	apiTokens, err := rep.DB.AllAPITokens()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := rep.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["api_tokens"] = apiTokens
+	data["rooms"] = rooms
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = newToken
+	stringMap["new_feed_url"] = newFeedURL
+	render.Template(w, "admin_api_tokens.page.tmpl", r, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// minPasswordLength is the shortest password accepted for a user
+const minPasswordLength = 8
+
+// AdminUsers lists every user
+func (rep *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := rep.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["users"] = users
+	render.Template(w, "admin_users.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewUser shows the form to create a user
+func (rep *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	rep.renderUserForm(w, r, models.User{AccessLevel: models.AccessLevelAuditor, Active: true}, forms.New(nil))
+}
+
+// AdminPostNewUser creates a user
+func (rep *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	u := userFromForm(r)
+	u.Active = true
+	form := forms.New(r.PostForm)
+	validateUserForm(form)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		rep.renderUserForm(w, r, u, form)
+		return
+	}
+	u.ID, err = rep.DB.InsertUser(u, form.Get("password"))
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "This email address is already in use")
+		rep.renderUserForm(w, r, u, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityUser, u.ID, nil, u)
+	rep.App.Session.Put(r.Context(), "flash", "User created!")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminShowUser shows the form to edit a user
+func (rep *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	u, err := rep.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.renderUserForm(w, r, u, forms.New(nil))
+}
+
+// AdminPostUser saves changes to a user's details and role
+func (rep *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	u, err := rep.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := u
+	posted := userFromForm(r)
+	u.FirstName = posted.FirstName
+	u.LastName = posted.LastName
+	u.Email = posted.Email
+	u.AccessLevel = posted.AccessLevel
+
+	form := forms.New(r.PostForm)
+	validateUserForm(form)
+	if current, ok := helpers.AuthUser(r); ok && current.ID == u.ID && u.AccessLevel != current.AccessLevel {
+		form.Errors.Add("access_level", "You cannot change your own role")
+	}
+	if !form.Valid() {
+		rep.renderUserForm(w, r, u, form)
+		return
+	}
+	err = rep.DB.UpdateUser(u)
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "This email address is already in use")
+		rep.renderUserForm(w, r, u, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityUser, u.ID, before, u)
+	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminPostUserPassword sets a new password for a user
+func (rep *Repository) AdminPostUserPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	u, err := rep.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		rep.renderUserForm(w, r, u, form)
+		return
+	}
+	err = rep.DB.UpdatePassword(u.ID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditPassword, models.EntityUser, u.ID, nil, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Password changed!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", u.ID), http.StatusSeeOther)
+}
+
+// AdminDeactivateUser stops a user from logging in
+func (rep *Repository) AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if current, ok := helpers.AuthUser(r); ok && current.ID == id {
+		rep.App.Session.Put(r.Context(), "error", "You cannot deactivate your own account")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	err := rep.DB.SetUserActive(id, false)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDeactivate, models.EntityUser, id, models.User{Active: true}, models.User{Active: false})
+	rep.App.Session.Put(r.Context(), "flash", "User deactivated!")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminActivateUser lets a deactivated user log in again
+func (rep *Repository) AdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := rep.DB.SetUserActive(id, true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditActivate, models.EntityUser, id, models.User{Active: false}, models.User{Active: true})
+	rep.App.Session.Put(r.Context(), "flash", "User activated!")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminChangePassword shows the form for the logged in user to change their own password
+func (rep *Repository) AdminChangePassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, "admin_change_password.page.tmpl", r, &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostChangePassword changes the logged in user's password after checking the current one
+func (rep *Repository) AdminPostChangePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	current, ok := helpers.AuthUser(r)
+	if !ok {
+		rep.App.Session.Put(r.Context(), "error", "Please log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password_confirm", "password")
+	if form.Valid() {
+		_, _, err = rep.DB.Authenticate(current.Email, form.Get("current_password"))
+		if err != nil {
+			form.Errors.Add("current_password", "Incorrect password")
+		}
+	}
+	if !form.Valid() {
+		render.Template(w, "admin_change_password.page.tmpl", r, &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+	err = rep.DB.UpdatePassword(current.ID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditPassword, models.EntityUser, current.ID, nil, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Password changed!")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// userFromForm reads the user details posted from the user form
+func userFromForm(r *http.Request) models.User {
+	accessLevel, _ := strconv.Atoi(r.Form.Get("access_level"))
+	return models.User{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Email:       r.Form.Get("email"),
+		AccessLevel: accessLevel,
+	}
+}
+
+// validateUserForm checks the fields shared by the create and edit user forms
+func validateUserForm(form *forms.Form) {
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+	accessLevel, _ := strconv.Atoi(form.Get("access_level"))
+	if !models.IsValidAccessLevel(accessLevel) {
+		form.Errors.Add("access_level", "Please choose a role")
+	}
+}
+
+func (rep *Repository) renderUserForm(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["roles"] = models.Roles()
+	render.Template(w, "admin_user.page.tmpl", r, &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
@@ -14,7 +14,10 @@ import (
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/driver"
+	"github.com/Ed-cred/bookings/internal/helpers"
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/tokens"
+	"github.com/go-chi/chi"
 )
 
 var theTests = []struct {
@@ -36,9 +39,18 @@ var theTests = []struct {
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"new_res", "/admin/reservations_new", "GET", http.StatusOK},
 	{"all_res", "/admin/reservations_all", "GET", http.StatusOK},
+	{"all_res_checked_in", "/admin/reservations_all?status=checked_in", "GET", http.StatusOK},
+	{"all_res_bad_status", "/admin/reservations_all?status=archived", "GET", http.StatusBadRequest},
+	{"trash", "/admin/reservations_trash", "GET", http.StatusOK},
 	{"show_res", "/admin/reservations/new/10/show", "GET", http.StatusOK},
 	{"show_res_cal", "/admin/reservations_calendar", "GET", http.StatusOK},
 	{"show_res_cal_with_params", "/admin/reservations_calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"api_tokens", "/admin/api_tokens", "GET", http.StatusOK},
+	{"users", "/admin/users", "GET", http.StatusOK},
+	{"new_user", "/admin/users/new", "GET", http.StatusOK},
+	{"show_user", "/admin/users/2/show", "GET", http.StatusOK},
+	{"change_password", "/admin/password", "GET", http.StatusOK},
+	{"forgot_password", "/user/forgot-password", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -63,7 +75,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepoReservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		RoomID:    1,
+		StartDate: time.Date(2050, 3, 7, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 3, 9, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -80,6 +94,26 @@ func TestRepoReservation(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Reservation handler returned %v, expected %v", rr.Code, http.StatusOK)
 	}
+	if !strings.Contains(rr.Body.String(), "$240.00") {
+		t.Error("Reservation handler did not show the price of two nights at $120.00")
+	}
+	if quoted := session.Get(ctx, "reservation").(models.Reservation); quoted.TotalPrice != 24000 {
+		t.Errorf("Reservation handler stored a total price of %d, expected 24000", quoted.TotalPrice)
+	}
+
+	// test stay shorter than the minimum stay of the festival rate
+	req, _ = http.NewRequest("GET", "/make_reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	short := reservation
+	short.StartDate = time.Date(2050, 7, 2, 0, 0, 0, 0, time.UTC)
+	short.EndDate = time.Date(2050, 7, 3, 0, 0, 0, 0, time.UTC)
+	session.Put(ctx, "reservation", short)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned %v for a stay below the minimum stay, expected %v", rr.Code, http.StatusSeeOther)
+	}
 	// test case when reservation is not in session
 	req, _ = http.NewRequest("GET", "/make_reservation", nil)
 	ctx = getCtx(req)
@@ -142,6 +176,41 @@ func TestRepoPostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned %v for correct request, expected %v", rr.Code, http.StatusSeeOther)
 	}
+	if location, _ := rr.Result().Location(); location.String() != "/reservation_summary" {
+		t.Errorf("PostReservation handler redirected to %s for a free stay, expected /reservation_summary", location.String())
+	}
+
+	// test priced stay continues to the deposit
+	priced := reservation
+	priced.RoomID = 1
+	req, _ = http.NewRequest("POST", "/make_reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", priced)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if location, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || location.String() != "/make_payment" {
+		t.Errorf("PostReservation handler returned %v to %s for a priced stay, expected %v to /make_payment", rr.Code, location.String(), http.StatusSeeOther)
+	}
+	if booked := session.Get(ctx, "reservation").(models.Reservation); booked.PaymentStatus != models.PaymentPending || booked.TotalPrice != 12000 {
+		t.Errorf("PostReservation handler stored payment %q and total %d, expected pending and 12000", booked.PaymentStatus, booked.TotalPrice)
+	}
+
+	// test priced stay needs no deposit without a payment provider
+	gateway := app.Payments
+	app.Payments = nil
+	req, _ = http.NewRequest("POST", "/make_reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", priced)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if location, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || location.String() != "/reservation_summary" {
+		t.Errorf("PostReservation handler returned %v to %s without a payment provider, expected %v to /reservation_summary", rr.Code, location.String(), http.StatusSeeOther)
+	}
+	app.Payments = gateway
 
 	// test for missing post body
 	req, _ = http.NewRequest("POST", "/make_reservation", nil)
@@ -265,6 +334,31 @@ func TestRepoPostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation handler returned %v for failing to insert reservation, expected %v", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// test room booked by someone else in the meantime
+	reservation.RoomID = 5
+
+	postedData = url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "076859432")
+
+	req, _ = http.NewRequest("POST", "/make_reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned %v for unavailable room, expected %v", rr.Code, http.StatusSeeOther)
+	}
+	location, _ := rr.Result().Location()
+	if location.String() != "/search_availability" {
+		t.Errorf("PostReservation handler redirected to %s for unavailable room, expected /search_availability", location.String())
+	}
 }
 
 func TestRepoAvailabilityJSON(t *testing.T) {
@@ -367,6 +461,44 @@ func TestRepoAvailabilityJSON(t *testing.T) {
 	if j.Ok != false && j.Message != "Error querying database" {
 		t.Errorf("AvailablityJSON handler returned %v and %v for bad db query, expected %v and Error querying database", j.Ok, j.Message, false)
 	}
+
+	// case: Room is available and the stay is priced
+	j = jsonResponse{}
+	reqBody = "start=2050-03-07"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-03-09")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+	req, _ = http.NewRequest("POST", "/search_availablity-json", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	err = json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse JSON")
+	}
+	if !j.Ok || j.Total != 24000 || j.Price != "$240.00" {
+		t.Errorf("AvailablityJSON handler returned %v, %d and %s for an available room, expected true, 24000 and $240.00", j.Ok, j.Total, j.Price)
+	}
+
+	// case: Room is free but the stay is shorter than the minimum stay
+	j = jsonResponse{}
+	reqBody = "start=2050-07-02"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-07-03")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+	req, _ = http.NewRequest("POST", "/search_availablity-json", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	err = json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse JSON")
+	}
+	if j.Ok || !strings.Contains(j.Message, "at least 3 nights") {
+		t.Errorf("AvailablityJSON handler returned %v and %q for a stay below the minimum stay, expected false and the minimum stay", j.Ok, j.Message)
+	}
 }
 
 func TestRepoPostAvailability(t *testing.T) {
@@ -577,6 +709,7 @@ var loginTests = []struct {
 }{
 	{name: "valid_cred", email: "me@sosmart.com", expStatusCode: http.StatusSeeOther, expHTML: "", expLocation: "/"},
 	{name: "invalid_cred", email: "me@nosmart.ro", expStatusCode: http.StatusSeeOther, expHTML: "", expLocation: "/user/login"},
+	{name: "inactive_user", email: "gone@sosmart.com", expStatusCode: http.StatusSeeOther, expHTML: "", expLocation: "/user/login"},
 	{name: "invalid_data", email: "nosmart", expStatusCode: http.StatusOK, expHTML: "action='/user/login'", expLocation: ""},
 }
 
@@ -611,38 +744,105 @@ func TestRepoLogin(t *testing.T) {
 	}
 }
 
-func TestRepoAdminProcessReservation(t *testing.T) {
-	// case: coming from a page that does not need time data aka new_res or all_res
-	src := "new"
-	id := 10
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process_reservation/%s/%d/do", src, id), nil)
-	expLocation := fmt.Sprintf("/admin/reservations_%s", src)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(Repo.AdminProcessReservation)
-	handler.ServeHTTP(rr, req)
-	location, _ := rr.Result().Location()
-	if rr.Code != http.StatusSeeOther && expLocation != location.String() {
-		t.Errorf("Failed ProcessReservation: returned %v and %s, expected %v and %s", rr.Code, location.String(), http.StatusSeeOther, expLocation)
+var passwordResetTests = []struct {
+	name          string
+	method        string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+}{
+	{"forgot_known_email", "POST", "/user/forgot-password", url.Values{"email": {"me@sosmart.com"}}, http.StatusSeeOther, "/user/login"},
+	{"forgot_unknown_email", "POST", "/user/forgot-password", url.Values{"email": {"nobody@sosmart.com"}}, http.StatusSeeOther, "/user/login"},
+	{"forgot_invalid_email", "POST", "/user/forgot-password", url.Values{"email": {"nobody"}}, http.StatusOK, ""},
+	{"reset_form", "GET", "/user/reset-password?token=" + resetToken(1, time.Hour), nil, http.StatusOK, ""},
+	{"reset_form_expired", "GET", "/user/reset-password?token=" + resetToken(1, -time.Hour), nil, http.StatusSeeOther, "/user/forgot-password"},
+	{"reset_form_unknown_user", "GET", "/user/reset-password?token=" + resetToken(2000, time.Hour), nil, http.StatusSeeOther, "/user/forgot-password"},
+	{"reset_form_bad_token", "GET", "/user/reset-password?token=garbage", nil, http.StatusSeeOther, "/user/forgot-password"},
+	{
+		"reset", "POST", "/user/reset-password",
+		url.Values{"token": {resetToken(1, time.Hour)}, "password": {"password123"}, "password_confirm": {"password123"}},
+		http.StatusSeeOther, "/user/login",
+	},
+	{
+		"reset_password_mismatch", "POST", "/user/reset-password",
+		url.Values{"token": {resetToken(1, time.Hour)}, "password": {"password123"}, "password_confirm": {"password321"}},
+		http.StatusOK, "",
+	},
+	{
+		"reset_expired", "POST", "/user/reset-password",
+		url.Values{"token": {resetToken(1, -time.Hour)}, "password": {"password123"}, "password_confirm": {"password123"}},
+		http.StatusSeeOther, "/user/forgot-password",
+	},
+}
+
+// resetToken signs a reset token the same way PostForgotPassword does for the test repo's users
+func resetToken(userID int, ttl time.Duration) string {
+	return tokens.NewSigned([]byte("test-signing-key"), userID, time.Now().Add(ttl), "")
+}
+
+func TestRepoPasswordReset(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range passwordResetTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
 	}
+}
 
-	// case: coming from calendar page
-	src = "cal"
-	id = 13
-	year := "2023"
-	month := "08"
+var changeStatusTests = []struct {
+	name        string
+	url         string
+	postedData  url.Values
+	expLocation string
+	expFlash    string
+	expError    string
+}{
+	{"confirm_from_list", "/admin/reservations/new/10/status", url.Values{"status": {"confirmed"}}, "/admin/reservations_new", "Reservation marked confirmed!", ""},
+	{
+		"check_in_from_calendar", "/admin/reservations/cal/13/status", url.Values{"status": {"checked_in"}, "year": {"2050"}, "month": {"01"}},
+		"/admin/reservations_calendar?y=2050&m=01", "Reservation marked checked in!", "",
+	},
+	{"check_out_pending", "/admin/reservations/all/10/status", url.Values{"status": {"checked_out"}}, "/admin/reservations_all", "", "cannot be marked checked out"},
+	{"unknown_status", "/admin/reservations/all/10/status", url.Values{"status": {"archived"}}, "/admin/reservations_all", "", "cannot be marked archived"},
+	{"unknown_reservation", "/admin/reservations/all/2000/status", url.Values{"status": {"confirmed"}}, "/admin/reservations_all", "", "Reservation not found"},
+}
 
-	req, _ = http.NewRequest("GET", fmt.Sprintf("/admin/process_reservation/%s/%d/do?y=%s&m=%s", src, id, year, month), nil)
-	expLocation = fmt.Sprintf("/admin/reservations_calendar?y=%s&m=%s", year, month)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(Repo.AdminProcessReservation)
-	handler.ServeHTTP(rr, req)
-	location, _ = rr.Result().Location()
-	if rr.Code != http.StatusSeeOther && expLocation != location.String() {
-		t.Errorf("Failed ProcessReservation: returned %v and %s, expected %v and %s", rr.Code, location.String(), http.StatusSeeOther, expLocation)
+func TestRepoAdminChangeReservationStatus(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range changeStatusTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		location, _ := rr.Result().Location()
+		if location.String() != e.expLocation {
+			t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expFlash {
+			t.Errorf("Failed %s: expected flash %q, got %q", e.name, e.expFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); !strings.Contains(msg, e.expError) || (e.expError == "" && msg != "") {
+			t.Errorf("Failed %s: expected error %q, got %q", e.name, e.expError, msg)
+		}
 	}
 }
 
@@ -681,6 +881,39 @@ func TestRepoAdminDeleteReservation(t *testing.T) {
 	}
 }
 
+var restoreReservationTests = []struct {
+	name     string
+	id       int
+	expFlash string
+	expError string
+}{
+	{"restored", 14, "Reservation restored!", ""},
+	{"dates_taken", 15, "", "cannot be restored"},
+	{"not_in_trash", 99, "", "not found in the trash"},
+}
+
+func TestRepoAdminRestoreReservation(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range restoreReservationTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservations_trash/%d/restore", e.id), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		location, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || location.String() != "/admin/reservations_trash" {
+			t.Errorf("Failed %s: expected a redirect to the trash, got %d to %s", e.name, rr.Code, location.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expFlash {
+			t.Errorf("Failed %s: expected flash %q, got %q", e.name, e.expFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); !strings.Contains(msg, e.expError) || (e.expError == "" && msg != "") {
+			t.Errorf("Failed %s: expected error %q, got %q", e.name, e.expError, msg)
+		}
+	}
+}
+
 var adminPostReservationTests = []struct {
 	name          string
 	pageSrc       string
@@ -815,6 +1048,321 @@ func TestRepoAdminPostCalendar(t *testing.T) {
 	}
 }
 
+var adminShowPermissionTests = []struct {
+	name        string
+	accessLevel int
+	expHTML     []string
+	notExpHTML  []string
+}{
+	{"owner", models.AccessLevelOwner, []string{"Delete Reservation", "Mark as Confirmed", "Mark as Cancelled", `value="Save"`}, nil},
+	{"staff", models.AccessLevelStaff, []string{"Mark as Confirmed", `value="Save"`}, []string{"Delete Reservation", "Mark as Checked out"}},
+	{"auditor", models.AccessLevelAuditor, nil, []string{"Delete Reservation", "Mark as Confirmed", `value="Save"`}},
+}
+
+func TestRepoAdminShowReservationPermissions(t *testing.T) {
+	for _, e := range adminShowPermissionTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/10/show", nil)
+		req.RequestURI = "/admin/reservations/all/10/show"
+		ctx := getCtx(req)
+		ctx = helpers.WithUser(ctx, models.User{ID: 1, AccessLevel: e.accessLevel})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminShowReservation)
+		handler.ServeHTTP(rr, req)
+		html := rr.Body.String()
+		for _, x := range e.expHTML {
+			if !strings.Contains(html, x) {
+				t.Errorf("Failed %s: expected page to contain %s", e.name, x)
+			}
+		}
+		for _, x := range e.notExpHTML {
+			if strings.Contains(html, x) {
+				t.Errorf("Failed %s: expected page not to contain %s", e.name, x)
+			}
+		}
+	}
+}
+
+func TestRepoAdminPostAPIToken(t *testing.T) {
+	// case: valid name from a logged in user, the token is shown once
+	postedData := url.Values{}
+	postedData.Add("name", "channel manager")
+	req, _ := http.NewRequest("POST", "/admin/api_tokens", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostAPIToken)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostAPIToken returned %v for valid data, expected %v", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "it will not be shown again") {
+		t.Error("AdminPostAPIToken did not show the new token")
+	}
+
+	// case: missing name
+	req, _ = http.NewRequest("POST", "/admin/api_tokens", strings.NewReader(url.Values{}.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostAPIToken returned %v for missing name, expected %v", rr.Code, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), "it will not be shown again") {
+		t.Error("AdminPostAPIToken issued a token for an invalid form")
+	}
+
+	// case: a feed token for room 1, shown with the feed's URL
+	feedData := url.Values{"name": {"front desk calendar"}, "room_id": {"1"}}
+	req, _ = http.NewRequest("POST", "/admin/api_tokens", strings.NewReader(feedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "/feeds/rooms/1.ics?token=") {
+		t.Error("AdminPostAPIToken did not show the feed URL of a feed token")
+	}
+
+	// case: a feed token for a room that does not exist
+	feedData.Set("room_id", "99")
+	req, _ = http.NewRequest("POST", "/admin/api_tokens", strings.NewReader(feedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), "it will not be shown again") {
+		t.Error("AdminPostAPIToken issued a feed token for an unknown room")
+	}
+
+	// case: not logged in
+	req, _ = http.NewRequest("POST", "/admin/api_tokens", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostAPIToken returned %v without a session user, expected %v", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepoAdminRevokeAPIToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/api_tokens/1/revoke", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminRevokeAPIToken)
+	handler.ServeHTTP(rr, req)
+	location, _ := rr.Result().Location()
+	if rr.Code != http.StatusSeeOther || location.String() != "/admin/api_tokens" {
+		t.Errorf("AdminRevokeAPIToken returned %v and %s, expected %v and /admin/api_tokens", rr.Code, location.String(), http.StatusSeeOther)
+	}
+}
+
+var adminUserTests = []struct {
+	name          string
+	url           string
+	id            string
+	handler       func(*Repository, http.ResponseWriter, *http.Request)
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expHTML       string
+}{
+	{
+		name:    "create_user",
+		url:     "/admin/users/new",
+		handler: (*Repository).AdminPostNewUser,
+		postedData: url.Values{
+			"first_name":       {"Front"},
+			"last_name":        {"Desk"},
+			"email":            {"desk@here.com"},
+			"access_level":     {"2"},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		},
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users",
+	},
+	{
+		name:    "create_user_password_mismatch",
+		url:     "/admin/users/new",
+		handler: (*Repository).AdminPostNewUser,
+		postedData: url.Values{
+			"first_name":       {"Front"},
+			"last_name":        {"Desk"},
+			"email":            {"desk@here.com"},
+			"access_level":     {"2"},
+			"password":         {"password123"},
+			"password_confirm": {"password321"},
+		},
+		expStatusCode: http.StatusOK,
+		expHTML:       "The values do not match",
+	},
+	{
+		name:    "create_user_duplicate_email",
+		url:     "/admin/users/new",
+		handler: (*Repository).AdminPostNewUser,
+		postedData: url.Values{
+			"first_name":       {"Front"},
+			"last_name":        {"Desk"},
+			"email":            {"taken@here.com"},
+			"access_level":     {"2"},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		},
+		expStatusCode: http.StatusOK,
+		expHTML:       "already in use",
+	},
+	{
+		name:    "create_user_bad_role",
+		url:     "/admin/users/new",
+		handler: (*Repository).AdminPostNewUser,
+		postedData: url.Values{
+			"first_name":       {"Front"},
+			"last_name":        {"Desk"},
+			"email":            {"desk@here.com"},
+			"access_level":     {"9"},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		},
+		expStatusCode: http.StatusOK,
+		expHTML:       "Please choose a role",
+	},
+	{
+		name:    "update_user",
+		url:     "/admin/users/2",
+		id:      "2",
+		handler: (*Repository).AdminPostUser,
+		postedData: url.Values{
+			"first_name":   {"Front"},
+			"last_name":    {"Desk"},
+			"email":        {"desk@here.com"},
+			"access_level": {"1"},
+		},
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users",
+	},
+	{
+		name:    "update_own_role",
+		url:     "/admin/users/1",
+		id:      "1",
+		handler: (*Repository).AdminPostUser,
+		postedData: url.Values{
+			"first_name":   {"Admin"},
+			"last_name":    {"User"},
+			"email":        {"admin@here.com"},
+			"access_level": {"1"},
+		},
+		expStatusCode: http.StatusOK,
+		expHTML:       "You cannot change your own role",
+	},
+	{
+		name:    "set_password",
+		url:     "/admin/users/2/password",
+		id:      "2",
+		handler: (*Repository).AdminPostUserPassword,
+		postedData: url.Values{
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		},
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users/2/show",
+	},
+	{
+		name:    "set_password_too_short",
+		url:     "/admin/users/2/password",
+		id:      "2",
+		handler: (*Repository).AdminPostUserPassword,
+		postedData: url.Values{
+			"password":         {"short"},
+			"password_confirm": {"short"},
+		},
+		expStatusCode: http.StatusOK,
+		expHTML:       "at least 8 characters",
+	},
+	{
+		name:          "deactivate_user",
+		url:           "/admin/users/2/deactivate",
+		postedData:    url.Values{},
+		id:            "2",
+		handler: (*Repository).AdminDeactivateUser,
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users",
+	},
+	{
+		name:          "deactivate_self",
+		url:           "/admin/users/1/deactivate",
+		postedData:    url.Values{},
+		id:            "1",
+		handler:       (*Repository).AdminDeactivateUser,
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users",
+	},
+	{
+		name:          "activate_user",
+		url:           "/admin/users/2/activate",
+		postedData:    url.Values{},
+		id:            "2",
+		handler: (*Repository).AdminActivateUser,
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/users",
+	},
+	{
+		name:    "change_own_password",
+		url:     "/admin/password",
+		handler: (*Repository).AdminPostChangePassword,
+		postedData: url.Values{
+			"current_password": {"old-password"},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		},
+		expStatusCode: http.StatusSeeOther,
+		expLocation:   "/admin/dashboard",
+	},
+}
+
+func TestRepoAdminUsers(t *testing.T) {
+	for _, e := range adminUserTests {
+		method := "GET"
+		if e.postedData != nil {
+			method = "POST"
+		}
+		req, _ := http.NewRequest(method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		ctx = helpers.WithUser(ctx, models.User{ID: 1, Email: "me@sosmart.com", AccessLevel: models.AccessLevelOwner, Active: true})
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
+
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
 	if err != nil {
@@ -822,3 +1370,97 @@ func getCtx(req *http.Request) context.Context {
 	}
 	return ctx
 }
+
+var reservationListTests = []struct {
+	name          string
+	url           string
+	expStatusCode int
+	expHTML       []string
+	notExpHTML    []string
+}{
+	{"first_page", "/admin/reservations_all", http.StatusOK, []string{"Showing 1 to 25 of 30", "Page 1 of 2", "/admin/reservations_all?page=2"}, nil},
+	{"second_page", "/admin/reservations_all?page=2", http.StatusOK, []string{"Showing 26 to 30 of 30", "/admin/reservations_all?page=1"}, nil},
+	{"past_the_end", "/admin/reservations_all?page=5", http.StatusOK, []string{"No reservations match."}, nil},
+	{"search", "/admin/reservations_all?q=JANE", http.StatusOK, []string{"Showing 1 to 10 of 10", "Doe, Jane"}, []string{"Smith, John"}},
+	{"by_email", "/admin/reservations_all?q=smith.com", http.StatusOK, []string{"of 20"}, []string{"Doe, Jane"}},
+	{"by_room", "/admin/reservations_all?room=2", http.StatusOK, []string{"of 10", "Doe, Jane"}, []string{"Smith, John"}},
+	{"by_status", "/admin/reservations_all?status=confirmed", http.StatusOK, []string{"of 20"}, []string{"Doe, Jane"}},
+	{"by_dates", "/admin/reservations_all?from=2050-01-10&to=2050-01-12", http.StatusOK, []string{"Showing 1 to 3 of 3"}, nil},
+	{"new_is_pending", "/admin/reservations_new?status=confirmed", http.StatusOK, []string{"of 10", "Doe, Jane"}, []string{"Smith, John"}},
+	{
+		"links_keep_filters", "/admin/reservations_all?q=o&page=1", http.StatusOK,
+		[]string{"/admin/reservations_all?dir=asc&amp;q=o&amp;sort=name", "/admin/reservations_all?page=2&amp;q=o", "/admin/reservations_all?q=o&amp;status=pending"},
+		nil,
+	},
+	{"sorted_by_name", "/admin/reservations_all?sort=name", http.StatusOK, []string{"Guest ▲", "dir=desc&amp;sort=name"}, nil},
+	{"sorted_by_start_date", "/admin/reservations_all", http.StatusOK, []string{"Arrival ▼", "dir=asc&amp;sort=start_date"}, nil},
+	{"bad_sort", "/admin/reservations_all?sort=price", http.StatusBadRequest, nil, nil},
+	{"bad_dir", "/admin/reservations_all?dir=up", http.StatusBadRequest, nil, nil},
+	{"bad_page", "/admin/reservations_all?page=0", http.StatusBadRequest, nil, nil},
+	{"bad_room", "/admin/reservations_all?room=two", http.StatusBadRequest, nil, nil},
+	{"bad_date", "/admin/reservations_new?from=tomorrow", http.StatusBadRequest, nil, nil},
+	{"search_fails", "/admin/reservations_all?room=1000", http.StatusInternalServerError, nil, nil},
+}
+
+func TestRepoAdminReservationLists(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range reservationListTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		for _, want := range e.expHTML {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Failed %s: expected page to contain %q", e.name, want)
+			}
+		}
+		for _, unwanted := range e.notExpHTML {
+			if strings.Contains(rr.Body.String(), unwanted) {
+				t.Errorf("Failed %s: expected page not to contain %q", e.name, unwanted)
+			}
+		}
+	}
+}
+
+var guestSearchTests = []struct {
+	name          string
+	url           string
+	expStatusCode int
+	expHTML       []string
+	notExpHTML    []string
+}{
+	{"empty", "/admin/reservations_search", http.StatusOK, nil, []string{"No guests match"}},
+	{"too_short", "/admin/reservations_search?q=j", http.StatusOK, []string{"at least 2 characters"}, []string{"No guests match"}},
+	{"by_name", "/admin/reservations_search?q=Jane", http.StatusOK, []string{"/admin/reservations/search/3/show", "Jane Doe"}, []string{"John Smith"}},
+	{"by_email", "/admin/reservations_search?q=smith.com", http.StatusOK, []string{"/admin/reservations/search/1/show"}, []string{"Jane Doe"}},
+	{"no_match", "/admin/reservations_search?q=nobody", http.StatusOK, []string{"No guests match"}, nil},
+	{"fails", "/admin/reservations_search?q=fail", http.StatusInternalServerError, nil, nil},
+}
+
+func TestRepoAdminSearchReservations(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range guestSearchTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+			continue
+		}
+		for _, want := range e.expHTML {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Failed %s: expected page to contain %q", e.name, want)
+			}
+		}
+		for _, unwanted := range e.notExpHTML {
+			if strings.Contains(rr.Body.String(), unwanted) {
+				t.Errorf("Failed %s: expected page not to contain %q", e.name, unwanted)
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/payments"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// depositPercent is the share of the total price taken as a deposit when booking
+const depositPercent = 20
+
+// maxWebhookSize is the largest webhook body read from the payment provider, in bytes
+const maxWebhookSize = 64 << 10
+
+// deposit returns the deposit due for a stay, rounded up to the cent
+func deposit(totalPrice int) int {
+	return (totalPrice*depositPercent + 99) / 100
+}
+
+// depositDue returns the deposit a new booking must pay, none when no payment provider is configured
+func (rep *Repository) depositDue(totalPrice int) int {
+	if rep.App.Payments == nil {
+		return 0
+	}
+	return deposit(totalPrice)
+}
+
+// Payment asks the guest for the deposit of the reservation they have just made
+func (rep *Repository) Payment(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.reservationAwaitingPayment(w, r)
+	if !ok {
+		return
+	}
+	rep.renderPayment(w, r, res, forms.New(nil))
+}
+
+// PostPayment takes the deposit. The amount is authorized and captured straight away, so the
+// guest learns at once whether the card was accepted; a declined card releases the dates. The
+// deposit is claimed before the card is charged, so a double click or a second tab cannot charge
+// it twice.
+func (rep *Repository) PostPayment(w http.ResponseWriter, r *http.Request) {
+	res, ok := rep.reservationAwaitingPayment(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("payment_token")
+	if !form.Valid() {
+		rep.renderPayment(w, r, res, form)
+		return
+	}
+
+	err = rep.DB.ChangeReservationPayment(res.ID, models.PaymentPending, models.PaymentProcessing, "", 0)
+	if errors.Is(err, repository.ErrPaymentChanged) {
+		rep.App.Session.Put(r.Context(), "error", "The deposit for this reservation is already being paid")
+		http.Redirect(w, r, guestReservationPath(res), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	amount := deposit(res.TotalPrice)
+	auth, err := rep.App.Payments.Authorize(payments.Charge{
+		Amount:      amount,
+		Token:       form.Get("payment_token"),
+		Reference:   res.ConfirmationCode,
+		Description: fmt.Sprintf("Deposit for reservation %s", res.ConfirmationCode),
+		// the provider charges a confirmation code once, however many times it is sent
+		IdempotencyKey: res.ConfirmationCode,
+	})
+	if errors.Is(err, payments.ErrDeclined) {
+		// release the dates rather than keep them from other guests; the guest can book them
+		// again with another card while they are free
+		err = rep.DB.UpdateReservationPayment(res.ID, models.PaymentDeclined, "", 0)
+		if err == nil {
+			err = rep.DB.UpdateReservationStatus(res.ID, models.StatusCancelled, 0)
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		res.ID = 0
+		res.ConfirmationCode = ""
+		res.PaymentStatus = ""
+		rep.App.Session.Put(r.Context(), "reservation", res)
+		rep.App.Session.Put(r.Context(), "error", "Your card was declined and the dates were released, please book again with another card")
+		http.Redirect(w, r, "/make_reservation", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		// nothing was charged, so let the guest try again
+		if dbErr := rep.DB.UpdateReservationPayment(res.ID, models.PaymentPending, "", 0); dbErr != nil {
+			rep.App.ErrorLog.Println(dbErr)
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = rep.App.Payments.Capture(auth.ID, auth.Amount)
+	if err != nil {
+		// keep the authorization on record so the owner can capture or release it with the provider
+		if dbErr := rep.DB.UpdateReservationPayment(res.ID, models.PaymentAuthorized, auth.ID, 0); dbErr != nil {
+			rep.App.ErrorLog.Println(dbErr)
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	res.PaymentStatus = models.PaymentPaid
+	res.PaymentID = auth.ID
+	res.AmountPaid = auth.Amount
+	err = rep.DB.UpdateReservationPayment(res.ID, res.PaymentStatus, res.PaymentID, res.AmountPaid)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the booking is only confirmed to the guest and the owner once the deposit is taken
+	rep.queueEmail(res.Email, rep.App.MailFrom, mailer.Confirmation{Reservation: res, ManageURL: rep.GuestReservationURL(res)})
+	rep.queueEmail(rep.App.OwnerEmail, rep.App.StaffMailFrom, mailer.OwnerNotification{Reservation: res})
+	rep.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/reservation_summary", http.StatusSeeOther)
+}
+
+// reservationAwaitingPayment loads the guest's new reservation from the session. If there is none,
+// it needs no payment, or its hold has ended, the response has already been written and ok is false.
+func (rep *Repository) reservationAwaitingPayment(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, ok := rep.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.ID == 0 {
+		rep.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return res, false
+	}
+	if !res.AwaitingPayment() || rep.App.Payments == nil {
+		http.Redirect(w, r, "/reservation_summary", http.StatusSeeOther)
+		return res, false
+	}
+	held, err := rep.DB.FetchReservationById(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+	// stop taking deposits a minute before the hold ends, so the dates cannot be released while
+	// the provider is still charging the card
+	if held.Cancelled() || time.Since(held.CreatedAt) > models.PaymentHold-time.Minute {
+		rep.App.Session.Remove(r.Context(), "reservation")
+		rep.App.Session.Put(r.Context(), "error", "Sorry, the deposit was not paid in time and the dates were released, please book again")
+		http.Redirect(w, r, "/search_availability", http.StatusSeeOther)
+		return res, false
+	}
+	return res, true
+}
+
+func (rep *Repository) renderPayment(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	intMap := make(map[string]int)
+	intMap["deposit"] = deposit(res.TotalPrice)
+	intMap["deposit_percent"] = depositPercent
+	intMap["hold_minutes"] = int(models.PaymentHold / time.Minute)
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	switch rep.App.Payments.(type) {
+	case *payments.Fake:
+		stringMap["fake_payments"] = "1"
+	case *payments.Stripe:
+		stringMap["payment_key"] = rep.App.Payment.PublishableKey
+	}
+	render.Template(w, "make_payment.page.tmpl", r, &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		IntMap:    intMap,
+		StringMap: stringMap,
+	})
+}
+
+// refundDeposit returns the deposit of a paid reservation to the guest's card
+func (rep *Repository) refundDeposit(res models.Reservation) error {
+	if rep.App.Payments == nil {
+		return errors.New("no payment provider is configured")
+	}
+	err := rep.App.Payments.Refund(res.PaymentID, res.AmountPaid)
+	if err != nil {
+		return err
+	}
+	return rep.DB.UpdateReservationPayment(res.ID, models.PaymentRefunded, res.PaymentID, res.AmountPaid)
+}
+
+// AdminRefundReservation refunds the deposit of a reservation
+func (rep *Repository) AdminRefundReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id)
+	res, err := rep.DB.FetchReservationById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if res.PaymentStatus != models.PaymentPaid {
+		rep.App.Session.Put(r.Context(), "error", "This reservation has no deposit to refund")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	err = rep.refundDeposit(res)
+	if err != nil {
+		rep.App.ErrorLog.Println(err)
+		rep.App.Session.Put(r.Context(), "error", "The payment provider could not refund the deposit: "+err.Error())
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	refunded := res
+	refunded.PaymentStatus = models.PaymentRefunded
+	rep.audit(r, models.AuditRefund, models.EntityReservation, res.ID, res, refunded)
+	rep.App.Session.Put(r.Context(), "flash", "Deposit refunded!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// webhookTransitions is the payment status each webhook event moves a deposit to, and the statuses
+// it may move it from. A deposit is authorized when the app could not capture it itself, so the
+// provider may still report it captured or failed.
+var webhookTransitions = map[string]struct {
+	to   string
+	from []string
+}{
+	payments.EventCaptured: {models.PaymentPaid, []string{models.PaymentPending, models.PaymentAuthorized}},
+	payments.EventFailed:   {models.PaymentDeclined, []string{models.PaymentPending, models.PaymentAuthorized}},
+	payments.EventRefunded: {models.PaymentRefunded, []string{models.PaymentPaid}},
+}
+
+// PaymentWebhook records payment changes the provider reports after the fact, such as refunds
+// made from the provider's dashboard. An event that does not follow from the deposit's status,
+// such as a late failure of a deposit since paid, is acknowledged and ignored.
+func (rep *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if rep.App.Payments == nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	event, err := rep.App.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		rep.App.InfoLog.Println("Rejected payment webhook:", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	transition, ok := webhookTransitions[event.Type]
+	res, err := rep.DB.FetchReservationByPaymentID(event.PaymentID)
+	if errors.Is(err, sql.ErrNoRows) || !ok {
+		// not ours to act on; acknowledge it so the provider stops retrying
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	allowed := false
+	for _, from := range transition.from {
+		allowed = allowed || res.PaymentStatus == from
+	}
+	if !allowed {
+		rep.App.InfoLog.Printf("Ignored payment webhook %s for a %s deposit of reservation %d", event.Type, res.PaymentStatus, res.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	amountPaid := res.AmountPaid
+	if event.Type == payments.EventCaptured {
+		amountPaid = event.Amount
+	}
+	err = rep.DB.ChangeReservationPayment(res.ID, res.PaymentStatus, transition.to, res.PaymentID, amountPaid)
+	if errors.Is(err, repository.ErrPaymentChanged) {
+		// another request moved the deposit on while the event was being read
+		rep.App.InfoLog.Printf("Ignored payment webhook %s for reservation %d, its deposit changed meanwhile", event.Type, res.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/payments"
+	"github.com/Ed-cred/bookings/internal/repository"
+)
+
+// useFakePayments gives the test a fresh fake provider and restores the shared one afterwards
+func useFakePayments(t *testing.T) *payments.Fake {
+	fake := payments.NewFake([]byte(app.Payment.WebhookSecret))
+	previous := app.Payments
+	app.Payments = fake
+	t.Cleanup(func() { app.Payments = previous })
+	return fake
+}
+
+// withoutPayments leaves the test without a payment provider and restores the shared one afterwards
+func withoutPayments(t *testing.T) {
+	previous := app.Payments
+	app.Payments = nil
+	t.Cleanup(func() { app.Payments = previous })
+}
+
+func awaitingDeposit(code string) models.Reservation {
+	return models.Reservation{
+		ID:               20,
+		RoomID:           1,
+		ConfirmationCode: code,
+		TotalPrice:       24000,
+		PaymentStatus:    models.PaymentPending,
+		Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+}
+
+func TestRepoPayment(t *testing.T) {
+	useFakePayments(t)
+	awaiting := awaitingDeposit("PAYGET")
+	paid := awaitingDeposit("PAYGET")
+	paid.PaymentStatus = models.PaymentPaid
+
+	tests := []struct {
+		name          string
+		reservation   *models.Reservation
+		expStatusCode int
+		expLocation   string
+		expHTML       string
+	}{
+		{"awaiting_deposit", &awaiting, http.StatusOK, "", "$48.00"},
+		{"already_paid", &paid, http.StatusSeeOther, "/reservation_summary", ""},
+		{"no_reservation", nil, http.StatusTemporaryRedirect, "/", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/make_payment", nil)
+		ctx := getCtx(req)
+		if e.reservation != nil {
+			session.Put(ctx, "reservation", *e.reservation)
+		}
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.Payment).ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
+
+// holdSpy records the reservations cancelled and the emails queued through it, passing everything
+// else to the test repo
+type holdSpy struct {
+	repository.DbRepo
+	cancelled []int
+	queued    []models.MailData
+}
+
+func (s *holdSpy) UpdateReservationStatus(id int, status string, userID int) error {
+	if status == models.StatusCancelled {
+		s.cancelled = append(s.cancelled, id)
+	}
+	return s.DbRepo.UpdateReservationStatus(id, status, userID)
+}
+
+func (s *holdSpy) QueueEmail(m models.MailData) error {
+	s.queued = append(s.queued, m)
+	return nil
+}
+
+func TestRepoPostPayment(t *testing.T) {
+	fake := useFakePayments(t)
+	declined := awaitingDeposit("RETRY")
+	declined.PaymentStatus = models.PaymentDeclined
+	expired := awaitingDeposit("LATE")
+	expired.ID = 21
+	claimed := awaitingDeposit("TWICE")
+	claimed.ID = 22
+
+	tests := []struct {
+		name          string
+		reservation   models.Reservation
+		token         string
+		expStatusCode int
+		expLocation   string
+		expHTML       string
+		expPayment    string
+		expFakeState  string
+		// expReleased is whether the reservation is cancelled to free its dates
+		expReleased bool
+		// expEmails is how many emails are queued, the confirmation and the owner's once paid
+		expEmails int
+	}{
+		{"paid", awaitingDeposit("PAYOK"), payments.FakeTokenOK, http.StatusSeeOther, "/reservation_summary", "", models.PaymentPaid, payments.FakeCaptured, false, 2},
+		{"declined", awaitingDeposit("PAYNO"), payments.FakeTokenDeclined, http.StatusSeeOther, "/make_reservation", "", "", "", true, 0},
+		{"missing_token", awaitingDeposit("PAYNONE"), "", http.StatusOK, "", "cannot be blank", models.PaymentPending, "", false, 0},
+		{"retry_after_decline", declined, payments.FakeTokenOK, http.StatusSeeOther, "/reservation_summary", "", models.PaymentPaid, payments.FakeCaptured, false, 2},
+		{"already_claimed", claimed, payments.FakeTokenOK, http.StatusSeeOther, "/reservations/TWICE", "", models.PaymentPending, "", false, 0},
+		{"hold_expired", expired, payments.FakeTokenOK, http.StatusSeeOther, "/search_availability", "", "", "", false, 0},
+	}
+
+	for _, e := range tests {
+		spy := &holdSpy{DbRepo: Repo.DB}
+		Repo.DB = spy
+		postedData := url.Values{"payment_token": {e.token}}
+		req, _ := http.NewRequest("POST", "/make_payment", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", e.reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostPayment).ServeHTTP(rr, req)
+		Repo.DB = spy.DbRepo
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if location, _ := rr.Result().Location(); e.expLocation != "" && (location == nil || location.String() != e.expLocation) {
+			t.Errorf("Failed %s: expected location %s, got %v", e.name, e.expLocation, location)
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if res.PaymentStatus != e.expPayment {
+			t.Errorf("Failed %s: expected payment status %q, got %q", e.name, e.expPayment, res.PaymentStatus)
+		}
+		paymentID := "fake_" + e.reservation.ConfirmationCode
+		if state := fake.State(paymentID); state != e.expFakeState {
+			t.Errorf("Failed %s: expected the provider to have a %q payment, got %q", e.name, e.expFakeState, state)
+		}
+		if e.expPayment == models.PaymentPaid && (res.PaymentID != paymentID || res.AmountPaid != 4800) {
+			t.Errorf("Failed %s: expected payment %s of 4800, got %s of %d", e.name, paymentID, res.PaymentID, res.AmountPaid)
+		}
+		if released := len(spy.cancelled) == 1 && spy.cancelled[0] == e.reservation.ID; released != e.expReleased {
+			t.Errorf("Failed %s: expected released %v, got cancelled reservations %v", e.name, e.expReleased, spy.cancelled)
+		}
+		if e.expReleased && (res.ID != 0 || res.ConfirmationCode != "") {
+			t.Errorf("Failed %s: expected the guest to book again, got reservation %d in the session", e.name, res.ID)
+		}
+		if len(spy.queued) != e.expEmails {
+			t.Errorf("Failed %s: expected %d emails queued, got %d", e.name, e.expEmails, len(spy.queued))
+		}
+	}
+}
+
+// capturePaid takes the deposit of the test repo's "PAID" reservation with the fake provider
+func capturePaid(t *testing.T, fake *payments.Fake) {
+	auth, err := fake.Authorize(payments.Charge{Amount: 4800, Token: payments.FakeTokenOK, Reference: "PAID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Capture(auth.ID, auth.Amount); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	routes := getRoutes()
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		captured    bool
+		expLocation string
+		expState    string
+	}{
+		{"guest_cancel", "POST", "/reservations/PAID/cancel", true, "/reservations/PAID", payments.FakeRefunded},
+		{"guest_cancel_refund_fails", "POST", "/reservations/PAID/cancel", false, "/reservations/PAID", ""},
+		{"admin_refund", "POST", "/admin/reservations/all/13/refund", true, "/admin/reservations/all/13/show", payments.FakeRefunded},
+		{"admin_refund_fails", "POST", "/admin/reservations/all/13/refund", false, "/admin/reservations/all/13/show", ""},
+		{"admin_refund_unpaid", "POST", "/admin/reservations/all/1/refund", false, "/admin/reservations/all/1/show", ""},
+	}
+
+	for _, e := range tests {
+		fake := useFakePayments(t)
+		if e.captured {
+			capturePaid(t, fake)
+		}
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(url.Values{}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		location, _ := rr.Result().Location()
+		if location.String() != e.expLocation {
+			t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+		}
+		if state := fake.State("fake_PAID"); state != e.expState {
+			t.Errorf("Failed %s: expected the provider to have a %q payment, got %q", e.name, e.expState, state)
+		}
+	}
+}
+
+// paymentSpy records the payment statuses deposits are changed to through it, passing everything
+// else to the test repo
+type paymentSpy struct {
+	repository.DbRepo
+	changed []string
+}
+
+func (s *paymentSpy) ChangeReservationPayment(id int, from, to, paymentID string, amountPaid int) error {
+	s.changed = append(s.changed, to)
+	return s.DbRepo.ChangeReservationPayment(id, from, to, paymentID, amountPaid)
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	routes := getRoutes()
+	fake := useFakePayments(t)
+	other := payments.NewFake([]byte("someone else"))
+
+	// the test repo's "PAID" reservation has its deposit paid
+	refunded := []byte(`{"type":"payment.refunded","payment_id":"fake_PAID","amount":4800}`)
+	captured := []byte(`{"type":"payment.captured","payment_id":"fake_PAID","amount":4800}`)
+	failed := []byte(`{"type":"payment.failed","payment_id":"fake_PAID"}`)
+	unknown := []byte(`{"type":"payment.refunded","payment_id":"fake_NOPE","amount":4800}`)
+	tests := []struct {
+		name          string
+		payload       []byte
+		signature     string
+		expStatusCode int
+		// expChanged is the payment status the deposit is changed to, if any
+		expChanged string
+	}{
+		{"refunded", refunded, fake.SignWebhook(refunded), http.StatusOK, models.PaymentRefunded},
+		{"captured_again", captured, fake.SignWebhook(captured), http.StatusOK, ""},
+		{"failed_after_paid", failed, fake.SignWebhook(failed), http.StatusOK, ""},
+		{"unknown_payment", unknown, fake.SignWebhook(unknown), http.StatusOK, ""},
+		{"bad_signature", refunded, other.SignWebhook(refunded), http.StatusBadRequest, ""},
+		{"unsigned", refunded, "", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		spy := &paymentSpy{DbRepo: Repo.DB}
+		Repo.DB = spy
+		req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(e.payload))
+		req.Header.Set(payments.FakeSignatureHeader, e.signature)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		Repo.DB = spy.DbRepo
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		changed := strings.Join(spy.changed, ",")
+		if changed != e.expChanged {
+			t.Errorf("Failed %s: expected the deposit changed to %q, got %q", e.name, e.expChanged, changed)
+		}
+	}
+}
+
+func TestPaymentWebhookWithoutProvider(t *testing.T) {
+	withoutPayments(t)
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(`{"type":"payment.refunded","payment_id":"fake_PAID","amount":4800}`))
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected code %d without a payment provider, got %d", http.StatusNotFound, rr.Code)
+	}
+}
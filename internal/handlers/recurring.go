@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// AdminRecurringBlocks lists the recurring blocks of every room
+func (rep *Repository) AdminRecurringBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := rep.DB.AllRecurringBlocks()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["recurring_blocks"] = blocks
+	render.Template(w, "admin_recurring_blocks.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRecurringBlock shows the form for closing a room on a repeating schedule
+func (rep *Repository) AdminNewRecurringBlock(w http.ResponseWriter, r *http.Request) {
+	block := models.RecurringBlock{Repeat: models.RepeatWeekly, Weekday: time.Monday}
+	block.RoomID, _ = strconv.Atoi(r.URL.Query().Get("room_id"))
+	rep.renderRecurringBlockForm(w, r, block, forms.New(nil))
+}
+
+// AdminPostNewRecurringBlock adds a recurring block
+func (rep *Repository) AdminPostNewRecurringBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	block := recurringBlockFromForm(form)
+	if !form.Valid() {
+		rep.renderRecurringBlockForm(w, r, block, form)
+		return
+	}
+	block.ID, err = rep.DB.InsertRecurringBlock(block)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityRecurringBlock, block.ID, nil, block)
+	rep.App.Session.Put(r.Context(), "flash", "Recurring block added!")
+	http.Redirect(w, r, "/admin/recurring_blocks", http.StatusSeeOther)
+}
+
+// AdminShowRecurringBlock shows the form for changing a recurring block
+func (rep *Repository) AdminShowRecurringBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := rep.adminRecurringBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	rep.renderRecurringBlockForm(w, r, block, forms.New(nil))
+}
+
+// AdminPostRecurringBlock changes the room, schedule or note of a recurring block
+func (rep *Repository) AdminPostRecurringBlock(w http.ResponseWriter, r *http.Request) {
+	existing, ok := rep.adminRecurringBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	block := recurringBlockFromForm(form)
+	block.ID = existing.ID
+	if !form.Valid() {
+		rep.renderRecurringBlockForm(w, r, block, form)
+		return
+	}
+	err = rep.DB.UpdateRecurringBlock(block)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityRecurringBlock, block.ID, existing, block)
+	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, "/admin/recurring_blocks", http.StatusSeeOther)
+}
+
+// AdminDeleteRecurringBlock removes a recurring block
+func (rep *Repository) AdminDeleteRecurringBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := rep.adminRecurringBlockFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := rep.DB.DeleteRecurringBlock(block.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityRecurringBlock, block.ID, block, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Recurring block removed!")
+	http.Redirect(w, r, "/admin/recurring_blocks", http.StatusSeeOther)
+}
+
+// recurringBlockFromForm reads the posted recurring block form, adding an error to the form for
+// every invalid field. Only the fields for the chosen schedule are read.
+func recurringBlockFromForm(form *forms.Form) models.RecurringBlock {
+	form.Required("room_id", "repeats")
+	block := models.RecurringBlock{
+		Repeat: form.Get("repeats"),
+		Note:   strings.TrimSpace(form.Get("note")),
+	}
+	var err error
+	block.RoomID, err = strconv.Atoi(form.Get("room_id"))
+	if err != nil || block.RoomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	// number reads a field that must be a whole number from min to max
+	number := func(field string, min, max int) int {
+		n, err := strconv.Atoi(form.Get(field))
+		if err != nil || n < min || n > max {
+			form.Errors.Add(field, "Choose a value from the list")
+		}
+		return n
+	}
+	switch block.Repeat {
+	case models.RepeatWeekly:
+		block.Weekday = time.Weekday(number("weekday", int(time.Sunday), int(time.Saturday)))
+	case models.RepeatYearly:
+		block.StartMonth = time.Month(number("start_month", 1, 12))
+		block.StartDay = number("start_day", 1, 31)
+		block.EndMonth = time.Month(number("end_month", 1, 12))
+		block.EndDay = number("end_day", 1, 31)
+		if !validMonthDay(block.StartMonth, block.StartDay) {
+			form.Errors.Add("start_day", "That month does not have this many days")
+		}
+		if !validMonthDay(block.EndMonth, block.EndDay) {
+			form.Errors.Add("end_day", "That month does not have this many days")
+		}
+	default:
+		form.Errors.Add("repeats", "Choose how often the block repeats")
+	}
+	return block
+}
+
+// validMonthDay reports whether day exists in month in some year, allowing February 29
+func validMonthDay(month time.Month, day int) bool {
+	return month >= time.January && month <= time.December && day >= 1 &&
+		time.Date(2000, month, day, 0, 0, 0, 0, time.UTC).Month() == month
+}
+
+// adminRecurringBlockFromURL loads the recurring block for the {id} URL parameter. If it cannot,
+// the response has already been written and ok is false.
+func (rep *Repository) adminRecurringBlockFromURL(w http.ResponseWriter, r *http.Request) (models.RecurringBlock, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.RecurringBlock{}, false
+	}
+	block, err := rep.DB.GetRecurringBlockById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "Recurring block not found")
+		http.Redirect(w, r, "/admin/recurring_blocks", http.StatusSeeOther)
+		return block, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return block, false
+	}
+	return block, true
+}
+
+// renderRecurringBlockForm shows the recurring block form
+func (rep *Repository) renderRecurringBlockForm(w http.ResponseWriter, r *http.Request, block models.RecurringBlock, form *forms.Form) {
+	rooms, err := rep.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var weekdays []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays = append(weekdays, d)
+	}
+	var months []time.Month
+	for m := time.January; m <= time.December; m++ {
+		months = append(months, m)
+	}
+	data := make(map[string]interface{})
+	data["recurring_block"] = block
+	data["rooms"] = rooms
+	data["weekdays"] = weekdays
+	data["months"] = months
+	render.Template(w, "admin_recurring_block.page.tmpl", r, &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
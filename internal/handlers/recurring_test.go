@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var recurringBlockTests = []struct {
+	name          string
+	method        string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expHTML       string
+}{
+	{"list", "GET", "/admin/recurring_blocks", nil, http.StatusOK, "", "Every year, Feb 1 to Feb 7"},
+	{"new", "GET", "/admin/recurring_blocks/new?room_id=2", nil, http.StatusOK, "", "New Recurring Block"},
+	{"show", "GET", "/admin/recurring_blocks/1/show", nil, http.StatusOK, "", "Deep clean"},
+	{"show_unknown", "GET", "/admin/recurring_blocks/99/show", nil, http.StatusSeeOther, "/admin/recurring_blocks", ""},
+	{"show_bad_id", "GET", "/admin/recurring_blocks/abc/show", nil, http.StatusBadRequest, "", ""},
+	{
+		"create_weekly", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"weekly"}, "weekday": {"1"}, "note": {"Deep clean"}},
+		http.StatusSeeOther, "/admin/recurring_blocks", "",
+	},
+	{
+		"create_yearly_over_new_year", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"yearly"}, "start_month": {"12"}, "start_day": {"24"}, "end_month": {"1"}, "end_day": {"2"}},
+		http.StatusSeeOther, "/admin/recurring_blocks", "",
+	},
+	{
+		"create_leap_day", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"yearly"}, "start_month": {"2"}, "start_day": {"29"}, "end_month": {"2"}, "end_day": {"29"}},
+		http.StatusSeeOther, "/admin/recurring_blocks", "",
+	},
+	{
+		"create_no_room", "POST", "/admin/recurring_blocks/new",
+		url.Values{"repeats": {"weekly"}, "weekday": {"1"}},
+		http.StatusOK, "", "Choose a room",
+	},
+	{
+		"create_bad_weekday", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"weekly"}, "weekday": {"7"}},
+		http.StatusOK, "", "Choose a value from the list",
+	},
+	{
+		"create_no_such_day", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"yearly"}, "start_month": {"4"}, "start_day": {"31"}, "end_month": {"5"}, "end_day": {"2"}},
+		http.StatusOK, "", "does not have this many days",
+	},
+	{
+		"create_bad_repeat", "POST", "/admin/recurring_blocks/new",
+		url.Values{"room_id": {"2"}, "repeats": {"daily"}},
+		http.StatusOK, "", "Choose how often",
+	},
+	{
+		"update", "POST", "/admin/recurring_blocks/1",
+		url.Values{"room_id": {"2"}, "repeats": {"weekly"}, "weekday": {"2"}, "note": {"Tuesday clean"}},
+		http.StatusSeeOther, "/admin/recurring_blocks", "",
+	},
+	{
+		"update_unknown", "POST", "/admin/recurring_blocks/99",
+		url.Values{"room_id": {"2"}, "repeats": {"weekly"}, "weekday": {"2"}},
+		http.StatusSeeOther, "/admin/recurring_blocks", "",
+	},
+	{"delete", "GET", "/admin/delete_recurring_block/2/do", nil, http.StatusSeeOther, "/admin/recurring_blocks", ""},
+	{"delete_unknown", "GET", "/admin/delete_recurring_block/99/do", nil, http.StatusSeeOther, "/admin/recurring_blocks", ""},
+}
+
+func TestRecurringBlocks(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range recurringBlockTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
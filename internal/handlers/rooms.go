@@ -0,0 +1,504 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/render"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/Ed-cred/bookings/internal/tokens"
+	"github.com/go-chi/chi"
+)
+
+// roomPhotoDir is where uploaded room photos are stored; it is served as roomPhotoURL
+var roomPhotoDir = "./static/images/rooms"
+
+const roomPhotoURL = "/static/images/rooms/"
+
+// maxRoomPhotoSize is the largest photo upload accepted, in bytes
+const maxRoomPhotoSize = 5 << 20
+
+// roomPhotoTypes maps the accepted photo content types to their file extension
+var roomPhotoTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Rooms lists the rooms guests can book
+func (rep *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := rep.DB.ActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	render.Template(w, "rooms.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room shows the page of a single room, looked up by its slug
+func (rep *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := rep.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if err == nil && !room.Active {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "We could not find that room")
+		http.Redirect(w, r, "/rooms", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["room"] = room
+	render.Template(w, "room.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms lists every room, including inactive ones
+func (rep *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := rep.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	render.Template(w, "admin_rooms.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form to add a room
+func (rep *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	rep.renderRoomForm(w, r, models.Room{MaxOccupancy: 2, Active: true}, forms.New(nil))
+}
+
+// AdminPostNewRoom adds a room
+func (rep *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	room := roomFromForm(form)
+	if !form.Valid() {
+		rep.renderRoomForm(w, r, room, form)
+		return
+	}
+	room.ID, err = rep.DB.InsertRoom(room)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "This slug is already used by another room")
+		rep.renderRoomForm(w, r, room, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityRoom, room.ID, nil, room)
+	rep.App.Session.Put(r.Context(), "flash", "Room added, you can upload photos now")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/show", room.ID), http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form to edit a room and its photos
+func (rep *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	rep.renderRoomForm(w, r, room, forms.New(nil))
+}
+
+// AdminPostRoom saves the details of a room
+func (rep *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	existing, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	room := roomFromForm(form)
+	room.ID = existing.ID
+	room.Photos = existing.Photos
+	if !form.Valid() {
+		rep.renderRoomForm(w, r, room, form)
+		return
+	}
+	err = rep.DB.UpdateRoom(room)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "This slug is already used by another room")
+		rep.renderRoomForm(w, r, room, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditUpdate, models.EntityRoom, room.ID, existing, room)
+	rep.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomPhoto uploads a photo for a room
+func (rep *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRoomPhotoSize+1<<20)
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		rep.App.Session.Put(r.Context(), "error", "Please choose a photo of at most 5MB")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxRoomPhotoSize+1))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	ext, ok := roomPhotoTypes[http.DetectContentType(content)]
+	if !ok || len(content) > maxRoomPhotoSize {
+		rep.App.Session.Put(r.Context(), "error", "Photos must be JPEG, PNG or WebP images of at most 5MB")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+
+	code, err := tokens.NewCode()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	name := fmt.Sprintf("%s-%s%s", room.Slug, strings.ToLower(code), ext)
+	err = os.MkdirAll(roomPhotoDir, 0755)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(roomPhotoDir, name), content, 0644)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	photo := models.RoomPhoto{RoomID: room.ID, Path: roomPhotoURL + name}
+	photo.ID, err = rep.DB.InsertRoomPhoto(photo)
+	if err != nil {
+		os.Remove(filepath.Join(roomPhotoDir, name))
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityRoomPhoto, photo.ID, nil, photo)
+	rep.App.Session.Put(r.Context(), "flash", "Photo uploaded!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from a room. Uploaded files are deleted from disk as well.
+func (rep *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+	photoID, _ := strconv.Atoi(chi.URLParam(r, "photo"))
+	var photo models.RoomPhoto
+	for _, p := range room.Photos {
+		if p.ID == photoID {
+			photo = p
+		}
+	}
+	if photo.ID == 0 {
+		rep.App.Session.Put(r.Context(), "error", "Photo not found")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	err := rep.DB.DeleteRoomPhoto(photo.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityRoomPhoto, photo.ID, photo, nil)
+	if strings.HasPrefix(photo.Path, roomPhotoURL) {
+		err = os.Remove(filepath.Join(roomPhotoDir, filepath.Base(photo.Path)))
+		if err != nil && !os.IsNotExist(err) {
+			rep.App.ErrorLog.Println(err)
+		}
+	}
+	rep.App.Session.Put(r.Context(), "flash", "Photo deleted!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminPostRoomRate adds a seasonal or date-range rate to a room
+func (rep *Repository) AdminPostRoomRate(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	rate, msg := roomRateFromForm(form)
+	if msg != "" {
+		rep.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	rate.RoomID = room.ID
+	rate.ID, err = rep.DB.InsertRoomRate(rate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditCreate, models.EntityRoomRate, rate.ID, nil, rate)
+	rep.App.Session.Put(r.Context(), "flash", "Rate added!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomRate removes a rate from a room
+func (rep *Repository) AdminDeleteRoomRate(w http.ResponseWriter, r *http.Request) {
+	room, ok := rep.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	showURL := fmt.Sprintf("/admin/rooms/%d/show", room.ID)
+	rates, err := rep.DB.RoomRates(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rateID, _ := strconv.Atoi(chi.URLParam(r, "rate"))
+	var rate models.RoomRate
+	for _, rt := range rates {
+		if rt.ID == rateID {
+			rate = rt
+		}
+	}
+	if rate.ID == 0 {
+		rep.App.Session.Put(r.Context(), "error", "Rate not found")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	err = rep.DB.DeleteRoomRate(rate.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rep.audit(r, models.AuditDelete, models.EntityRoomRate, rate.ID, rate, nil)
+	rep.App.Session.Put(r.Context(), "flash", "Rate deleted!")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// adminRoomFromURL loads the room for the {id} URL parameter. If it cannot, the response has
+// already been written and ok is false.
+func (rep *Repository) adminRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Room{}, false
+	}
+	room, err := rep.DB.GetRoomById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.App.Session.Put(r.Context(), "error", "Room not found")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+	return room, true
+}
+
+// roomFromForm reads and validates the posted room form. A blank slug is derived from the name.
+func roomFromForm(form *forms.Form) models.Room {
+	form.Required("room_name", "max_occupancy", "base_rate")
+	room := models.Room{
+		RoomName:    strings.TrimSpace(form.Get("room_name")),
+		Slug:        strings.TrimSpace(form.Get("slug")),
+		Description: form.Get("description"),
+		Active:      form.Has("active"),
+	}
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+	}
+	if !slugPattern.MatchString(room.Slug) {
+		form.Errors.Add("slug", "Use lower case letters, digits and dashes only")
+	}
+
+	var err error
+	room.MaxOccupancy, err = strconv.Atoi(form.Get("max_occupancy"))
+	if err != nil || room.MaxOccupancy < 1 {
+		form.Errors.Add("max_occupancy", "Must be a whole number of at least 1")
+	}
+	room.BaseRate, err = parseMoney(form.Get("base_rate"))
+	if err != nil {
+		form.Errors.Add("base_rate", "Must be an amount such as 120 or 120.50")
+	}
+	if form.Has("weekend_surcharge") {
+		room.WeekendSurcharge, err = parseMoney(form.Get("weekend_surcharge"))
+		if err != nil {
+			form.Errors.Add("weekend_surcharge", "Must be an amount such as 20 or 20.50")
+		}
+	}
+	room.MinNights = 1
+	if form.Has("min_nights") {
+		room.MinNights, err = strconv.Atoi(form.Get("min_nights"))
+		if err != nil || room.MinNights < 1 {
+			form.Errors.Add("min_nights", "Must be a whole number of at least 1")
+		}
+	}
+	return room
+}
+
+// roomRateFromForm reads the posted rate form. If it is not valid, msg says why.
+func roomRateFromForm(form *forms.Form) (rate models.RoomRate, msg string) {
+	rate.Name = strings.TrimSpace(form.Get("rate_name"))
+	rate.Yearly = form.Has("yearly")
+	if rate.Name == "" {
+		return rate, "Please give the rate a name"
+	}
+
+	layout := "2006-01-02"
+	var err error
+	rate.StartDate, err = time.Parse(layout, form.Get("rate_start"))
+	if err != nil {
+		return rate, "Please enter the first night of the rate as YYYY-MM-DD"
+	}
+	rate.EndDate, err = time.Parse(layout, form.Get("rate_end"))
+	if err != nil {
+		return rate, "Please enter the last night of the rate as YYYY-MM-DD"
+	}
+	if rate.EndDate.Before(rate.StartDate) {
+		return rate, "The last night of the rate cannot be before the first"
+	}
+
+	if form.Has("nightly_rate") {
+		rate.NightlyRate, err = parseMoney(form.Get("nightly_rate"))
+		if err != nil || rate.NightlyRate == 0 {
+			return rate, "The nightly rate must be an amount such as 120 or 120.50"
+		}
+	}
+	if form.Has("rate_min_nights") {
+		rate.MinNights, err = strconv.Atoi(form.Get("rate_min_nights"))
+		if err != nil || rate.MinNights < 1 {
+			return rate, "The minimum stay must be a whole number of at least 1"
+		}
+	}
+	if rate.NightlyRate == 0 && rate.MinNights == 0 {
+		return rate, "Please set a nightly rate, a minimum stay or both"
+	}
+	return rate, ""
+}
+
+func (rep *Repository) renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+	if room.ID > 0 {
+		rates, err := rep.DB.RoomRates(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["rates"] = rates
+		feeds, err := rep.DB.CalendarFeedsForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["feeds"] = feeds
+	}
+	stringMap := make(map[string]string)
+	for field, amount := range map[string]int{"base_rate": room.BaseRate, "weekend_surcharge": room.WeekendSurcharge} {
+		stringMap[field] = formatAmount(amount)
+		if form.Has(field) {
+			stringMap[field] = form.Get(field)
+		}
+	}
+	if room.ID > 0 {
+		stringMap["feed_url"] = fmt.Sprintf("%s/feeds/rooms/%d.ics", rep.App.BaseURL, room.ID)
+	}
+	render.Template(w, "admin_room.page.tmpl", r, &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// slugify turns a room name such as "General's Quarters" into "generals-quarters"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'':
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// parseMoney parses an amount such as "120" or "120.50" into cents
+func parseMoney(s string) (int, error) {
+	whole, frac, found := strings.Cut(strings.TrimSpace(s), ".")
+	if found && (len(frac) == 0 || len(frac) > 2) {
+		return 0, errors.New("invalid amount")
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	w, err := strconv.Atoi(whole)
+	if err != nil || w < 0 {
+		return 0, errors.New("invalid amount")
+	}
+	f, err := strconv.Atoi(frac)
+	if err != nil || f < 0 {
+		return 0, errors.New("invalid amount")
+	}
+	return w*100 + f, nil
+}
+
+// formatAmount formats cents as a plain decimal amount, the inverse of parseMoney
+func formatAmount(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Ed-cred/bookings/internal/forms"
+)
+
+var roomTests = []struct {
+	name          string
+	method        string
+	url           string
+	postedData    url.Values
+	expStatusCode int
+	expLocation   string
+	expHTML       string
+}{
+	{"rooms", "GET", "/rooms", nil, http.StatusOK, "", "/rooms/generals-quarters"},
+	{"room", "GET", "/rooms/generals-quarters", nil, http.StatusOK, "", "$120.00"},
+	{"room_inactive", "GET", "/rooms/majors-suite", nil, http.StatusSeeOther, "/rooms", ""},
+	{"room_unknown", "GET", "/rooms/nope", nil, http.StatusSeeOther, "/rooms", ""},
+	{"legacy_room_url", "GET", "/generals-quarters", nil, http.StatusMovedPermanently, "/rooms/generals-quarters", ""},
+	{"admin_rooms", "GET", "/admin/rooms", nil, http.StatusOK, "", "New Room"},
+	{"admin_new_room", "GET", "/admin/rooms/new", nil, http.StatusOK, "", "New Room"},
+	{"admin_show_room", "GET", "/admin/rooms/1/show", nil, http.StatusOK, "", "120.00"},
+	{"admin_show_room_unknown", "GET", "/admin/rooms/9/show", nil, http.StatusSeeOther, "/admin/rooms", ""},
+	{"admin_show_room_bad_id", "GET", "/admin/rooms/abc/show", nil, http.StatusBadRequest, "", ""},
+	{
+		"create_room", "POST", "/admin/rooms/new",
+		url.Values{"room_name": {"Colonel's Loft"}, "max_occupancy": {"3"}, "base_rate": {"150.50"}, "active": {"1"}},
+		http.StatusSeeOther, "/admin/rooms/3/show", "",
+	},
+	{
+		"create_room_duplicate_slug", "POST", "/admin/rooms/new",
+		url.Values{"room_name": {"General's Quarters"}, "max_occupancy": {"3"}, "base_rate": {"150"}},
+		http.StatusOK, "", "already used by another room",
+	},
+	{
+		"create_room_bad_slug", "POST", "/admin/rooms/new",
+		url.Values{"room_name": {"Loft"}, "slug": {"The Loft"}, "max_occupancy": {"3"}, "base_rate": {"150"}},
+		http.StatusOK, "", "lower case letters",
+	},
+	{
+		"create_room_bad_rate", "POST", "/admin/rooms/new",
+		url.Values{"room_name": {"Loft"}, "max_occupancy": {"3"}, "base_rate": {"150.505"}},
+		http.StatusOK, "", "Must be an amount",
+	},
+	{
+		"create_room_bad_occupancy", "POST", "/admin/rooms/new",
+		url.Values{"room_name": {"Loft"}, "max_occupancy": {"0"}, "base_rate": {"150"}},
+		http.StatusOK, "", "at least 1",
+	},
+	{
+		"update_room", "POST", "/admin/rooms/1",
+		url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "max_occupancy": {"2"}, "base_rate": {"125"}},
+		http.StatusSeeOther, "/admin/rooms", "",
+	},
+	{
+		"update_room_duplicate_slug", "POST", "/admin/rooms/1",
+		url.Values{"room_name": {"General's Quarters"}, "slug": {"majors-suite"}, "max_occupancy": {"2"}, "base_rate": {"125"}},
+		http.StatusOK, "", "already used by another room",
+	},
+	{"delete_photo", "GET", "/admin/delete_room_photo/1/1/do", nil, http.StatusSeeOther, "/admin/rooms/1/show", ""},
+	{"delete_photo_unknown", "GET", "/admin/delete_room_photo/1/99/do", nil, http.StatusSeeOther, "/admin/rooms/1/show", ""},
+	{"admin_show_room_rates", "GET", "/admin/rooms/1/show", nil, http.StatusOK, "", "Festival"},
+	{
+		"update_room_bad_min_nights", "POST", "/admin/rooms/1",
+		url.Values{"room_name": {"General's Quarters"}, "max_occupancy": {"2"}, "base_rate": {"125"}, "min_nights": {"0"}},
+		http.StatusOK, "", "at least 1",
+	},
+	{
+		"update_room_bad_surcharge", "POST", "/admin/rooms/1",
+		url.Values{"room_name": {"General's Quarters"}, "max_occupancy": {"2"}, "base_rate": {"125"}, "weekend_surcharge": {"abc"}},
+		http.StatusOK, "", "Must be an amount",
+	},
+	{
+		"add_rate", "POST", "/admin/rooms/1/rates",
+		url.Values{"rate_name": {"Summer"}, "rate_start": {"2023-06-01"}, "rate_end": {"2023-08-31"}, "nightly_rate": {"150"}, "yearly": {"1"}},
+		http.StatusSeeOther, "/admin/rooms/1/show", "",
+	},
+	{
+		"add_rate_end_before_start", "POST", "/admin/rooms/1/rates",
+		url.Values{"rate_name": {"Summer"}, "rate_start": {"2023-08-31"}, "rate_end": {"2023-06-01"}, "nightly_rate": {"150"}},
+		http.StatusSeeOther, "/admin/rooms/1/show", "",
+	},
+	{"delete_rate", "GET", "/admin/delete_room_rate/1/1/do", nil, http.StatusSeeOther, "/admin/rooms/1/show", ""},
+	{"delete_rate_unknown", "GET", "/admin/delete_room_rate/1/99/do", nil, http.StatusSeeOther, "/admin/rooms/1/show", ""},
+}
+
+func TestRooms(t *testing.T) {
+	routes := getRoutes()
+	for _, e := range roomTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expStatusCode {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, e.expStatusCode, rr.Code)
+		}
+		if e.expLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expLocation {
+				t.Errorf("Failed %s: expected location %s, got %s", e.name, e.expLocation, location.String())
+			}
+		}
+		if e.expHTML != "" && !strings.Contains(rr.Body.String(), e.expHTML) {
+			t.Errorf("Failed %s: expected page to contain %s", e.name, e.expHTML)
+		}
+	}
+}
+
+func TestAdminPostRoomPhoto(t *testing.T) {
+	roomPhotoDir = t.TempDir()
+	routes := getRoutes()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	tests := []struct {
+		name     string
+		content  []byte
+		expFiles int
+	}{
+		{"png", png, 1},
+		{"not_an_image", []byte("hello world"), 1},
+	}
+
+	for _, e := range tests {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		fw, _ := mw.CreateFormFile("photo", "photo.png")
+		fw.Write(e.content)
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/photos", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Failed %s: expected code %d, got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		files, _ := os.ReadDir(roomPhotoDir)
+		if len(files) != e.expFiles {
+			t.Errorf("Failed %s: expected %d stored photos, got %d", e.name, e.expFiles, len(files))
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"General's Quarters": "generals-quarters",
+		"  Major's   Suite ": "majors-suite",
+		"Room 3 (sea view)":  "room-3-sea-view",
+	}
+	for name, expected := range tests {
+		if got := slugify(name); got != expected {
+			t.Errorf("slugify(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestRoomRateFromForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   url.Values
+		expMsg string
+	}{
+		{"valid", url.Values{"rate_name": {"Summer"}, "rate_start": {"2023-06-01"}, "rate_end": {"2023-08-31"}, "rate_min_nights": {"3"}}, ""},
+		{"no_name", url.Values{"rate_start": {"2023-06-01"}, "rate_end": {"2023-08-31"}, "nightly_rate": {"150"}}, "name"},
+		{"bad_start", url.Values{"rate_name": {"Summer"}, "rate_start": {"June"}, "rate_end": {"2023-08-31"}, "nightly_rate": {"150"}}, "first night"},
+		{"zero_rate", url.Values{"rate_name": {"Summer"}, "rate_start": {"2023-06-01"}, "rate_end": {"2023-08-31"}, "nightly_rate": {"0"}}, "nightly rate"},
+		{"nothing_to_override", url.Values{"rate_name": {"Summer"}, "rate_start": {"2023-06-01"}, "rate_end": {"2023-08-31"}}, "or both"},
+	}
+	for _, e := range tests {
+		_, msg := roomRateFromForm(forms.New(e.data))
+		if e.expMsg == "" && msg != "" {
+			t.Errorf("Failed %s: unexpected error %q", e.name, msg)
+		}
+		if e.expMsg != "" && !strings.Contains(msg, e.expMsg) {
+			t.Errorf("Failed %s: expected an error about %q, got %q", e.name, e.expMsg, msg)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]int{"120": 12000, "120.5": 12050, "120.05": 12005, "0": 0}
+	for s, expected := range valid {
+		got, err := parseMoney(s)
+		if err != nil || got != expected {
+			t.Errorf("parseMoney(%q): expected %d, got %d (%v)", s, expected, got, err)
+		}
+		if parsed, _ := parseMoney(formatAmount(got)); parsed != got {
+			t.Errorf("formatAmount(%d) does not round trip", got)
+		}
+	}
+	for _, s := range []string{"", "abc", "12.345", "-5", "12."} {
+		if _, err := parseMoney(s); err == nil {
+			t.Errorf("parseMoney(%q): expected an error", s)
+		}
+	}
+}
@@ -12,7 +12,10 @@ import (
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/config"
+	"github.com/Ed-cred/bookings/internal/helpers"
+	"github.com/Ed-cred/bookings/internal/mailer"
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/payments"
 	"github.com/Ed-cred/bookings/internal/render"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
@@ -31,6 +34,8 @@ var functions =template.FuncMap{
 	"humanDate": render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate": render.IterateDays,
+	"formatMoney": render.FormatMoney,
+	"statusLabel": models.StatusLabel,
 }
 
 func TestMain(m *testing.M) {
@@ -54,12 +59,6 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProd
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-	listenForMail()
-
-
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("Could not create template cache")
@@ -67,9 +66,22 @@ func TestMain(m *testing.M) {
 
 	app.TemplateCache = tc
 	app.UseCache = true
+	app.EmailTemplates, err = mailer.Load("./../../email_templates")
+	if err != nil {
+		log.Fatal("Could not load the email templates")
+	}
+	app.BaseURL = "http://localhost:8080"
+	app.MailFrom = "me@here.com"
+	app.StaffMailFrom = "me@here.com"
+	app.OwnerEmail = "property@owner.com"
+	app.MailMaxAttempts = 8
+	app.SigningKey = []byte("test-signing-key")
+	app.Payment = config.PaymentConfig{Provider: "fake", WebhookSecret: "test-webhook-secret", Currency: "usd"}
+	app.Payments = payments.NewFake([]byte(app.Payment.WebhookSecret))
 	repo := NewTestRepository(&app)
 	NewHandlers(repo) 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())	
 }
 
@@ -81,8 +93,11 @@ func getRoutes() http.Handler {
 	mux.Use(SessionLoad)
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	// the first two rooms had their own pages before rooms were stored in the database
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search_availability", Repo.Availability)
 	mux.Post("/search_availability", Repo.PostAvailability)
@@ -93,13 +108,27 @@ func getRoutes() http.Handler {
 	mux.Get("/make_reservation", Repo.Reservation)
 	mux.Post("/make_reservation", Repo.PostReservation)
 
+	mux.Get("/make_payment", Repo.Payment)
+	mux.Post("/make_payment", Repo.PostPayment)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+
 	mux.Get("/reservation_summary", Repo.Summary)
 
+	mux.Get("/reservations/{code}", Repo.GuestReservation)
+	mux.Post("/reservations/{code}", Repo.GuestPostReservationDates)
+	mux.Post("/reservations/{code}/cancel", Repo.GuestCancelReservation)
+	mux.Get("/reservations/{code}/pay", Repo.GuestPayReservation)
+
 	
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostLogin)
 	mux.Get("/user/logout", Repo.UserLogout)
 
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -107,26 +136,87 @@ func getRoutes() http.Handler {
 		mux.Get("/dashboard", Repo.AdminDashboard)
 		mux.Get("/reservations_new", Repo.AdminNewReservations)
 		mux.Get("/reservations_all", Repo.AdminAllReservations)
+		mux.Get("/reservations_search", Repo.AdminSearchReservations)
+		mux.Get("/guests", Repo.AdminGuests)
+		mux.Get("/guests/{id}/show", Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", Repo.AdminMergeGuest)
 		
 		mux.Get("/reservations_calendar", Repo.AdminReservationsCalendar)
 		mux.Post("/reservations_calendar", Repo.AdminPostReservationsCalendar)
+		mux.Get("/blocks/new", Repo.AdminNewBlock)
+		mux.Post("/blocks/new", Repo.AdminPostNewBlock)
+		mux.Get("/blocks/{id}/show", Repo.AdminShowBlock)
+		mux.Post("/blocks/{id}", Repo.AdminPostBlock)
+		mux.Get("/delete_block/{id}/do", Repo.AdminDeleteBlock)
+		mux.Get("/recurring_blocks", Repo.AdminRecurringBlocks)
+		mux.Get("/recurring_blocks/new", Repo.AdminNewRecurringBlock)
+		mux.Post("/recurring_blocks/new", Repo.AdminPostNewRecurringBlock)
+		mux.Get("/recurring_blocks/{id}/show", Repo.AdminShowRecurringBlock)
+		mux.Post("/recurring_blocks/{id}", Repo.AdminPostRecurringBlock)
+		mux.Get("/delete_recurring_block/{id}/do", Repo.AdminDeleteRecurringBlock)
 
-		mux.Get("/process_reservation/{src}/{id}/do", Repo.AdminProcessReservation)
+		mux.Post("/reservations/{src}/{id}/status", Repo.AdminChangeReservationStatus)
 		mux.Get("/delete_reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+		mux.Get("/reservations_trash", Repo.AdminTrash)
+		mux.Post("/reservations_trash/{id}/restore", Repo.AdminRestoreReservation)
+		mux.Post("/reservations/{src}/{id}/refund", Repo.AdminRefundReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", Repo.AdminPostReservation)
+
+		mux.Get("/api_tokens", Repo.AdminAPITokens)
+		mux.Post("/api_tokens", Repo.AdminPostAPIToken)
+		mux.Post("/api_tokens/{id}/revoke", Repo.AdminRevokeAPIToken)
+
+		mux.Get("/audit", Repo.AdminAudit)
+		mux.Get("/audit.csv", Repo.AdminAuditCSV)
+		mux.Get("/emails", Repo.AdminFailedEmails)
+		mux.Post("/emails/{id}/resend", Repo.AdminResendEmail)
+
+		mux.Get("/password", Repo.AdminChangePassword)
+		mux.Post("/password", Repo.AdminPostChangePassword)
+
+		mux.Get("/users", Repo.AdminUsers)
+		mux.Get("/users/new", Repo.AdminNewUser)
+		mux.Post("/users/new", Repo.AdminPostNewUser)
+		mux.Get("/users/{id}/show", Repo.AdminShowUser)
+		mux.Post("/users/{id}", Repo.AdminPostUser)
+		mux.Post("/users/{id}/password", Repo.AdminPostUserPassword)
+		mux.Post("/users/{id}/deactivate", Repo.AdminDeactivateUser)
+		mux.Post("/users/{id}/activate", Repo.AdminActivateUser)
+
+		mux.Get("/rooms", Repo.AdminRooms)
+		mux.Get("/rooms/new", Repo.AdminNewRoom)
+		mux.Post("/rooms/new", Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}/show", Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+		mux.Get("/delete_room_photo/{id}/{photo}/do", Repo.AdminDeleteRoomPhoto)
+		mux.Post("/rooms/{id}/rates", Repo.AdminPostRoomRate)
+		mux.Get("/delete_room_rate/{id}/{rate}/do", Repo.AdminDeleteRoomRate)
+		mux.Post("/rooms/{id}/feeds", Repo.AdminPostRoomFeed)
+		mux.Post("/rooms/{id}/feeds/{feed}/sync", Repo.AdminSyncRoomFeed)
+		mux.Post("/rooms/{id}/feeds/{feed}/delete", Repo.AdminDeleteRoomFeed)
 	})
 
-	return mux
-}
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/rooms/{id}", Repo.APIRoom)
+		mux.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		mux.Get("/availability", Repo.APIAvailability)
 
-func listenForMail () {
-	go func () {
-		for {
-			_ = <- app.MailChan
-		}
-	}()
+		mux.Get("/reservations", Repo.APIReservations)
+		mux.Get("/reservations/search", Repo.APISearchReservations)
+		mux.Post("/reservations", Repo.APICreateReservation)
+		mux.Get("/reservations/{id}", Repo.APIReservation)
+		mux.Put("/reservations/{id}", Repo.APIUpdateReservation)
+		mux.Delete("/reservations/{id}", Repo.APIDeleteReservation)
+	})
+
+	mux.Get("/feeds/rooms/{id}.ics", Repo.RoomCalendarFeed)
+
+	return mux
 }
 
 // Nosurf adds CSRF protection to all POST requests
@@ -1,15 +1,21 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/Ed-cred/bookings/internal/config"
+	"github.com/Ed-cred/bookings/internal/models"
 )
 
 var app *config.AppConfig
 
+type contextKey string
+
+const userKey contextKey = "user"
+
 // NewHelpers sets up app config for helpers
 func NewHelpers(a *config.AppConfig) {
 	app = a
@@ -30,3 +36,14 @@ func IsAuthenticated (r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+// AuthUser returns the user authenticated by the session or an API token, if any
+func AuthUser(r *http.Request) (models.User, bool) {
+	u, ok := r.Context().Value(userKey).(models.User)
+	return u, ok
+}
@@ -0,0 +1,128 @@
+// Package ical reads and writes iCalendar (RFC 5545) feeds
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineLength is the longest content line allowed by RFC 5545, in octets, without the CRLF
+const maxLineLength = 75
+
+// Calendar is a VCALENDAR object published as a feed
+type Calendar struct {
+	// ProdID identifies the product that created the calendar
+	ProdID string
+	// Name is shown by calendar apps for the subscribed calendar
+	Name   string
+	Events []Event
+}
+
+// Event is an all-day VEVENT. Start is the first day and End the day after the last one,
+// so a reservation's check-out date can be used as End directly.
+type Event struct {
+	// UID must not change for the lifetime of the event, so clients update it instead of adding a copy
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Categories  []string
+	// Status is the STATUS property, e.g. StatusCancelled. It is only read, never written.
+	Status       string
+	Created      time.Time
+	LastModified time.Time
+}
+
+// Encode writes the calendar to w with CRLF line endings and long lines folded
+func (c Calendar) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escapeText(c.Name))
+	}
+	for _, e := range c.Events {
+		stamp := e.LastModified
+		if stamp.IsZero() {
+			stamp = e.Created
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		// DTSTAMP is required; the last change keeps the feed identical between polls
+		line("DTSTAMP", formatDateTime(stamp))
+		line("DTSTART;VALUE=DATE", formatDate(e.Start))
+		line("DTEND;VALUE=DATE", formatDate(e.End))
+		line("SUMMARY", escapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeText(e.Description))
+		}
+		if len(e.Categories) > 0 {
+			categories := make([]string, len(e.Categories))
+			for i, c := range e.Categories {
+				categories[i] = escapeText(c)
+			}
+			line("CATEGORIES", strings.Join(categories, ","))
+		}
+		if !e.Created.IsZero() {
+			line("CREATED", formatDateTime(e.Created))
+		}
+		if !e.LastModified.IsZero() {
+			line("LAST-MODIFIED", formatDateTime(e.LastModified))
+		}
+		// the room is occupied, so the event shows as busy in free/busy lookups
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func formatDateTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escapeText escapes a TEXT property value
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+// writeFolded writes a content line, breaking it after maxLineLength octets and continuing
+// on the next line after a single space. Multi-octet characters are never split.
+func writeFolded(w *bufio.Writer, s string) {
+	limit := maxLineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space counts towards the length of continuation lines
+		limit = maxLineLength - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
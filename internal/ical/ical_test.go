@@ -0,0 +1,75 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	modified := time.Date(2050, 1, 2, 15, 4, 5, 0, time.UTC)
+	c := Calendar{
+		ProdID: "-//Test//Bookings//EN",
+		Name:   "General's Quarters",
+		Events: []Event{{
+			UID:          "reservation-1@example.com",
+			Start:        time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+			End:          time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+			Summary:      "Smith, John; party of 2",
+			Description:  "first line\nsecond line",
+			Categories:   []string{"Reservation"},
+			LastModified: modified,
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := c.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//Bookings//EN\r\n",
+		"UID:reservation-1@example.com\r\n",
+		"DTSTAMP:20500102T150405Z\r\n",
+		"DTSTART;VALUE=DATE:20500110\r\n",
+		"DTEND;VALUE=DATE:20500112\r\n",
+		`SUMMARY:Smith\, John\; party of 2` + "\r\n",
+		`DESCRIPTION:first line\nsecond line` + "\r\n",
+		"LAST-MODIFIED:20500102T150405Z\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got\n%s", want, out)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Error("expected every line to end with CRLF")
+	}
+}
+
+func TestFolding(t *testing.T) {
+	var buf bytes.Buffer
+	c := Calendar{ProdID: "-//Test//EN", Events: []Event{{UID: "1", Summary: strings.Repeat("é", 100)}}}
+	err := c.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unfolded strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d octets is longer than %d: %q", len(line), maxLineLength, line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+	if !strings.Contains(unfolded.String(), "\nSUMMARY:"+strings.Repeat("é", 100)+"\n") {
+		t.Errorf("expected the summary to unfold to the original text, got %s", unfolded.String())
+	}
+}
@@ -0,0 +1,258 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusCancelled marks an event that was cancelled but is still published
+const StatusCancelled = "CANCELLED"
+
+// ErrNotCalendar is returned by Parse for input that is not an iCalendar object, such as an
+// HTML error page served in place of a feed
+var ErrNotCalendar = errors.New("not an iCalendar feed")
+
+// maxLineBytes is the longest unfolded content line Parse accepts
+const maxLineBytes = 1 << 20
+
+// Parse reads the VEVENTs of a calendar. Dates are reduced to whole days: an event running
+// from the afternoon of one day to the morning of another covers the nights in between, the way
+// booking sites publish stays. Recurrence rules are not expanded.
+//
+// Events without a UID get one made from their dates, so they can still be told apart
+// between reads of the same feed.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var e *Event
+	// depth counts the components open inside the current VEVENT, such as VALARM,
+	// whose properties must not be mistaken for the event's own
+	depth := 0
+	ended := false
+	for n, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: malformed content line", n+1)
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && e == nil:
+			e = &Event{}
+			continue
+		case name == "BEGIN" && e != nil:
+			depth++
+			continue
+		case name == "END" && e != nil && depth > 0:
+			depth--
+			continue
+		case name == "END" && strings.EqualFold(value, "VEVENT") && e != nil:
+			err := e.finish()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			events = append(events, *e)
+			e = nil
+			continue
+		case name == "END" && strings.EqualFold(value, "VCALENDAR"):
+			ended = true
+		}
+		if e == nil || depth > 0 {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			e.UID = value
+		case "DTSTART":
+			e.Start, err = parseDate(value, params)
+		case "DTEND":
+			e.End, err = parseDate(value, params)
+		case "DURATION":
+			if !e.Start.IsZero() {
+				var days int
+				days, err = parseDurationDays(value)
+				e.End = e.Start.AddDate(0, 0, days)
+			}
+		case "SUMMARY":
+			e.Summary = unescapeText(value)
+		case "DESCRIPTION":
+			e.Description = unescapeText(value)
+		case "STATUS":
+			e.Status = strings.ToUpper(value)
+		case "CREATED":
+			e.Created, _ = parseDateTime(value, params)
+		case "LAST-MODIFIED":
+			e.LastModified, _ = parseDateTime(value, params)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n+1, name, err)
+		}
+	}
+	if e != nil || !ended {
+		return nil, errors.New("calendar is truncated")
+	}
+	return events, nil
+}
+
+// finish checks the event read so far and fills in what the feed left out
+func (e *Event) finish() error {
+	if e.Start.IsZero() {
+		return errors.New("event has no DTSTART")
+	}
+	// an event ending on the day it starts, or without an end, still takes one night
+	if !e.End.After(e.Start) {
+		e.End = e.Start.AddDate(0, 0, 1)
+	}
+	if e.UID == "" {
+		e.UID = fmt.Sprintf("%s-%s", formatDate(e.Start), formatDate(e.End))
+	}
+	return nil
+}
+
+// unfold reads content lines, joining continuation lines onto the line they continue
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitLine splits a content line such as DTSTART;TZID="Europe/Paris":20500101T140000 into its
+// upper-cased name, its parameters and its value. Colons inside quoted parameter values do not
+// end the parameters.
+func splitLine(line string) (name string, params map[string]string, value string, ok bool) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 1 {
+		return "", nil, "", false
+	}
+	parts := strings.Split(line[:colon], ";")
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// parseDate returns the day of a DATE or DATE-TIME value, as midnight UTC
+func parseDate(value string, params map[string]string) (time.Time, error) {
+	if len(value) == len("20060102") {
+		return time.Parse("20060102", value)
+	}
+	t, err := parseDateTime(value, params)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// parseDateTime parses a DATE-TIME in UTC, in the zone named by TZID, or in UTC when the zone is
+// unknown or the time is floating
+func parseDateTime(value string, params map[string]string) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseDurationDays returns the whole days in a DURATION such as P3D, P1W or PT36H
+func parseDurationDays(value string) (int, error) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(value, "+"), "P")
+	if !ok {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var days, hours int
+	inTime := false
+	num := ""
+	for _, c := range rest {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		num = ""
+		switch {
+		case c == 'W':
+			days += 7 * n
+		case c == 'D':
+			days += n
+		case c == 'H' && inTime:
+			hours += n
+		case (c == 'M' || c == 'S') && inTime:
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return days + hours/24, nil
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, c := range s {
+		if escaped {
+			if c == 'n' || c == 'N' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteRune(c)
+			}
+			escaped = false
+			continue
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	feed := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Outside//EN",
+		"BEGIN:VEVENT",
+		"UID:abc@outside.example",
+		"DTSTART;VALUE=DATE:20500110",
+		"DTEND;VALUE=DATE:20500113",
+		"SUMMARY:Reserved\\, with a very long summary that is folded onto the",
+		"  next line",
+		"BEGIN:VALARM",
+		"DTSTART:20000101T000000Z",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		`DTSTART;TZID="America/New_York":20500201T150000`,
+		"DURATION:P2D",
+		"STATUS:cancelled",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:one-day",
+		"DTSTART;VALUE=DATE:20500301",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	tests := []struct {
+		name     string
+		e        Event
+		expUID   string
+		expStart time.Time
+		expEnd   time.Time
+	}{
+		{"dates", events[0], "abc@outside.example", day(2050, 1, 10), day(2050, 1, 13)},
+		{"duration_without_uid", events[1], "20500201-20500203", day(2050, 2, 1), day(2050, 2, 3)},
+		{"start_only", events[2], "one-day", day(2050, 3, 1), day(2050, 3, 2)},
+	}
+	for _, e := range tests {
+		if e.e.UID != e.expUID || !e.e.Start.Equal(e.expStart) || !e.e.End.Equal(e.expEnd) {
+			t.Errorf("Failed %s: expected %s from %s to %s, got %s from %s to %s", e.name,
+				e.expUID, e.expStart, e.expEnd, e.e.UID, e.e.Start, e.e.End)
+		}
+	}
+	if events[0].Summary != "Reserved, with a very long summary that is folded onto the next line" {
+		t.Errorf("unexpected summary %q", events[0].Summary)
+	}
+	if events[1].Status != StatusCancelled {
+		t.Errorf("expected the second event to be cancelled, got %q", events[1].Status)
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	c := Calendar{ProdID: "-//Test//EN", Events: []Event{{
+		UID:     "reservation-1@example.com",
+		Start:   day(2050, 1, 10),
+		End:     day(2050, 1, 12),
+		Summary: strings.Repeat("Smith; John, ", 10),
+	}}}
+	var buf bytes.Buffer
+	err := c.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UID != c.Events[0].UID || events[0].Summary != c.Events[0].Summary ||
+		!events[0].Start.Equal(c.Events[0].Start) || !events[0].End.Equal(c.Events[0].End) {
+		t.Errorf("expected %+v back, got %+v", c.Events, events)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+	}{
+		{"html", "<html><body>Please log in</body></html>"},
+		{"empty", ""},
+		{"truncated", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART;VALUE=DATE:20500101\r\n"},
+		{"bad_date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:tomorrow\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"no_start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.feed)); err == nil {
+			t.Errorf("Failed %s: expected an error", e.name)
+		}
+	}
+}
+
+func TestParseDurationDays(t *testing.T) {
+	tests := map[string]int{"P3D": 3, "P1W": 7, "PT48H": 2, "P1DT12H": 1, "+P2D": 2}
+	for value, exp := range tests {
+		days, err := parseDurationDays(value)
+		if err != nil || days != exp {
+			t.Errorf("%s: expected %d days, got %d (%v)", value, exp, days, err)
+		}
+	}
+	if _, err := parseDurationDays("3D"); err == nil {
+		t.Error("expected an error for a duration without P")
+	}
+}
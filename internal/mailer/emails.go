@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// Confirmation is sent to the guest when they book
+type Confirmation struct {
+	Reservation models.Reservation
+	// ManageURL is where the guest can view, change or cancel the reservation
+	ManageURL string
+}
+
+func (Confirmation) Template() string { return "confirmation" }
+
+// OwnerNotification tells the owner about a new booking
+type OwnerNotification struct {
+	Reservation models.Reservation
+}
+
+func (OwnerNotification) Template() string { return "owner_notification" }
+
+// DatesChanged tells the owner that a guest moved their stay from the reservation's dates to the
+// new ones. With a ManageURL it is the guest's copy instead.
+type DatesChanged struct {
+	Reservation models.Reservation
+	NewStart    time.Time
+	NewEnd      time.Time
+	// NewTotal is the price of the new dates, in cents
+	NewTotal int
+	// ManageURL is where the guest can view, change or cancel the reservation
+	ManageURL string
+}
+
+func (DatesChanged) Template() string { return "dates_changed" }
+
+// Cancellation tells the owner that a guest cancelled. When the guest had paid a deposit, Refunded
+// says whether it was given back, and RefundError why not.
+type Cancellation struct {
+	Reservation models.Reservation
+	Refunded    bool
+	RefundError string
+}
+
+func (Cancellation) Template() string { return "cancellation" }
+
+// Reminder is sent to the guest shortly before they arrive
+type Reminder struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+func (Reminder) Template() string { return "reminder" }
+
+// ThankYou is sent to the guest after they leave, asking for a review at ReviewURL if it is set
+type ThankYou struct {
+	Reservation models.Reservation
+	ReviewURL   string
+}
+
+func (ThankYou) Template() string { return "thank_you" }
+
+// PasswordReset sends a user the link to choose a new password
+type PasswordReset struct {
+	User     models.User
+	ResetURL string
+}
+
+func (PasswordReset) Template() string { return "password_reset" }
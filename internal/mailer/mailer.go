@@ -0,0 +1,163 @@
+// Package mailer writes the emails the app sends from templates. Every kind of email is a pair of
+// templates, <name>.html.tmpl and <name>.txt.tmpl, so each message carries a plain text version
+// next to the HTML one. Both are parsed once, with layout.html.tmpl and layout.txt.tmpl, and the
+// data they are executed with is one of the typed structs in this package.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+const (
+	htmlExt    = ".html.tmpl"
+	textExt    = ".txt.tmpl"
+	layoutName = "layout"
+)
+
+// functions are the same helpers the pages use, for both kinds of template
+var functions = map[string]interface{}{
+	"humanDate":   humanDate,
+	"formatMoney": formatMoney,
+}
+
+// humanDate formats a date as yyyy-mm-dd
+func humanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// formatMoney formats an amount in cents as dollars, e.g. 12050 as $120.50
+func formatMoney(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
+// Email is the data of one kind of email
+type Email interface {
+	// Template is the name of the templates the email is written with
+	Template() string
+}
+
+// Templates holds the parsed email templates by name
+type Templates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// Load parses the email templates in dir. Every HTML template needs a plain text one of the same
+// name and the other way round, and the plain text one defines the subject as well as the body.
+func Load(dir string) (*Templates, error) {
+	t := &Templates{
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+	htmlLayout := filepath.Join(dir, layoutName+htmlExt)
+	textLayout := filepath.Join(dir, layoutName+textExt)
+
+	htmlPages, err := pages(dir, htmlExt)
+	if err != nil {
+		return nil, err
+	}
+	for name, page := range htmlPages {
+		ts, err := htmltemplate.New(layoutName+htmlExt).Funcs(functions).ParseFiles(htmlLayout, page)
+		if err != nil {
+			return nil, err
+		}
+		t.html[name] = ts
+	}
+
+	textPages, err := pages(dir, textExt)
+	if err != nil {
+		return nil, err
+	}
+	for name, page := range textPages {
+		ts, err := texttemplate.New(layoutName+textExt).Funcs(functions).ParseFiles(textLayout, page)
+		if err != nil {
+			return nil, err
+		}
+		if ts.Lookup("subject") == nil {
+			return nil, fmt.Errorf("email template %s does not define a subject", page)
+		}
+		t.text[name] = ts
+	}
+
+	for _, name := range t.Names() {
+		if t.html[name] == nil {
+			return nil, fmt.Errorf("email template %s has no HTML version", name)
+		}
+		if t.text[name] == nil {
+			return nil, fmt.Errorf("email template %s has no plain text version", name)
+		}
+	}
+	return t, nil
+}
+
+// pages returns the templates in dir with the extension ext by name, leaving out the layout
+func pages(dir, ext string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ext)
+		if name != layoutName {
+			found[name] = file
+		}
+	}
+	return found, nil
+}
+
+// Names returns the names of the templates, sorted
+func (t *Templates) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for name := range t.html {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range t.text {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render writes e from its templates into an email from from to to
+func (t *Templates) Render(to, from string, e Email) (models.MailData, error) {
+	name := e.Template()
+	html, text := t.html[name], t.text[name]
+	if html == nil || text == nil {
+		return models.MailData{}, fmt.Errorf("no email template named %s", name)
+	}
+
+	var subject, plain, content bytes.Buffer
+	err := text.ExecuteTemplate(&subject, "subject", e)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	err = text.Execute(&plain, e)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	err = html.Execute(&content, e)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	return models.MailData{
+		To:      to,
+		From:    from,
+		Subject: strings.TrimSpace(subject.String()),
+		Content: content.String(),
+		Text:    plain.String(),
+	}, nil
+}
@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+const templateDir = "./../../email_templates"
+
+var res = models.Reservation{
+	FirstName:        "John",
+	LastName:         "Smith",
+	StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	Room:             models.Room{RoomName: "General's Quarters"},
+	ConfirmationCode: "ABCD2345",
+	TotalPrice:       24000,
+	AmountPaid:       7200,
+}
+
+func loadTemplates(t *testing.T) *Templates {
+	t.Helper()
+	templates, err := Load(templateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func TestLoad(t *testing.T) {
+	expected := []string{"cancellation", "confirmation", "dates_changed", "owner_notification", "password_reset", "reminder", "thank_you"}
+	if names := loadTemplates(t).Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected templates %v, got %v", expected, names)
+	}
+}
+
+var renderTests = []struct {
+	name       string
+	email      Email
+	expSubject string
+	// expected in both the HTML and the plain text version
+	expContent []string
+}{
+	{"confirmation", Confirmation{Reservation: res, ManageURL: "http://localhost:8080/reservations/ABCD2345"}, "Reservation confirmation",
+		[]string{"Dear John", "2050-01-01 to 2050-01-03", "$240.00", "ABCD2345", "http://localhost:8080/reservations/ABCD2345"}},
+	{"owner_notification", OwnerNotification{Reservation: res}, "New Reservation",
+		[]string{"John Smith has made a reservation", "ABCD2345"}},
+	{"dates_changed", DatesChanged{Reservation: res, NewStart: res.StartDate.AddDate(0, 0, 7), NewEnd: res.EndDate.AddDate(0, 0, 7), NewTotal: 26000}, "Reservation changed",
+		[]string{"2050-01-01 - 2050-01-03 to 2050-01-08 - 2050-01-10", "$260.00"}},
+	{"dates_changed_guest", DatesChanged{Reservation: res, NewStart: res.StartDate.AddDate(0, 0, 7), NewEnd: res.EndDate.AddDate(0, 0, 7), NewTotal: 26000, ManageURL: "http://localhost:8080/reservations/ABCD2345"}, "Reservation changed",
+		[]string{"Dear John", "Your reservation ABCD2345", "2050-01-01 - 2050-01-03 to 2050-01-08 - 2050-01-10", "http://localhost:8080/reservations/ABCD2345"}},
+	{"cancellation", Cancellation{Reservation: res}, "Reservation cancelled",
+		[]string{"John Smith has cancelled the reservation ABCD2345"}},
+	{"cancellation_refunded", Cancellation{Reservation: res, Refunded: true}, "Reservation cancelled",
+		[]string{"The deposit of $72.00 has been refunded."}},
+	{"cancellation_not_refunded", Cancellation{Reservation: res, RefundError: "card expired"}, "Reservation cancelled",
+		[]string{"The deposit of $72.00 could not be refunded automatically: card expired"}},
+	{"reminder", Reminder{Reservation: res, ManageURL: "http://localhost:8080/reservations/ABCD2345"}, "Your stay at Fort Dowry is coming up",
+		[]string{"Dear John", "starts on 2050-01-01", "http://localhost:8080/reservations/ABCD2345"}},
+	{"thank_you", ThankYou{Reservation: res}, "Thank you for staying at Fort Dowry",
+		[]string{"Dear John", "from 2050-01-01 to 2050-01-03"}},
+	{"thank_you_review", ThankYou{Reservation: res, ReviewURL: "https://reviews.example.com/fort-dowry"}, "Thank you for staying at Fort Dowry",
+		[]string{"please", "https://reviews.example.com/fort-dowry"}},
+	{"password_reset", PasswordReset{User: models.User{FirstName: "Jane"}, ResetURL: "http://localhost:8080/user/reset-password?token=abc"}, "Reset your password",
+		[]string{"Dear Jane", "http://localhost:8080/user/reset-password?token=abc"}},
+}
+
+func TestRender(t *testing.T) {
+	templates := loadTemplates(t)
+	for _, e := range renderTests {
+		m, err := templates.Render("guest@example.com", "me@here.com", e.email)
+		if err != nil {
+			t.Errorf("Failed %s: %v", e.name, err)
+			continue
+		}
+		if m.To != "guest@example.com" || m.From != "me@here.com" || m.Subject != e.expSubject {
+			t.Errorf("Failed %s: unexpected email %s to %s from %s", e.name, m.Subject, m.To, m.From)
+		}
+		if !strings.Contains(m.Content, "<html") {
+			t.Errorf("Failed %s: expected the HTML version to use the layout", e.name)
+		}
+		if strings.Contains(m.Text, "<") || !strings.HasSuffix(m.Text, "Fort Dowry B&B\n") {
+			t.Errorf("Failed %s: expected a plain text version using the layout, got %q", e.name, m.Text)
+		}
+		for _, want := range e.expContent {
+			if !strings.Contains(m.Content, want) {
+				t.Errorf("Failed %s: expected the HTML version to contain %q", e.name, want)
+			}
+			if !strings.Contains(m.Text, want) {
+				t.Errorf("Failed %s: expected the plain text version to contain %q", e.name, want)
+			}
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	guest := res
+	guest.FirstName = `<script>alert("hi")</script>`
+	m, err := loadTemplates(t).Render("guest@example.com", "me@here.com", Confirmation{Reservation: guest, ManageURL: `javascript:alert("hi")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(m.Content, "<script>") || !strings.Contains(m.Content, "&lt;script&gt;") {
+		t.Error("expected the guest's name to be escaped in the HTML version")
+	}
+	if strings.Contains(m.Content, `href="javascript:`) {
+		t.Error("expected an unsafe link to be left out of the HTML version")
+	}
+	if !strings.Contains(m.Text, guest.FirstName) {
+		t.Error("expected the guest's name as it is in the plain text version")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		expErr string
+	}{
+		{"no_text", map[string]string{"welcome.html.tmpl": `{{define "body"}}hi{{end}}`}, "welcome has no plain text version"},
+		{"no_html", map[string]string{"welcome.txt.tmpl": `{{define "subject"}}Hi{{end}}{{define "body"}}hi{{end}}`}, "welcome has no HTML version"},
+		{"no_subject", map[string]string{
+			"welcome.html.tmpl": `{{define "body"}}hi{{end}}`,
+			"welcome.txt.tmpl":  `{{define "body"}}hi{{end}}`,
+		}, "does not define a subject"},
+		{"bad_syntax", map[string]string{"welcome.html.tmpl": `{{define "body"}}{{.Name{{end}}`}, "welcome.html.tmpl:1"},
+	}
+	for _, e := range tests {
+		dir := t.TempDir()
+		files := map[string]string{
+			"layout.html.tmpl": `<html>{{template "body" .}}</html>`,
+			"layout.txt.tmpl":  `{{template "body" .}}`,
+		}
+		for name, content := range e.files {
+			files[name] = content
+		}
+		for name, content := range files {
+			err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := Load(dir)
+		if err == nil || !strings.Contains(err.Error(), e.expErr) {
+			t.Errorf("Failed %s: expected error %q, got %v", e.name, e.expErr, err)
+		}
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	_, err := loadTemplates(t).Render("guest@example.com", "me@here.com", unknownEmail{})
+	if err == nil {
+		t.Error("expected an error for an email without templates")
+	}
+}
+
+type unknownEmail struct{}
+
+func (unknownEmail) Template() string { return "unknown" }
@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// Audited actions
+const (
+	AuditCreate       = "create"
+	AuditUpdate       = "update"
+	AuditDelete       = "delete"
+	AuditRestore      = "restore"
+	AuditStatusChange = "status_change"
+	AuditRefund       = "refund"
+	AuditPassword     = "password_change"
+	AuditActivate     = "activate"
+	AuditDeactivate   = "deactivate"
+	AuditRevoke       = "revoke"
+	AuditSync         = "sync"
+	AuditMerge        = "merge"
+	AuditResend       = "resend"
+)
+
+// Kinds of entity recorded in the audit log
+const (
+	EntityReservation    = "reservation"
+	EntityBlock          = "block"
+	EntityRecurringBlock = "recurring_block"
+	EntityRoom           = "room"
+	EntityRoomPhoto      = "room_photo"
+	EntityRoomRate       = "room_rate"
+	EntityCalendarFeed   = "calendar_feed"
+	EntityUser           = "user"
+	EntityAPIToken       = "api_token"
+	EntityGuest          = "guest"
+	EntityEmail          = "email"
+)
+
+// AuditEntities lists the kinds of entity in the audit log, e.g. for filters
+var AuditEntities = []string{
+	EntityReservation,
+	EntityBlock,
+	EntityRecurringBlock,
+	EntityRoom,
+	EntityRoomPhoto,
+	EntityRoomRate,
+	EntityCalendarFeed,
+	EntityUser,
+	EntityAPIToken,
+	EntityGuest,
+	EntityEmail,
+}
+
+// AuditEntry records one change made in the admin area or through the API. UserID is 0 when the
+// user has since been removed.
+type AuditEntry struct {
+	ID         int
+	UserID     int
+	Action     string
+	EntityType string
+	EntityID   int
+	Changes    []AuditChange
+	CreatedAt  time.Time
+	User       User
+}
+
+// AuditChange is one field of an entity before and after a change. Before is empty for entities
+// that were created and After is empty for entities that were removed.
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditFilter narrows down the audit log. Zero fields match every entry.
+type AuditFilter struct {
+	// Query matches the action, entity, changed fields and values, or the user's name or email
+	Query      string
+	UserID     int
+	EntityType string
+	EntityID   int
+	// From and To are the first and last day included
+	From  time.Time
+	To    time.Time
+	Limit int
+}
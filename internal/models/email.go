@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Delivery states of an email in the outbox
+const (
+	EmailQueued = "queued"
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+// Email is an email in the outbox. A queued email is retried until it is sent or has failed too
+// many times, when it is left as failed until staff resend it.
+type Email struct {
+	ID int
+	MailData
+	Status    string
+	Attempts  int
+	LastError string
+	// NextAttemptAt is when a queued email is due to be sent
+	NextAttemptAt time.Time
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
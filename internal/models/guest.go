@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Guest is a person who has made reservations, known by their email address. Each reservation
+// keeps the details it was booked with, while the guest holds the ones staff have on file.
+type Guest struct {
+	ID        int
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	// Notes are for staff only, e.g. "Prefers a ground floor room"
+	Notes string
+	Tags  []string
+	// MergedInto is the guest this one was merged into as a duplicate, or 0. Bookings made with a
+	// merged guest's email go to that guest.
+	MergedInto int
+	// Stays, Nights and LastStay summarise the guest's reservations in guest lists
+	Stays     int
+	Nights    int
+	LastStay  time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TagVIP marks the guests staff should look after especially well
+const TagVIP = "VIP"
+
+// SuggestedTags are offered when tagging a guest, though any tag may be used
+var SuggestedTags = []string{TagVIP, "Returning", "Corporate", "Accessibility", "Do not rebook"}
+
+// HasTag reports whether the guest has tag, ignoring case
+func (g Guest) HasTag(tag string) bool {
+	for _, t := range g.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags splits comma separated tags, trimming them and leaving out empty tags and tags that
+// only differ in case from an earlier one
+func ParseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !(Guest{Tags: tags}).HasTag(t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// Merge returns the guest with the notes and tags of its duplicate dup added, and dup's phone if
+// the guest has none. The guest's name and email are kept.
+func (g Guest) Merge(dup Guest) Guest {
+	switch {
+	case g.Notes == "":
+		g.Notes = dup.Notes
+	case dup.Notes != "":
+		g.Notes += "\n\n" + dup.Notes
+	}
+	g.Tags = ParseTags(strings.Join(append(append([]string{}, g.Tags...), dup.Tags...), ","))
+	if g.Phone == "" {
+		g.Phone = dup.Phone
+	}
+	return g
+}
+
+// CountsAsStay reports whether the reservation adds to its guest's stays and nights. Cancelled
+// reservations and no-shows do not.
+func (r Reservation) CountsAsStay() bool {
+	return r.Status != StatusCancelled && r.Status != StatusNoShow
+}
+
+// Nights returns the number of nights the reservation is for. Rounding to whole days keeps a
+// stay across a daylight saving change from losing a night.
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Round(24*time.Hour) / (24 * time.Hour))
+}
+
+// StayTotals returns the number of reservations that count as stays and the nights they add up to
+func StayTotals(reservations []Reservation) (stays, nights int) {
+	for _, res := range reservations {
+		if res.CountsAsStay() {
+			stays++
+			nights += res.Nights()
+		}
+	}
+	return stays, nights
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := map[string][]string{
+		"":                          nil,
+		"VIP":                       {"VIP"},
+		" VIP , Corporate,,":        {"VIP", "Corporate"},
+		"VIP, vip, Returning, VIP ": {"VIP", "Returning"},
+	}
+	for in, expected := range tests {
+		if got := ParseTags(in); !reflect.DeepEqual(got, expected) {
+			t.Errorf("ParseTags(%q): expected %v, got %v", in, expected, got)
+		}
+	}
+}
+
+func TestGuestHasTag(t *testing.T) {
+	g := Guest{Tags: []string{"VIP", "Corporate"}}
+	if !g.HasTag("vip") || !g.HasTag(TagVIP) {
+		t.Error("expected the guest to have the VIP tag whatever its case")
+	}
+	if g.HasTag("Returning") {
+		t.Error("expected the guest not to have the Returning tag")
+	}
+}
+
+func TestStayTotals(t *testing.T) {
+	arrival := time.Date(2050, 3, 20, 0, 0, 0, 0, time.UTC)
+	// the second stay crosses the change to summer time, when one of its days is 23 hours long
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		berlin = time.UTC
+	}
+	local := time.Date(2050, 3, 25, 0, 0, 0, 0, berlin)
+	reservations := []Reservation{
+		{Status: StatusCheckedOut, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 3)},
+		{Status: StatusConfirmed, StartDate: local, EndDate: local.AddDate(0, 0, 4)},
+		{Status: StatusCancelled, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 7)},
+		{Status: StatusNoShow, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 1)},
+	}
+	stays, nights := StayTotals(reservations)
+	if stays != 2 || nights != 7 {
+		t.Errorf("expected 2 stays of 7 nights, got %d stays of %d nights", stays, nights)
+	}
+}
+
+func TestGuestMerge(t *testing.T) {
+	keep := Guest{ID: 1, FirstName: "John", Email: "john@smith.com", Notes: "Late arrival", Tags: []string{"VIP"}}
+	dup := Guest{ID: 2, FirstName: "Jon", Email: "jon@smith.com", Phone: "555-0100", Notes: "Allergic to feathers", Tags: []string{"vip", "Corporate"}}
+
+	got := keep.Merge(dup)
+	if got.ID != 1 || got.FirstName != "John" || got.Email != "john@smith.com" {
+		t.Errorf("expected the kept guest's name and email, got %+v", got)
+	}
+	if got.Phone != "555-0100" {
+		t.Errorf("expected the duplicate's phone, got %q", got.Phone)
+	}
+	if got.Notes != "Late arrival\n\nAllergic to feathers" {
+		t.Errorf("expected both notes, got %q", got.Notes)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"VIP", "Corporate"}) {
+		t.Errorf("expected the tags combined, got %v", got.Tags)
+	}
+	if len(keep.Tags) != 1 {
+		t.Errorf("expected the kept guest's tags to be left alone, got %v", keep.Tags)
+	}
+	if (Guest{}).Merge(dup).Notes != dup.Notes {
+		t.Error("expected the duplicate's notes on a guest without any")
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Orders the admin reservation lists can be sorted in
+const (
+	SortStartDate = "start_date"
+	SortCreatedAt = "created_at"
+	SortGuestName = "name"
+)
+
+// ReservationSorts lists the sort orders of the reservation lists, the default first
+var ReservationSorts = []string{SortStartDate, SortCreatedAt, SortGuestName}
+
+// ValidReservationSort reports whether sort is one of ReservationSorts
+func ValidReservationSort(sort string) bool {
+	for _, s := range ReservationSorts {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservationFilter selects one page of the reservations that are not in the trash. Zero fields
+// match every reservation.
+type ReservationFilter struct {
+	// Query matches part of the guest's name, email or phone
+	Query  string
+	RoomID int
+	Status string
+	// From and To select the stays that overlap the nights from From up to and including To
+	From time.Time
+	To   time.Time
+	// Sort is one of ReservationSorts, and Desc reverses it
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+}
+
+// Offset is the number of reservations before the filter's page, counting pages from 1
+func (f ReservationFilter) Offset() int {
+	if f.Page < 1 {
+		return 0
+	}
+	return (f.Page - 1) * f.PerPage
+}
+
+// ReservationPage is one page of the reservations matching a filter. Total counts every match.
+type ReservationPage struct {
+	Reservations []Reservation
+	Page         int
+	PerPage      int
+	Total        int
+}
+
+// Pages returns the number of pages the matches fill, at least 1
+func (p ReservationPage) Pages() int {
+	if p.PerPage < 1 || p.Total <= p.PerPage {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// HasPrev reports whether there is a page before this one
+func (p ReservationPage) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext reports whether there is a page after this one
+func (p ReservationPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// First is the position of the page's first reservation among all the matches, counting from 1,
+// or 0 if the page is empty
+func (p ReservationPage) First() int {
+	if len(p.Reservations) == 0 {
+		return 0
+	}
+	return (p.Page-1)*p.PerPage + 1
+}
+
+// Last is the position of the page's last reservation among all the matches
+func (p ReservationPage) Last() int {
+	if len(p.Reservations) == 0 {
+		return 0
+	}
+	return p.First() + len(p.Reservations) - 1
+}
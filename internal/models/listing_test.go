@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestReservationPage(t *testing.T) {
+	tests := []struct {
+		name               string
+		page               ReservationPage
+		pages, first, last int
+		hasPrev, hasNext   bool
+	}{
+		{"empty", ReservationPage{Page: 1, PerPage: 25}, 1, 0, 0, false, false},
+		{"one_page", ReservationPage{Reservations: make([]Reservation, 3), Page: 1, PerPage: 25, Total: 3}, 1, 1, 3, false, false},
+		{"first_of_three", ReservationPage{Reservations: make([]Reservation, 25), Page: 1, PerPage: 25, Total: 60}, 3, 1, 25, false, true},
+		{"middle", ReservationPage{Reservations: make([]Reservation, 25), Page: 2, PerPage: 25, Total: 60}, 3, 26, 50, true, true},
+		{"last_partial", ReservationPage{Reservations: make([]Reservation, 10), Page: 3, PerPage: 25, Total: 60}, 3, 51, 60, true, false},
+		{"exact_fit", ReservationPage{Reservations: make([]Reservation, 25), Page: 2, PerPage: 25, Total: 50}, 2, 26, 50, true, false},
+		{"past_the_end", ReservationPage{Page: 9, PerPage: 25, Total: 60}, 3, 0, 0, true, false},
+	}
+	for _, e := range tests {
+		p := e.page
+		if p.Pages() != e.pages || p.First() != e.first || p.Last() != e.last || p.HasPrev() != e.hasPrev || p.HasNext() != e.hasNext {
+			t.Errorf("Failed %s: got pages %d, first %d, last %d, prev %t, next %t", e.name, p.Pages(), p.First(), p.Last(), p.HasPrev(), p.HasNext())
+		}
+	}
+}
+
+func TestReservationFilterOffset(t *testing.T) {
+	for page, expected := range map[int]int{0: 0, 1: 0, 2: 25, 4: 75} {
+		if got := (ReservationFilter{Page: page, PerPage: 25}).Offset(); got != expected {
+			t.Errorf("page %d: expected offset %d, got %d", page, expected, got)
+		}
+	}
+}
@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+// Access levels stored in users.access_level
+const (
+	AccessLevelAuditor = 1
+	AccessLevelStaff   = 2
+	AccessLevelOwner   = 3
+)
+
 // User model
 type User struct {
 	ID          int
@@ -12,18 +19,71 @@ type User struct {
 	LastName    string
 	Email       string
 	Password    string
+	Active      bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
 // Room model
 type Room struct {
+	ID           int
+	RoomName     string
+	Slug         string
+	Description  string
+	MaxOccupancy int
+	// BaseRate is the nightly rate in cents
+	BaseRate int
+	// WeekendSurcharge is added to Friday and Saturday nights, in cents
+	WeekendSurcharge int
+	MinNights        int
+	Active           bool
+	Photos           []RoomPhoto
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// CoverPhoto returns the path of the room's first photo, or "" if it has none
+func (r Room) CoverPhoto() string {
+	if len(r.Photos) == 0 {
+		return ""
+	}
+	return r.Photos[0].Path
+}
+
+// RoomRate overrides a room's nightly rate or minimum stay between two dates, both inclusive.
+// Yearly rates are seasons that repeat every year on the same days.
+type RoomRate struct {
+	ID        int
+	RoomID    int
+	Name      string
+	StartDate time.Time
+	EndDate   time.Time
+	// NightlyRate is in cents, 0 keeps the room's base rate
+	NightlyRate int
+	// MinNights applies to stays arriving within the rate, 0 keeps the room's minimum stay
+	MinNights int
+	Yearly    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RoomPhoto model
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Path      string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Restriction types, matching the rows of the restrictions table
+const (
+	RestrictionReservation     = 1
+	RestrictionOwnerBlock      = 2
+	RestrictionExternalBooking = 3
+)
+
 type Restriction struct {
 	ID              int
 	RestrictionName string
@@ -32,18 +92,52 @@ type Restriction struct {
 }
 
 type Reservation struct {
-	ID        int
-	RoomID    int
-	Processed int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
+	ID               int
+	RoomID           int
+	// GuestID is the guest the reservation was linked to by its email
+	GuestID          int
+	Status           string
+	ConfirmationCode string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	TotalPrice       int // cents
+	PaymentStatus    string
+	PaymentID        string
+	AmountPaid       int // cents
+	CancelledAt      time.Time
+	// DeletedAt is set while the reservation is in the trash, and DeletedBy is who put it there
+	DeletedAt        time.Time
+	DeletedBy        User
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Room             Room
+}
+
+// Payment statuses of a reservation. Reservations made without a deposit have no status.
+const (
+	PaymentPending    = "pending"
+	PaymentProcessing = "processing"
+	PaymentDeclined   = "declined"
+	PaymentAuthorized = "authorized"
+	PaymentPaid       = "paid"
+	PaymentRefunded   = "refunded"
+)
+
+// PaymentHold is how long a new reservation keeps its dates while the guest pays the deposit
+const PaymentHold = 30 * time.Minute
+
+// AwaitingPayment reports whether the guest still has to pay the deposit
+func (r Reservation) AwaitingPayment() bool {
+	return r.PaymentStatus == PaymentPending || r.PaymentStatus == PaymentDeclined
+}
+
+// Cancelled reports whether the reservation has been cancelled, by the guest or by staff
+func (r Reservation) Cancelled() bool {
+	return r.Status == StatusCancelled
 }
 
 type RoomRestriction struct {
@@ -53,11 +147,50 @@ type RoomRestriction struct {
 	RestrictionID int
 	StartDate     time.Time
 	EndDate       time.Time
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+	// Note says why an owner block was made, e.g. "Renovation"
+	Note string
+	// FeedID and ExternalUID identify the event an external booking was imported from
+	FeedID      int
+	ExternalUID string
+	// RecurringBlockID is set on the blocks expanded from a recurring block, which are not stored
+	RecurringBlockID int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// CalendarFeed is an iCalendar feed published by an outside booking site, whose events are
+// imported as external bookings for a room
+type CalendarFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	// LastError is empty when the last sync succeeded
+	LastError  string
+	EventCount int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+}
+
+// APIToken is a bearer token issued to a user for the JSON API. Only its hash is stored.
+// A token with a RoomID is a feed token: it reads that room's calendar feed and nothing else.
+type APIToken struct {
+	ID         int
+	UserID     int
+	RoomID     int
+	Name       string
+	TokenHash  string
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+	Room       Room
 }
 
 //MailData holds information for an email message
@@ -65,6 +198,7 @@ type MailData struct {
 	To      string
 	From    string
 	Subject string
+	// Content is the HTML body and Text the plain text one sent alongside it, if any
 	Content string
-	Template string
+	Text    string
 }
@@ -0,0 +1,8 @@
+package models
+
+// Kinds of scheduled email sent to a guest around their stay. Each is recorded per reservation
+// once it is queued, so it is sent only once.
+const (
+	NotificationReminder = "reminder"
+	NotificationThankYou = "thank_you"
+)
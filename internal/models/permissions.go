@@ -0,0 +1,93 @@
+package models
+
+// Permission names an action in the admin area that is limited to some roles
+type Permission string
+
+const (
+	PermViewReservations    Permission = "reservations.view"
+	PermEditReservations    Permission = "reservations.edit"
+	PermProcessReservations Permission = "reservations.process"
+	PermDeleteReservations  Permission = "reservations.delete"
+	PermEditCalendar        Permission = "calendar.edit"
+	PermManageAPITokens     Permission = "api_tokens.manage"
+	PermManageUsers         Permission = "users.manage"
+	PermManageRooms         Permission = "rooms.manage"
+	PermRefundPayments      Permission = "payments.refund"
+	PermViewAudit           Permission = "audit.view"
+	PermMergeGuests         Permission = "guests.merge"
+	PermManageEmails        Permission = "emails.manage"
+)
+
+// rolePermissions maps every access level to the permissions it grants
+var rolePermissions = map[int][]Permission{
+	AccessLevelAuditor: {
+		PermViewReservations,
+	},
+	AccessLevelStaff: {
+		PermViewReservations,
+		PermEditReservations,
+		PermProcessReservations,
+		PermEditCalendar,
+	},
+	AccessLevelOwner: {
+		PermViewReservations,
+		PermEditReservations,
+		PermProcessReservations,
+		PermDeleteReservations,
+		PermEditCalendar,
+		PermManageAPITokens,
+		PermManageUsers,
+		PermManageRooms,
+		PermRefundPayments,
+		PermViewAudit,
+		PermMergeGuests,
+		PermManageEmails,
+	},
+}
+
+// Role pairs an access level with its display name
+type Role struct {
+	AccessLevel int
+	Name        string
+}
+
+// roleNames holds the display name of every access level
+var roleNames = map[int]string{
+	AccessLevelAuditor: "Auditor",
+	AccessLevelStaff:   "Front Desk",
+	AccessLevelOwner:   "Owner",
+}
+
+// Can reports whether the user's role grants permission p
+func (u User) Can(p Permission) bool {
+	for _, granted := range rolePermissions[u.AccessLevel] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleName returns the display name of the user's role
+func (u User) RoleName() string {
+	name, ok := roleNames[u.AccessLevel]
+	if !ok {
+		return "None"
+	}
+	return name
+}
+
+// Roles returns every role, lowest access level first
+func Roles() []Role {
+	return []Role{
+		{AccessLevel: AccessLevelAuditor, Name: roleNames[AccessLevelAuditor]},
+		{AccessLevel: AccessLevelStaff, Name: roleNames[AccessLevelStaff]},
+		{AccessLevel: AccessLevelOwner, Name: roleNames[AccessLevelOwner]},
+	}
+}
+
+// IsValidAccessLevel reports whether level belongs to a known role
+func IsValidAccessLevel(level int) bool {
+	_, ok := roleNames[level]
+	return ok
+}
@@ -0,0 +1,50 @@
+package models
+
+import "testing"
+
+var canTests = []struct {
+	name        string
+	accessLevel int
+	permission  Permission
+	expected    bool
+}{
+	{"auditor_view", AccessLevelAuditor, PermViewReservations, true},
+	{"auditor_edit", AccessLevelAuditor, PermEditReservations, false},
+	{"staff_process", AccessLevelStaff, PermProcessReservations, true},
+	{"staff_calendar", AccessLevelStaff, PermEditCalendar, true},
+	{"staff_delete", AccessLevelStaff, PermDeleteReservations, false},
+	{"staff_tokens", AccessLevelStaff, PermManageAPITokens, false},
+	{"owner_delete", AccessLevelOwner, PermDeleteReservations, true},
+	{"owner_tokens", AccessLevelOwner, PermManageAPITokens, true},
+	{"owner_users", AccessLevelOwner, PermManageUsers, true},
+	{"staff_users", AccessLevelStaff, PermManageUsers, false},
+	{"owner_rooms", AccessLevelOwner, PermManageRooms, true},
+	{"staff_rooms", AccessLevelStaff, PermManageRooms, false},
+	{"owner_refunds", AccessLevelOwner, PermRefundPayments, true},
+	{"staff_refunds", AccessLevelStaff, PermRefundPayments, false},
+	{"owner_audit", AccessLevelOwner, PermViewAudit, true},
+	{"staff_audit", AccessLevelStaff, PermViewAudit, false},
+	{"owner_merge_guests", AccessLevelOwner, PermMergeGuests, true},
+	{"staff_merge_guests", AccessLevelStaff, PermMergeGuests, false},
+	{"owner_emails", AccessLevelOwner, PermManageEmails, true},
+	{"staff_emails", AccessLevelStaff, PermManageEmails, false},
+	{"unknown_level", 0, PermViewReservations, false},
+}
+
+func TestUserCan(t *testing.T) {
+	for _, e := range canTests {
+		u := User{AccessLevel: e.accessLevel}
+		if u.Can(e.permission) != e.expected {
+			t.Errorf("Failed %s: expected %t for %s", e.name, e.expected, e.permission)
+		}
+	}
+}
+
+func TestUserRoleName(t *testing.T) {
+	if (User{AccessLevel: AccessLevelOwner}).RoleName() != "Owner" {
+		t.Error("wrong role name for owner")
+	}
+	if (User{}).RoleName() != "None" {
+		t.Error("expected None for a user without a role")
+	}
+}
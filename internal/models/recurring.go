@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// How often a recurring block repeats
+const (
+	RepeatWeekly = "weekly"
+	RepeatYearly = "yearly"
+)
+
+// RecurringBlock is a rule that closes a room on a repeating schedule, such as every Monday or the
+// first week of every February. It is stored once and expanded into blocks wherever availability
+// or the calendar is looked at.
+type RecurringBlock struct {
+	ID     int
+	RoomID int
+	Note   string
+	// Repeat is RepeatWeekly or RepeatYearly
+	Repeat string
+	// Weekday is the night closed by a weekly rule
+	Weekday time.Weekday
+	// StartMonth and StartDay to EndMonth and EndDay are the nights closed by a yearly rule. The
+	// end may be before the start, for a range over the new year.
+	StartMonth time.Month
+	StartDay   int
+	EndMonth   time.Month
+	EndDay     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+}
+
+// Covers reports whether the rule closes the room on the night of day
+func (b RecurringBlock) Covers(day time.Time) bool {
+	switch b.Repeat {
+	case RepeatWeekly:
+		return day.Weekday() == b.Weekday
+	case RepeatYearly:
+		d := monthDay(day.Month(), day.Day())
+		start, end := monthDay(b.StartMonth, b.StartDay), monthDay(b.EndMonth, b.EndDay)
+		if start <= end {
+			return d >= start && d <= end
+		}
+		return d >= start || d <= end
+	}
+	return false
+}
+
+// Occurrences returns the nights from start up to, but not including, end that the rule closes,
+// as one owner block for every run of consecutive nights. The blocks have no ID of their own;
+// RecurringBlockID says which rule they came from.
+func (b RecurringBlock) Occurrences(start, end time.Time) []RoomRestriction {
+	var blocks []RoomRestriction
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if !b.Covers(d) {
+			continue
+		}
+		if n := len(blocks); n > 0 && blocks[n-1].EndDate.Equal(d) {
+			blocks[n-1].EndDate = d.AddDate(0, 0, 1)
+			continue
+		}
+		blocks = append(blocks, RoomRestriction{
+			RoomID:           b.RoomID,
+			RestrictionID:    RestrictionOwnerBlock,
+			StartDate:        d,
+			EndDate:          d.AddDate(0, 0, 1),
+			Note:             b.Note,
+			RecurringBlockID: b.ID,
+			CreatedAt:        b.CreatedAt,
+			UpdatedAt:        b.UpdatedAt,
+		})
+	}
+	return blocks
+}
+
+// Blocks reports whether the rule closes any night from start up to, but not including, end
+func (b RecurringBlock) Blocks(start, end time.Time) bool {
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if b.Covers(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule describes the rule, e.g. "Every Monday" or "Every year, Feb 1 to Feb 7"
+func (b RecurringBlock) Schedule() string {
+	if b.Repeat == RepeatWeekly {
+		return fmt.Sprintf("Every %s", b.Weekday)
+	}
+	return fmt.Sprintf("Every year, %s %d to %s %d", b.StartMonth.String()[:3], b.StartDay, b.EndMonth.String()[:3], b.EndDay)
+}
+
+func monthDay(m time.Month, d int) int {
+	return int(m)*100 + d
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+var mondays = RecurringBlock{ID: 1, RoomID: 2, Repeat: RepeatWeekly, Weekday: time.Monday, Note: "Deep clean"}
+var february = RecurringBlock{ID: 2, RoomID: 2, Repeat: RepeatYearly, StartMonth: time.February, StartDay: 1, EndMonth: time.February, EndDay: 7}
+var newYear = RecurringBlock{ID: 3, RoomID: 2, Repeat: RepeatYearly, StartMonth: time.December, StartDay: 24, EndMonth: time.January, EndDay: 2}
+
+var coversTests = []struct {
+	name     string
+	block    RecurringBlock
+	day      time.Time
+	expected bool
+}{
+	{"weekly_monday", mondays, day(2050, 1, 3), true},
+	{"weekly_tuesday", mondays, day(2050, 1, 4), false},
+	{"yearly_first_night", february, day(2050, 2, 1), true},
+	{"yearly_last_night", february, day(2051, 2, 7), true},
+	{"yearly_after", february, day(2050, 2, 8), false},
+	{"yearly_before", february, day(2050, 1, 31), false},
+	{"new_year_december", newYear, day(2050, 12, 30), true},
+	{"new_year_january", newYear, day(2051, 1, 2), true},
+	{"new_year_outside", newYear, day(2051, 1, 3), false},
+	{"unknown_repeat", RecurringBlock{Repeat: "daily"}, day(2050, 1, 3), false},
+}
+
+func TestRecurringBlockCovers(t *testing.T) {
+	for _, e := range coversTests {
+		if e.block.Covers(e.day) != e.expected {
+			t.Errorf("Failed %s: expected %t for %s", e.name, e.expected, e.day.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestRecurringBlockOccurrences(t *testing.T) {
+	// January 2050 has five Mondays
+	blocks := mondays.Occurrences(day(2050, 1, 1), day(2050, 2, 1))
+	if len(blocks) != 5 {
+		t.Fatalf("expected 5 Mondays, got %d", len(blocks))
+	}
+	b := blocks[0]
+	if !b.StartDate.Equal(day(2050, 1, 3)) || !b.EndDate.Equal(day(2050, 1, 4)) || b.RecurringBlockID != 1 ||
+		b.RestrictionID != RestrictionOwnerBlock || b.RoomID != 2 || b.Note != "Deep clean" || b.ID != 0 {
+		t.Errorf("unexpected first Monday %+v", b)
+	}
+
+	// consecutive nights are one block, cut off at the ends of the range
+	blocks = february.Occurrences(day(2050, 2, 3), day(2050, 3, 1))
+	if len(blocks) != 1 || !blocks[0].StartDate.Equal(day(2050, 2, 3)) || !blocks[0].EndDate.Equal(day(2050, 2, 8)) {
+		t.Errorf("expected one block from Feb 3 to Feb 8, got %+v", blocks)
+	}
+	blocks = newYear.Occurrences(day(2050, 12, 1), day(2051, 2, 1))
+	if len(blocks) != 1 || !blocks[0].StartDate.Equal(day(2050, 12, 24)) || !blocks[0].EndDate.Equal(day(2051, 1, 3)) {
+		t.Errorf("expected one block over the new year, got %+v", blocks)
+	}
+}
+
+func TestRecurringBlockBlocks(t *testing.T) {
+	// a stay from Friday to Monday morning does not include Monday night
+	if mondays.Blocks(day(2050, 1, 7), day(2050, 1, 10)) {
+		t.Error("expected a weekend stay to be free")
+	}
+	if !mondays.Blocks(day(2050, 1, 7), day(2050, 1, 11)) {
+		t.Error("expected a stay over Monday night to be blocked")
+	}
+}
+
+func TestRecurringBlockSchedule(t *testing.T) {
+	if s := mondays.Schedule(); s != "Every Monday" {
+		t.Errorf("unexpected weekly schedule %q", s)
+	}
+	if s := newYear.Schedule(); s != "Every year, Dec 24 to Jan 2" {
+		t.Errorf("unexpected yearly schedule %q", s)
+	}
+}
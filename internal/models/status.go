@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// Reservation statuses. Every reservation starts out pending and can only move along
+// reservationTransitions; cancelled, checked out and no-show are final.
+const (
+	StatusPending    = "pending"
+	StatusConfirmed  = "confirmed"
+	StatusCheckedIn  = "checked_in"
+	StatusCheckedOut = "checked_out"
+	StatusCancelled  = "cancelled"
+	StatusNoShow     = "no_show"
+)
+
+// ReservationStatuses lists the statuses in lifecycle order, e.g. for filters
+var ReservationStatuses = []string{
+	StatusPending,
+	StatusConfirmed,
+	StatusCheckedIn,
+	StatusCheckedOut,
+	StatusCancelled,
+	StatusNoShow,
+}
+
+var reservationTransitions = map[string][]string{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusCancelled, StatusNoShow},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+var statusLabels = map[string]string{
+	StatusPending:    "Pending",
+	StatusConfirmed:  "Confirmed",
+	StatusCheckedIn:  "Checked in",
+	StatusCheckedOut: "Checked out",
+	StatusCancelled:  "Cancelled",
+	StatusNoShow:     "No-show",
+}
+
+// StatusChange records one move of a reservation from one status to another. UserID is 0 when the
+// guest made the change.
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	UserID        int
+	CreatedAt     time.Time
+	User          User
+}
+
+// ValidStatus reports whether status is one of the reservation statuses
+func ValidStatus(status string) bool {
+	_, ok := statusLabels[status]
+	return ok
+}
+
+// CanTransition reports whether a reservation may move from one status to the other
+func CanTransition(from, to string) bool {
+	for _, s := range reservationTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusLabel is the name of a status as shown to people, e.g. "Checked in"
+func StatusLabel(status string) string {
+	if label, ok := statusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// NextStatuses returns the statuses the reservation may move to from its current one
+func (r Reservation) NextStatuses() []string {
+	return reservationTransitions[r.Status]
+}
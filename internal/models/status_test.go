@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+var transitionTests = []struct {
+	name     string
+	from     string
+	to       string
+	expected bool
+}{
+	{"confirm", StatusPending, StatusConfirmed, true},
+	{"cancel_pending", StatusPending, StatusCancelled, true},
+	{"check_in_pending", StatusPending, StatusCheckedIn, false},
+	{"check_in", StatusConfirmed, StatusCheckedIn, true},
+	{"no_show", StatusConfirmed, StatusNoShow, true},
+	{"cancel_confirmed", StatusConfirmed, StatusCancelled, true},
+	{"check_out", StatusCheckedIn, StatusCheckedOut, true},
+	{"cancel_checked_in", StatusCheckedIn, StatusCancelled, false},
+	{"reopen_cancelled", StatusCancelled, StatusPending, false},
+	{"after_no_show", StatusNoShow, StatusCheckedIn, false},
+	{"same_status", StatusConfirmed, StatusConfirmed, false},
+	{"unknown_status", StatusPending, "archived", false},
+}
+
+func TestCanTransition(t *testing.T) {
+	for _, e := range transitionTests {
+		if CanTransition(e.from, e.to) != e.expected {
+			t.Errorf("Failed %s: expected %t from %s to %s", e.name, e.expected, e.from, e.to)
+		}
+	}
+}
+
+func TestStatusLabel(t *testing.T) {
+	for _, s := range ReservationStatuses {
+		if !ValidStatus(s) || StatusLabel(s) == s {
+			t.Errorf("expected %s to be a valid status with a label", s)
+		}
+	}
+	if ValidStatus("archived") || StatusLabel("archived") != "archived" {
+		t.Error("expected an unknown status to be invalid and shown as is")
+	}
+}
+
+func TestReservationNextStatuses(t *testing.T) {
+	if n := (Reservation{Status: StatusCheckedIn}).NextStatuses(); len(n) != 1 || n[0] != StatusCheckedOut {
+		t.Errorf("expected a checked in reservation to only check out, got %v", n)
+	}
+	if n := (Reservation{Status: StatusCheckedOut}).NextStatuses(); len(n) != 0 {
+		t.Errorf("expected checked out to be final, got %v", n)
+	}
+}
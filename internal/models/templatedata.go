@@ -13,4 +13,10 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	User            User
+}
+
+// Can reports whether the logged in user may perform p, used to hide actions in templates
+func (td *TemplateData) Can(p Permission) bool {
+	return td.User.Can(p)
 }
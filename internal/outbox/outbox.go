@@ -0,0 +1,132 @@
+// Package outbox delivers the emails queued in the database. An email that cannot be sent is
+// retried with exponential backoff, and after too many failed attempts it is left as failed.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// Store is the part of the repository the worker needs
+type Store interface {
+	ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.Email, error)
+	UpdateEmailDelivery(e models.Email) error
+}
+
+// Worker sends the emails that are due, one batch at a time
+type Worker struct {
+	Store Store
+	// Send sends one email
+	Send     func(models.MailData) error
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	// MaxAttempts is how many times an email is tried before it is marked as failed
+	MaxAttempts int
+	// Backoff is the wait after the first failed attempt. It doubles after every other one, up to
+	// MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BatchSize emails are claimed at once, and held for Lease so no other worker sends them too.
+	// An email still held when a worker stops half way is tried again once the lease runs out.
+	BatchSize int
+	Lease     time.Duration
+	// Now returns the current time
+	Now func() time.Time
+}
+
+// New returns a worker that retries an email after 1 minute, then 2, 4 and so on up to 6 hours
+func New(store Store, send func(models.MailData) error, maxAttempts int, infoLog, errorLog *log.Logger) *Worker {
+	return &Worker{
+		Store:       store,
+		Send:        send,
+		InfoLog:     infoLog,
+		ErrorLog:    errorLog,
+		MaxAttempts: maxAttempts,
+		Backoff:     time.Minute,
+		MaxBackoff:  6 * time.Hour,
+		BatchSize:   10,
+		Lease:       10 * time.Minute,
+		Now:         time.Now,
+	}
+}
+
+// Run delivers the emails that are due straight away and then every interval, until ctx is done.
+// It makes one last delivery before returning, so the emails queued just before are not left
+// until the next start.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.DeliverDue()
+		select {
+		case <-ctx.Done():
+			w.DeliverDue()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every email that is due and returns how many were sent
+func (w *Worker) DeliverDue() int {
+	sent := 0
+	for {
+		emails, err := w.Store.ClaimDueEmails(w.Now(), w.BatchSize, w.Lease)
+		if err != nil {
+			w.ErrorLog.Println("Cannot load queued emails:", err)
+			return sent
+		}
+		for _, e := range emails {
+			if w.deliver(e) {
+				sent++
+			}
+		}
+		if len(emails) < w.BatchSize {
+			return sent
+		}
+	}
+}
+
+// deliver sends one email and records the attempt, reporting whether it was sent
+func (w *Worker) deliver(e models.Email) bool {
+	err := w.Send(e.MailData)
+	now := w.Now()
+	e.Attempts++
+	switch {
+	case err == nil:
+		e.Status = models.EmailSent
+		e.SentAt = now
+		e.LastError = ""
+	case e.Attempts >= w.MaxAttempts:
+		e.Status = models.EmailFailed
+		e.LastError = err.Error()
+		w.ErrorLog.Printf("Email %d to %s failed %d times, giving up: %v", e.ID, e.To, e.Attempts, err)
+	default:
+		e.LastError = err.Error()
+		e.NextAttemptAt = now.Add(Backoff(e.Attempts, w.Backoff, w.MaxBackoff))
+		w.ErrorLog.Printf("Email %d to %s failed, trying again at %s: %v", e.ID, e.To, e.NextAttemptAt.Format(time.RFC3339), err)
+	}
+	if err := w.Store.UpdateEmailDelivery(e); err != nil {
+		w.ErrorLog.Printf("Cannot record the delivery of email %d: %v", e.ID, err)
+	}
+	return e.Status == models.EmailSent
+}
+
+// Backoff returns how long to wait after an email has failed attempts times: base after the first
+// failure, doubling after every other one, and never more than max
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
@@ -0,0 +1,154 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+var now = time.Date(2023, 11, 15, 12, 0, 0, 0, time.UTC)
+
+// memoryStore keeps the outbox in memory
+type memoryStore struct {
+	emails  map[int]models.Email
+	claims  int
+	failing bool
+}
+
+func (s *memoryStore) ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.Email, error) {
+	s.claims++
+	if s.failing {
+		return nil, errors.New("database is down")
+	}
+	var due []models.Email
+	for id := 1; id <= len(s.emails) && len(due) < limit; id++ {
+		e := s.emails[id]
+		if e.Status == models.EmailQueued && !e.NextAttemptAt.After(now) {
+			due = append(due, e)
+			e.NextAttemptAt = now.Add(lease)
+			s.emails[id] = e
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryStore) UpdateEmailDelivery(e models.Email) error {
+	s.emails[e.ID] = e
+	return nil
+}
+
+func newStore(n int) *memoryStore {
+	s := &memoryStore{emails: make(map[int]models.Email)}
+	for id := 1; id <= n; id++ {
+		s.emails[id] = models.Email{ID: id, MailData: models.MailData{To: "guest@example.com"}, Status: models.EmailQueued, NextAttemptAt: now}
+	}
+	return s
+}
+
+func newWorker(store Store, send func(models.MailData) error) *Worker {
+	w := New(store, send, 3, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	w.BatchSize = 2
+	w.Now = func() time.Time { return now }
+	return w
+}
+
+func TestDeliverDueSendsEveryBatch(t *testing.T) {
+	store := newStore(5)
+	sent := 0
+	w := newWorker(store, func(models.MailData) error {
+		sent++
+		return nil
+	})
+
+	if n := w.DeliverDue(); n != 5 || sent != 5 {
+		t.Fatalf("expected 5 emails sent, got %d (%d sends)", n, sent)
+	}
+	for id, e := range store.emails {
+		if e.Status != models.EmailSent || e.Attempts != 1 || !e.SentAt.Equal(now) {
+			t.Errorf("email %d: expected it sent on the first attempt, got %+v", id, e)
+		}
+	}
+	if n := w.DeliverDue(); n != 0 {
+		t.Errorf("expected nothing left to send, sent %d", n)
+	}
+}
+
+func TestDeliverDueRetriesThenFails(t *testing.T) {
+	store := newStore(1)
+	w := newWorker(store, func(models.MailData) error { return errors.New("connection refused") })
+	clock := now
+	w.Now = func() time.Time { return clock }
+
+	expected := []struct {
+		status string
+		wait   time.Duration
+	}{
+		{models.EmailQueued, time.Minute},
+		{models.EmailQueued, 2 * time.Minute},
+		{models.EmailFailed, 0},
+	}
+	for i, exp := range expected {
+		before := store.emails[1].NextAttemptAt
+		w.DeliverDue()
+		e := store.emails[1]
+		next := clock.Add(exp.wait)
+		if exp.wait == 0 {
+			// given up on, so not due again
+			next = before
+		}
+		if e.Attempts != i+1 || e.Status != exp.status || !e.NextAttemptAt.Equal(next) || e.LastError != "connection refused" {
+			t.Errorf("attempt %d: expected %s due at %s, got %+v", i+1, exp.status, next, e)
+		}
+		clock = clock.Add(time.Hour)
+	}
+	w.DeliverDue()
+	if e := store.emails[1]; e.Attempts != 3 {
+		t.Errorf("expected a failed email not to be tried again, got %d attempts", e.Attempts)
+	}
+}
+
+func TestDeliverDueStoreDown(t *testing.T) {
+	store := newStore(1)
+	store.failing = true
+	w := newWorker(store, func(models.MailData) error {
+		t.Error("expected nothing to be sent")
+		return nil
+	})
+	if n := w.DeliverDue(); n != 0 || store.claims != 1 {
+		t.Errorf("expected one failed claim and nothing sent, got %d sent after %d claims", n, store.claims)
+	}
+}
+
+func TestRunDeliversBeforeStopping(t *testing.T) {
+	store := newStore(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := newWorker(store, func(models.MailData) error { return nil })
+	w.Run(ctx, time.Hour)
+	if store.claims < 2 {
+		t.Errorf("expected a delivery on start and one before stopping, got %d claims", store.claims)
+	}
+	if store.emails[1].Status != models.EmailSent {
+		t.Errorf("expected the queued email to be sent, got %+v", store.emails[1])
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		9:  256 * time.Minute,
+		10: 6 * time.Hour,
+		50: 6 * time.Hour,
+	} {
+		if got := Backoff(attempts, time.Minute, 6*time.Hour); got != expected {
+			t.Errorf("after %d attempts: expected %s, got %s", attempts, expected, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Card tokens understood by the fake provider
+const (
+	FakeTokenOK       = "tok_visa"
+	FakeTokenDeclined = "tok_declined"
+)
+
+// FakeSignatureHeader carries the hex HMAC-SHA256 of a fake webhook body
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Payment states kept by the fake provider
+const (
+	FakeAuthorized = "authorized"
+	FakeCaptured   = "captured"
+	FakeRefunded   = "refunded"
+)
+
+type fakePayment struct {
+	state    string
+	amount   int
+	captured int
+	refunded int
+}
+
+// Fake is an in-memory payment provider for development and tests. Every token except
+// FakeTokenDeclined is accepted, and payment ids are "fake_" followed by the charge reference,
+// so tests know the id of a payment before it is made.
+type Fake struct {
+	secret   []byte
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	// authorized holds the authorization made for each idempotency key
+	authorized map[string]Authorization
+}
+
+// NewFake returns a fake provider that signs webhooks with secret
+func NewFake(secret []byte) *Fake {
+	return &Fake{
+		secret:     secret,
+		payments:   make(map[string]*fakePayment),
+		authorized: make(map[string]Authorization),
+	}
+}
+
+// Authorize holds the amount unless the token is FakeTokenDeclined. A charge repeating the
+// idempotency key of an earlier one gets its authorization back and holds nothing more.
+func (f *Fake) Authorize(c Charge) (Authorization, error) {
+	if c.Token == "" || c.Token == FakeTokenDeclined {
+		return Authorization{}, ErrDeclined
+	}
+	if c.Amount <= 0 {
+		return Authorization{}, errors.New("amount must be positive")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if auth, ok := f.authorized[c.IdempotencyKey]; ok && c.IdempotencyKey != "" {
+		return auth, nil
+	}
+	id := "fake_" + c.Reference
+	if p, ok := f.payments[id]; ok && p.state != FakeAuthorized {
+		return Authorization{}, ErrInvalidState
+	}
+	f.payments[id] = &fakePayment{state: FakeAuthorized, amount: c.Amount}
+	auth := Authorization{ID: id, Amount: c.Amount}
+	if c.IdempotencyKey != "" {
+		f.authorized[c.IdempotencyKey] = auth
+	}
+	return auth, nil
+}
+
+// Capture takes up to the authorized amount
+func (f *Fake) Capture(paymentID string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if p.state != FakeAuthorized || amount <= 0 || amount > p.amount {
+		return ErrInvalidState
+	}
+	p.state = FakeCaptured
+	p.captured = amount
+	return nil
+}
+
+// Refund returns up to the captured amount, less anything refunded already
+func (f *Fake) Refund(paymentID string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if p.state == FakeAuthorized || amount <= 0 || p.refunded+amount > p.captured {
+		return ErrInvalidState
+	}
+	p.refunded += amount
+	if p.refunded == p.captured {
+		p.state = FakeRefunded
+	}
+	return nil
+}
+
+// State returns the state of a payment, or "" if the fake does not know it
+func (f *Fake) State(paymentID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.payments[paymentID]; ok {
+		return p.state
+	}
+	return ""
+}
+
+// VerifyWebhook checks FakeSignatureHeader and decodes a JSON event such as
+// {"type": "payment.refunded", "payment_id": "fake_ABC", "amount": 2400}
+func (f *Fake) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var e Event
+	sig, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(sig, f.sign(payload)) {
+		return e, ErrInvalidSignature
+	}
+	var body struct {
+		Type      string `json:"type"`
+		PaymentID string `json:"payment_id"`
+		Amount    int    `json:"amount"`
+	}
+	err = json.Unmarshal(payload, &body)
+	if err != nil {
+		return e, err
+	}
+	return Event{Type: body.Type, PaymentID: body.PaymentID, Amount: body.Amount}, nil
+}
+
+// SignWebhook returns the FakeSignatureHeader value for payload
+func (f *Fake) SignWebhook(payload []byte) string {
+	return hex.EncodeToString(f.sign(payload))
+}
+
+func (f *Fake) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,102 @@
+package payments
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFakePaymentLifecycle(t *testing.T) {
+	f := NewFake([]byte("secret"))
+
+	_, err := f.Authorize(Charge{Amount: 2400, Token: FakeTokenDeclined, Reference: "ABC"})
+	if err != ErrDeclined {
+		t.Errorf("expected ErrDeclined, got %v", err)
+	}
+
+	auth, err := f.Authorize(Charge{Amount: 2400, Token: FakeTokenOK, Reference: "ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.ID != "fake_ABC" {
+		t.Errorf("expected payment id fake_ABC, got %s", auth.ID)
+	}
+	if err := f.Refund(auth.ID, 2400); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState refunding an uncaptured payment, got %v", err)
+	}
+	if err := f.Capture(auth.ID, 3000); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState capturing more than authorized, got %v", err)
+	}
+	if err := f.Capture(auth.ID, 2400); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Capture(auth.ID, 2400); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState capturing twice, got %v", err)
+	}
+	if err := f.Refund(auth.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if f.State(auth.ID) != FakeCaptured {
+		t.Errorf("expected a partly refunded payment to stay captured, got %s", f.State(auth.ID))
+	}
+	if err := f.Refund(auth.ID, 1400); err != nil {
+		t.Fatal(err)
+	}
+	if f.State(auth.ID) != FakeRefunded {
+		t.Errorf("expected the payment to be refunded, got %s", f.State(auth.ID))
+	}
+	if err := f.Refund(auth.ID, 1); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState refunding more than captured, got %v", err)
+	}
+	if err := f.Capture("fake_nope", 1); err != ErrUnknownPayment {
+		t.Errorf("expected ErrUnknownPayment, got %v", err)
+	}
+}
+
+func TestFakeIdempotentAuthorize(t *testing.T) {
+	f := NewFake([]byte("secret"))
+	c := Charge{Amount: 2400, Token: FakeTokenOK, Reference: "ABC", IdempotencyKey: "ABC"}
+
+	first, err := f.Authorize(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Capture(first.ID, 2400); err != nil {
+		t.Fatal(err)
+	}
+	again, err := f.Authorize(c)
+	if err != nil || again != first {
+		t.Errorf("expected the repeated charge to get %+v back, got %+v, %v", first, again, err)
+	}
+	if f.State(first.ID) != FakeCaptured {
+		t.Errorf("expected the repeated charge to leave the payment captured, got %s", f.State(first.ID))
+	}
+	c.IdempotencyKey = ""
+	if _, err := f.Authorize(c); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState charging a captured payment again without the key, got %v", err)
+	}
+}
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	f := NewFake([]byte("secret"))
+	payload := []byte(`{"type":"payment.refunded","payment_id":"fake_ABC","amount":2400}`)
+
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, f.SignWebhook(payload))
+	e, err := f.VerifyWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventRefunded || e.PaymentID != "fake_ABC" || e.Amount != 2400 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	other := NewFake([]byte("other"))
+	header.Set(FakeSignatureHeader, other.SignWebhook(payload))
+	if _, err := f.VerifyWebhook(payload, header); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for another secret, got %v", err)
+	}
+	header.Del(FakeSignatureHeader)
+	if _, err := f.VerifyWebhook(payload, header); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature without a signature, got %v", err)
+	}
+}
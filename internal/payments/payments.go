@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrDeclined is returned when the card issuer refuses a charge
+var ErrDeclined = errors.New("payment declined")
+
+// ErrUnknownPayment is returned for a payment id the provider does not know
+var ErrUnknownPayment = errors.New("unknown payment")
+
+// ErrInvalidState is returned when a payment cannot move to the requested state, such as
+// capturing it twice or refunding more than was captured
+var ErrInvalidState = errors.New("payment is not in a state that allows this")
+
+// ErrInvalidSignature is returned when a webhook does not carry a valid signature
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Webhook event types
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+// Charge describes an amount to take from a card
+type Charge struct {
+	// Amount is in cents
+	Amount int
+	// Token identifies the card; it comes from the provider's checkout form so card
+	// numbers never reach the server
+	Token string
+	// Reference ties the payment to our records, usually the reservation's confirmation code
+	Reference   string
+	Description string
+	// IdempotencyKey makes the provider answer a repeat of the charge with the result of the
+	// first one instead of charging the card again
+	IdempotencyKey string
+}
+
+// Authorization is a hold on the guest's card that can be captured later
+type Authorization struct {
+	ID     string
+	Amount int
+}
+
+// Event is a verified notification from the provider about a payment
+type Event struct {
+	Type      string
+	PaymentID string
+	Amount    int
+}
+
+// Gateway is implemented by every payment provider
+type Gateway interface {
+	// Authorize places a hold for the charge on the card, or returns ErrDeclined
+	Authorize(c Charge) (Authorization, error)
+	// Capture takes up to the authorized amount
+	Capture(paymentID string, amount int) error
+	// Refund returns up to the captured amount to the card
+	Refund(paymentID string, amount int) error
+	// VerifyWebhook checks the signature of a webhook request and decodes its event
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
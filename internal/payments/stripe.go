@@ -0,0 +1,227 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader carries the timestamp and signatures of a Stripe webhook
+const StripeSignatureHeader = "Stripe-Signature"
+
+// stripeWebhookTolerance is how old a webhook may be before it is refused as a replay
+const stripeWebhookTolerance = 5 * time.Minute
+
+// Stripe takes payments through the Stripe API. A charge is a PaymentIntent confirmed with the
+// PaymentMethod the checkout form created, and captured manually; the token of a charge is the
+// PaymentMethod's id.
+type Stripe struct {
+	// SecretKey authenticates the API calls
+	SecretKey string
+	// WebhookSecret is the signing secret of the webhook endpoint
+	WebhookSecret string
+	// Currency is the ISO code amounts are charged in, e.g. usd
+	Currency string
+	// BaseURL is the API's address
+	BaseURL string
+	Client  *http.Client
+	// Now returns the current time, to check the age of webhooks
+	Now func() time.Time
+}
+
+// NewStripe returns a gateway calling the Stripe API with a 30 second timeout
+func NewStripe(secretKey, webhookSecret, currency string) *Stripe {
+	return &Stripe{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		Currency:      currency,
+		BaseURL:       "https://api.stripe.com",
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		Now:           time.Now,
+	}
+}
+
+// stripeError is the error object of a failed API call
+type stripeError struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// stripeIntent is the part of a PaymentIntent the gateway reads
+type stripeIntent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount int    `json:"amount"`
+}
+
+// Authorize confirms a PaymentIntent for the charge that holds the amount on the card. A card
+// that needs the guest to authenticate cannot be confirmed here and is refused as declined.
+func (s *Stripe) Authorize(c Charge) (Authorization, error) {
+	if c.Token == "" {
+		return Authorization{}, ErrDeclined
+	}
+	var pi stripeIntent
+	err := s.postOnce("/v1/payment_intents", c.IdempotencyKey, url.Values{
+		"amount":                 {strconv.Itoa(c.Amount)},
+		"currency":               {s.Currency},
+		"payment_method":         {c.Token},
+		"payment_method_types[]": {"card"},
+		"capture_method":         {"manual"},
+		"confirm":                {"true"},
+		"description":            {c.Description},
+		"metadata[reference]":    {c.Reference},
+	}, &pi)
+	if err != nil {
+		return Authorization{}, err
+	}
+	if pi.Status != "requires_capture" {
+		// let go of anything the card issuer is still holding
+		s.post("/v1/payment_intents/"+url.PathEscape(pi.ID)+"/cancel", url.Values{}, nil)
+		return Authorization{}, fmt.Errorf("%w: the card needs authentication", ErrDeclined)
+	}
+	return Authorization{ID: pi.ID, Amount: pi.Amount}, nil
+}
+
+// Capture takes up to the authorized amount
+func (s *Stripe) Capture(paymentID string, amount int) error {
+	return s.post("/v1/payment_intents/"+url.PathEscape(paymentID)+"/capture", url.Values{
+		"amount_to_capture": {strconv.Itoa(amount)},
+	}, nil)
+}
+
+// Refund returns up to the captured amount to the card
+func (s *Stripe) Refund(paymentID string, amount int) error {
+	return s.post("/v1/refunds", url.Values{
+		"payment_intent": {paymentID},
+		"amount":         {strconv.Itoa(amount)},
+	}, nil)
+}
+
+// post calls the API and decodes a successful response into v, if it is not nil
+func (s *Stripe) post(path string, form url.Values, v interface{}) error {
+	return s.postOnce(path, "", form, v)
+}
+
+// postOnce is post with an idempotency key, if it is not empty, so Stripe answers a repeated
+// request with the response to the first one
+func (s *Stripe) postOnce(path, idempotencyKey string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error stripeError `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return fmt.Errorf("stripe: unexpected status %s", resp.Status)
+		}
+		e := body.Error
+		switch {
+		case e.Type == "card_error":
+			return fmt.Errorf("%w: %s", ErrDeclined, e.Message)
+		case resp.StatusCode == http.StatusNotFound || e.Code == "resource_missing":
+			return ErrUnknownPayment
+		case e.Code == "payment_intent_unexpected_state" || e.Code == "charge_already_refunded" || e.Code == "amount_too_large":
+			return ErrInvalidState
+		}
+		return fmt.Errorf("stripe: %s", e.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// VerifyWebhook checks StripeSignatureHeader and decodes the events the app acts on. Other
+// events are returned with their Stripe type, which matches none of the Event types.
+func (s *Stripe) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var e Event
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header.Get(StripeSignatureHeader), ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || s.Now().Sub(time.Unix(t, 0)).Abs() > stripeWebhookTolerance {
+		return e, ErrInvalidSignature
+	}
+	expected := s.sign(timestamp, payload)
+	valid := false
+	for _, sig := range signatures {
+		valid = valid || hmac.Equal(sig, expected)
+	}
+	if !valid {
+		return e, ErrInvalidSignature
+	}
+
+	var body struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID             string `json:"id"`
+				PaymentIntent  string `json:"payment_intent"`
+				AmountReceived int    `json:"amount_received"`
+				AmountRefunded int    `json:"amount_refunded"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(payload, &body)
+	if err != nil {
+		return e, err
+	}
+	object := body.Data.Object
+	switch body.Type {
+	case "payment_intent.succeeded":
+		return Event{Type: EventCaptured, PaymentID: object.ID, Amount: object.AmountReceived}, nil
+	case "payment_intent.payment_failed":
+		return Event{Type: EventFailed, PaymentID: object.ID}, nil
+	case "charge.refunded":
+		return Event{Type: EventRefunded, PaymentID: object.PaymentIntent, Amount: object.AmountRefunded}, nil
+	}
+	if body.Type == "" {
+		return e, errors.New("webhook has no event type")
+	}
+	return Event{Type: body.Type}, nil
+}
+
+// SignWebhook returns the StripeSignatureHeader value for payload sent at t
+func (s *Stripe) SignWebhook(payload []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(s.sign(timestamp, payload))
+}
+
+func (s *Stripe) sign(timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
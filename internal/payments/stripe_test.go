@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// stripeServer answers the API calls made by the gateway and records their forms by path, with
+// the Idempotency-Key header of the request as the form's idempotency_key
+func stripeServer(t *testing.T, forms map[string]url.Values) *Stripe {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error": {"type": "invalid_request_error", "message": "Invalid API Key"}}`)
+			return
+		}
+		r.ParseForm()
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			r.PostForm.Set("idempotency_key", key)
+		}
+		forms[r.URL.Path] = r.PostForm
+		switch {
+		case r.URL.Path == "/v1/payment_intents" && r.PostForm.Get("payment_method") == "pm_card_visa":
+			io.WriteString(w, `{"id": "pi_1", "status": "requires_capture", "amount": 4800}`)
+		case r.URL.Path == "/v1/payment_intents" && r.PostForm.Get("payment_method") == "pm_card_authenticationRequired":
+			io.WriteString(w, `{"id": "pi_2", "status": "requires_action", "amount": 4800}`)
+		case r.URL.Path == "/v1/payment_intents":
+			w.WriteHeader(http.StatusPaymentRequired)
+			io.WriteString(w, `{"error": {"type": "card_error", "code": "card_declined", "message": "Your card was declined."}}`)
+		case r.URL.Path == "/v1/payment_intents/pi_1/capture", r.URL.Path == "/v1/payment_intents/pi_2/cancel":
+			io.WriteString(w, `{"id": "pi_1", "status": "succeeded"}`)
+		case r.URL.Path == "/v1/refunds" && r.PostForm.Get("payment_intent") == "pi_1":
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"type": "invalid_request_error", "code": "charge_already_refunded", "message": "Charge has already been refunded."}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error": {"type": "invalid_request_error", "code": "resource_missing", "message": "No such payment_intent"}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	s := NewStripe("sk_test", "whsec_test", "usd")
+	s.BaseURL = srv.URL
+	return s
+}
+
+func TestStripePayments(t *testing.T) {
+	forms := make(map[string]url.Values)
+	s := stripeServer(t, forms)
+
+	auth, err := s.Authorize(Charge{Amount: 4800, Token: "pm_card_visa", Reference: "ABCD2345", IdempotencyKey: "ABCD2345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.ID != "pi_1" || auth.Amount != 4800 {
+		t.Errorf("unexpected authorization %+v", auth)
+	}
+	sent := forms["/v1/payment_intents"]
+	if sent.Get("capture_method") != "manual" || sent.Get("currency") != "usd" || sent.Get("metadata[reference]") != "ABCD2345" ||
+		sent.Get("idempotency_key") != "ABCD2345" {
+		t.Errorf("unexpected payment intent %v", sent)
+	}
+	if err := s.Capture(auth.ID, 4800); err != nil {
+		t.Error(err)
+	}
+	if forms["/v1/payment_intents/pi_1/capture"].Get("amount_to_capture") != "4800" {
+		t.Errorf("unexpected capture %v", forms["/v1/payment_intents/pi_1/capture"])
+	}
+
+	_, err = s.Authorize(Charge{Amount: 4800, Token: "pm_card_chargeDeclined"})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined, got %v", err)
+	}
+	_, err = s.Authorize(Charge{Amount: 4800, Token: "pm_card_authenticationRequired"})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined for a card needing authentication, got %v", err)
+	}
+	if _, ok := forms["/v1/payment_intents/pi_2/cancel"]; !ok {
+		t.Error("expected the payment needing authentication to be cancelled")
+	}
+
+	if err := s.Refund("pi_1", 4800); err != ErrInvalidState {
+		t.Errorf("expected ErrInvalidState refunding twice, got %v", err)
+	}
+	if err := s.Capture("pi_unknown", 4800); err != ErrUnknownPayment {
+		t.Errorf("expected ErrUnknownPayment, got %v", err)
+	}
+
+	s.SecretKey = "sk_wrong"
+	if err := s.Capture("pi_1", 4800); err == nil || errors.Is(err, ErrDeclined) {
+		t.Errorf("expected an API error, got %v", err)
+	}
+}
+
+func TestStripeWebhook(t *testing.T) {
+	now := time.Date(2050, 6, 10, 9, 0, 0, 0, time.UTC)
+	s := NewStripe("sk_test", "whsec_test", "usd")
+	s.Now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		payload string
+		sign    func(payload []byte) string
+		exp     Event
+		expErr  error
+	}{
+		{"captured", `{"type": "payment_intent.succeeded", "data": {"object": {"id": "pi_1", "amount_received": 4800}}}`,
+			func(p []byte) string { return s.SignWebhook(p, now) }, Event{Type: EventCaptured, PaymentID: "pi_1", Amount: 4800}, nil},
+		{"refunded", `{"type": "charge.refunded", "data": {"object": {"id": "ch_1", "payment_intent": "pi_1", "amount_refunded": 4800}}}`,
+			func(p []byte) string { return s.SignWebhook(p, now.Add(-time.Minute)) }, Event{Type: EventRefunded, PaymentID: "pi_1", Amount: 4800}, nil},
+		{"failed", `{"type": "payment_intent.payment_failed", "data": {"object": {"id": "pi_1"}}}`,
+			func(p []byte) string { return s.SignWebhook(p, now) }, Event{Type: EventFailed, PaymentID: "pi_1"}, nil},
+		{"other_event", `{"type": "customer.created", "data": {"object": {"id": "cus_1"}}}`,
+			func(p []byte) string { return s.SignWebhook(p, now) }, Event{Type: "customer.created"}, nil},
+		{"replayed", `{"type": "charge.refunded", "data": {"object": {"payment_intent": "pi_1"}}}`,
+			func(p []byte) string { return s.SignWebhook(p, now.Add(-time.Hour)) }, Event{}, ErrInvalidSignature},
+		{"wrong_secret", `{"type": "charge.refunded", "data": {"object": {"payment_intent": "pi_1"}}}`,
+			func(p []byte) string {
+				other := NewStripe("sk_test", "whsec_other", "usd")
+				return other.SignWebhook(p, now)
+			}, Event{}, ErrInvalidSignature},
+		{"unsigned", `{"type": "charge.refunded", "data": {"object": {"payment_intent": "pi_1"}}}`,
+			func(p []byte) string { return "" }, Event{}, ErrInvalidSignature},
+	}
+	for _, e := range tests {
+		header := http.Header{}
+		header.Set(StripeSignatureHeader, e.sign([]byte(e.payload)))
+		event, err := s.VerifyWebhook([]byte(e.payload), header)
+		if err != e.expErr {
+			t.Errorf("Failed %s: expected error %v, got %v", e.name, e.expErr, err)
+		}
+		if event != e.exp {
+			t.Errorf("Failed %s: expected event %+v, got %+v", e.name, e.exp, event)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// ErrInvalidDates is returned when the departure is not after the arrival
+var ErrInvalidDates = errors.New("departure must be after arrival")
+
+// MinStayError is returned when a stay is shorter than the minimum stay for its arrival date
+type MinStayError struct {
+	MinNights int
+}
+
+func (e MinStayError) Error() string {
+	return fmt.Sprintf("the minimum stay for these dates is %d nights", e.MinNights)
+}
+
+// Night is the price of a single night of a stay
+type Night struct {
+	Date time.Time
+	// Rate is the nightly rate in cents
+	Rate int
+	// RateName names the rate override that applied, "" for the room's base rate
+	RateName string
+	// Surcharge is the weekend surcharge in cents
+	Surcharge int
+}
+
+// Price returns the total price of the night in cents
+func (n Night) Price() int {
+	return n.Rate + n.Surcharge
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	Nights []Night
+	// Total is the price of the whole stay in cents
+	Total int
+}
+
+// NewQuote prices a stay in room from start to end. Each night uses the room's base rate unless a
+// rate override covers it; date-range overrides win over yearly seasons, and shorter overrides over
+// longer ones. Friday and Saturday nights add the room's weekend surcharge. The minimum stay is
+// taken from the override that applies to the arrival night, or from the room.
+func NewQuote(room models.Room, rates []models.RoomRate, start, end time.Time) (Quote, error) {
+	var q Quote
+	start = day(start)
+	end = day(end)
+	if !end.After(start) {
+		return q, ErrInvalidDates
+	}
+
+	minNights := room.MinNights
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		n := Night{Date: d, Rate: room.BaseRate}
+		if rate, ok := rateFor(rates, d); ok {
+			n.RateName = rate.Name
+			if rate.NightlyRate > 0 {
+				n.Rate = rate.NightlyRate
+			}
+			if d.Equal(start) && rate.MinNights > 0 {
+				minNights = rate.MinNights
+			}
+		}
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			n.Surcharge = room.WeekendSurcharge
+		}
+		q.Nights = append(q.Nights, n)
+		q.Total += n.Price()
+	}
+
+	if len(q.Nights) < minNights {
+		return q, MinStayError{MinNights: minNights}
+	}
+	return q, nil
+}
+
+// rateFor returns the rate override that applies to the night starting on d, if any
+func rateFor(rates []models.RoomRate, d time.Time) (models.RoomRate, bool) {
+	var best models.RoomRate
+	found := false
+	for _, r := range rates {
+		if !covers(r, d) {
+			continue
+		}
+		if !found || beats(r, best) {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+// beats reports whether rate a takes precedence over rate b
+func beats(a, b models.RoomRate) bool {
+	if a.Yearly != b.Yearly {
+		return !a.Yearly
+	}
+	if span(a) != span(b) {
+		return span(a) < span(b)
+	}
+	return a.ID > b.ID
+}
+
+// covers reports whether the rate applies to the night starting on d. Both ends are inclusive.
+// Yearly rates compare month and day only, and may wrap around the new year.
+func covers(r models.RoomRate, d time.Time) bool {
+	if !r.Yearly {
+		return !d.Before(day(r.StartDate)) && !d.After(day(r.EndDate))
+	}
+	md := monthDay(d)
+	from, to := monthDay(r.StartDate), monthDay(r.EndDate)
+	if from <= to {
+		return md >= from && md <= to
+	}
+	return md >= from || md <= to
+}
+
+// span returns the number of nights a rate covers in one year
+func span(r models.RoomRate) int {
+	nights := int(day(r.EndDate).Sub(day(r.StartDate)).Hours()/24) + 1
+	if r.Yearly && nights <= 0 {
+		nights += 365
+	}
+	return nights
+}
+
+func monthDay(t time.Time) int {
+	return int(t.Month())*100 + t.Day()
+}
+
+// day drops the time of day, so nights are counted the same way whatever time t carries
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
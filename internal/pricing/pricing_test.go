@@ -0,0 +1,80 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{ID: 1, BaseRate: 10000, WeekendSurcharge: 2500, MinNights: 1}
+
+var rates = []models.RoomRate{
+	{ID: 1, Name: "Summer", StartDate: date("2000-06-01"), EndDate: date("2000-08-31"), NightlyRate: 15000, MinNights: 3, Yearly: true},
+	{ID: 2, Name: "Holidays", StartDate: date("2000-12-20"), EndDate: date("2001-01-05"), NightlyRate: 20000, Yearly: true},
+	{ID: 3, Name: "Festival", StartDate: date("2050-07-10"), EndDate: date("2050-07-12"), NightlyRate: 30000},
+	{ID: 4, Name: "Long weekend", StartDate: date("2050-10-01"), EndDate: date("2050-10-31"), MinNights: 2},
+}
+
+func TestNewQuote(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		expNights int
+		expTotal  int
+	}{
+		// 2050-03-07 is a Monday
+		{"weekdays", "2050-03-07", "2050-03-09", 2, 20000},
+		{"weekend", "2050-03-11", "2050-03-13", 2, 25000},
+		{"season", "2050-06-06", "2050-06-09", 3, 45000},
+		{"into_season", "2050-05-30", "2050-06-02", 3, 35000},
+		{"override_beats_season", "2050-07-09", "2050-07-13", 4, 15000 + 2500 + 3*30000},
+		{"season_over_new_year", "2050-12-31", "2051-01-02", 2, 40000 + 2500},
+		{"min_nights_without_rate", "2050-10-03", "2050-10-05", 2, 20000},
+	}
+
+	for _, e := range tests {
+		q, err := NewQuote(room, rates, date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("Failed %s: unexpected error %v", e.name, err)
+			continue
+		}
+		if len(q.Nights) != e.expNights {
+			t.Errorf("Failed %s: expected %d nights, got %d", e.name, e.expNights, len(q.Nights))
+		}
+		if q.Total != e.expTotal {
+			t.Errorf("Failed %s: expected total %d, got %d", e.name, e.expTotal, q.Total)
+		}
+	}
+}
+
+func TestNewQuoteMinStay(t *testing.T) {
+	_, err := NewQuote(room, rates, date("2050-06-06"), date("2050-06-08"))
+	var minStay MinStayError
+	if !errors.As(err, &minStay) || minStay.MinNights != 3 {
+		t.Errorf("expected a minimum stay of 3 nights, got %v", err)
+	}
+
+	_, err = NewQuote(room, rates, date("2050-10-03"), date("2050-10-04"))
+	if !errors.As(err, &minStay) || minStay.MinNights != 2 {
+		t.Errorf("expected a minimum stay of 2 nights, got %v", err)
+	}
+
+	// arriving before the season starts uses the room's minimum stay
+	if _, err = NewQuote(room, rates, date("2050-05-31"), date("2050-06-01")); err != nil {
+		t.Errorf("expected no minimum stay error, got %v", err)
+	}
+}
+
+func TestNewQuoteInvalidDates(t *testing.T) {
+	if _, err := NewQuote(room, rates, date("2050-03-07"), date("2050-03-07")); err != ErrInvalidDates {
+		t.Errorf("expected ErrInvalidDates, got %v", err)
+	}
+}
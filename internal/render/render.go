@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/config"
+	"github.com/Ed-cred/bookings/internal/helpers"
 	"github.com/Ed-cred/bookings/internal/models"
 	"github.com/justinas/nosurf"
 )
@@ -18,6 +19,8 @@ var functions =template.FuncMap{
 	"humanDate": HumanDate,
 	"formatDate": FormatDate,
 	"iterate": IterateDays,
+	"formatMoney": FormatMoney,
+	"statusLabel": models.StatusLabel,
 }
 var app *config.AppConfig
 var pathToTemplate = "./templates"
@@ -36,6 +39,11 @@ func HumanDate(t time.Time) string {
 func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
+// FormatMoney formats an amount in cents as dollars, e.g. 12050 as $120.50
+func FormatMoney(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
 func Add (a, b int) int {
 	return a + b
 } 
@@ -59,6 +67,9 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if u, ok := helpers.AuthUser(r); ok {
+		td.User = u
+	}
 	return td
 }
 
@@ -2,16 +2,117 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDbRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user, active users first
+func (m *postgresDbRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var users []models.User
+	query := `SELECT id, first_name, last_name, email, access_level, active, created_at, updated_at
+	FROM users ORDER BY active DESC, last_name, first_name`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.Active,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// InsertUser creates an active user with a bcrypt hash of password
+func (m *postgresDbRepo) InsertUser(u models.User, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+	var newID int
+	query := `INSERT INTO users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, true, $6, $7) RETURNING id`
+	err = m.DB.QueryRowContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		string(hash),
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateEmail
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdatePassword replaces a user's password with a bcrypt hash of password
+func (m *postgresDbRepo) UpdatePassword(id int, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`
+	_, err = m.DB.ExecContext(ctx, query, string(hash), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetUserActive activates or deactivates a user. Deactivated users cannot log in.
+func (m *postgresDbRepo) SetUserActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	query := `UPDATE users SET active = $1, updated_at = $2 WHERE id = $3`
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err was caused by a unique index, e.g. on users.email
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
 }
 
 func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error) {
@@ -19,10 +120,16 @@ func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error)
 	defer cancel()
 	var newID int
 
-	stmt := `insert into reservations (first_name, last_name, email,  phone, start_date, end_date, room_id, created_at, updated_at) 
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	guestID, err := linkGuest(ctx, m.DB, res)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into reservations (first_name, last_name, email,  phone, start_date, end_date, room_id, created_at, updated_at, confirmation_code, total_price,
+			payment_status, guest_id) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 			returning id`
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -32,6 +139,10 @@ func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error)
 		res.RoomID,
 		time.Now(),
 		time.Now(),
+		res.ConfirmationCode,
+		res.TotalPrice,
+		res.PaymentStatus,
+		guestID,
 	).Scan(&newID)
 	if err != nil {
 		log.Printf("Error inserting reservation data into database: %v", err)
@@ -44,8 +155,9 @@ func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error)
 func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7)`
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at,
+			feed_id, external_uid)
+			values ($1, $2, $3, nullif($4, 0), $5, $6, $7, nullif($8, 0), $9)`
 
 	_, err := m.DB.ExecContext(ctx, stmt,
 		r.StartDate,
@@ -55,6 +167,8 @@ func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		r.RestrictionID,
 		time.Now(),
 		time.Now(),
+		r.FeedID,
+		r.ExternalUID,
 	)
 	if err != nil {
 		log.Println("Unable to insert data into room_restrictions table: ", err)
@@ -63,72 +177,375 @@ func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
-// Returns true if the date range is available for specified roomID,otherwise false
+// BookReservation inserts a reservation and its room restriction in a single transaction.
+// The room row is locked while availability is re-checked, so two concurrent bookings for
+// overlapping dates cannot both succeed; the loser gets repository.ErrRoomUnavailable.
+// The emails about the booking are queued in the same transaction, so they are sent exactly
+// when the booking is made.
+func (m *postgresDbRepo) BookReservation(res models.Reservation, emails []models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 AND active FOR UPDATE`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions 
+			where room_id = $1 and $2 < end_date and $3 > start_date and ` + notDeletedRestriction
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+	closed, err := recurringBlockClash(ctx, tx, res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return 0, err
+	}
+	if closed {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	guestID, err := linkGuest(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt := `insert into reservations (first_name, last_name, email,  phone, start_date, end_date, room_id, created_at, updated_at, confirmation_code, total_price,
+			payment_status, guest_id) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		time.Now(),
+		res.ConfirmationCode,
+		res.TotalPrice,
+		res.PaymentStatus,
+		guestID,
+	).Scan(&newID)
+	if err != nil {
+		log.Printf("Error inserting reservation data into database: %v", err)
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		1,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		log.Println("Unable to insert data into room_restrictions table: ", err)
+		return 0, err
+	}
+
+	for _, e := range emails {
+		err = queueEmail(ctx, tx, e)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// Returns true if the date range is available for specified roomID,otherwise false.
+// Nights closed by a recurring block are unavailable too.
 func (m *postgresDbRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	var numRows int
 	query := `select count(id) from room_restrictions 
-			where room_id = $1 and $2 < end_date and $3 > start_date`
+			where room_id = $1 and $2 < end_date and $3 > start_date and ` + notDeletedRestriction
 	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
 	}
-	if numRows == 0 {
-		return true, nil
+	if numRows > 0 {
+		return false, nil
 	}
-	return false, nil
+	closed, err := recurringBlockClash(ctx, m.DB, roomID, start, end)
+	if err != nil {
+		return false, err
+	}
+	return !closed, nil
 }
 
+// SearchAvailabilityAllRooms returns the active rooms, each with its cover photo, that are free from
+// start to end, leaving out rooms closed by a recurring block on any of the nights
 func (m *postgresDbRepo) SearchAvailabilityAllRooms(start, end time.Time) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	var rooms []models.Room
-	query := `select r.id, r.room_name from rooms r 
-			where r.id not in (select room_id from room_restrictions rr
-			where $1 < rr.end_date and $2 > rr.start_date);`
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	blocks, err := listRecurringBlocks(ctx, m.DB, ``)
 	if err != nil {
 		return rooms, err
 	}
-	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(&room.ID, &room.RoomName)
-		if err != nil {
-			return rooms, err
+	closed := make(map[int]bool)
+	for _, b := range blocks {
+		if b.Blocks(start, end) {
+			closed[b.RoomID] = true
 		}
-		rooms = append(rooms, room)
 	}
-	if err := rows.Err(); err != nil {
+	free, err := m.listRooms(`WHERE r.active AND r.id NOT IN (SELECT room_id FROM room_restrictions rr
+	WHERE $1 < rr.end_date AND $2 > rr.start_date AND `+notDeletedRestriction+`)`, start, end)
+	if err != nil {
 		return rooms, err
 	}
+	for _, room := range free {
+		if !closed[room.ID] {
+			rooms = append(rooms, room)
+		}
+	}
 	return rooms, nil
 }
 
 func (m *postgresDbRepo) GetRoomById(id int) (models.Room, error) {
+	return m.fetchRoom("id = $1", id)
+}
+
+// GetRoomBySlug returns the room with the given slug, including its photos
+func (m *postgresDbRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	return m.fetchRoom("slug = $1", slug)
+}
+
+func (m *postgresDbRepo) fetchRoom(where string, arg interface{}) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 	var room models.Room
-	query := `SELECT id, room_name, created_at, updated_at FROM rooms where id=$1`
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	query := `SELECT id, room_name, slug, description, max_occupancy, base_rate, weekend_surcharge, min_nights, active,
+	created_at, updated_at FROM rooms where ` + where
+	row := m.DB.QueryRowContext(ctx, query, arg)
+	err := row.Scan(&room.ID, &room.RoomName, &room.Slug, &room.Description, &room.MaxOccupancy, &room.BaseRate,
+		&room.WeekendSurcharge, &room.MinNights, &room.Active, &room.CreatedAt, &room.UpdatedAt)
 	if err != nil {
 		return room, err
 	}
 
+	query = `SELECT id, room_id, path, sort_order, created_at, updated_at FROM room_photos
+	WHERE room_id = $1 ORDER BY sort_order, id`
+	rows, err := m.DB.QueryContext(ctx, query, room.ID)
+	if err != nil {
+		return room, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(&p.ID, &p.RoomID, &p.Path, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return room, err
+		}
+		room.Photos = append(room.Photos, p)
+	}
+	if err := rows.Err(); err != nil {
+		return room, err
+	}
 	return room, nil
 }
 
+// InsertRoom adds a room and returns its id
+func (m *postgresDbRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var newID int
+	query := `INSERT INTO rooms (room_name, slug, description, max_occupancy, base_rate, weekend_surcharge, min_nights, active,
+	created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.MaxOccupancy,
+		room.BaseRate,
+		room.WeekendSurcharge,
+		room.MinNights,
+		room.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateSlug
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoom saves the details of a room. Photos are managed separately.
+func (m *postgresDbRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	query := `UPDATE rooms SET room_name = $1, slug = $2, description = $3, max_occupancy = $4, base_rate = $5,
+	weekend_surcharge = $6, min_nights = $7, active = $8, updated_at = $9 WHERE id = $10`
+	_, err := m.DB.ExecContext(ctx, query,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.MaxOccupancy,
+		room.BaseRate,
+		room.WeekendSurcharge,
+		room.MinNights,
+		room.Active,
+		time.Now(),
+		room.ID,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateSlug
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertRoomPhoto adds a photo after the room's existing photos
+func (m *postgresDbRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var newID int
+	query := `INSERT INTO room_photos (room_id, path, sort_order, created_at, updated_at)
+	VALUES ($1, $2, (SELECT coalesce(max(sort_order), 0) + 1 FROM room_photos WHERE room_id = $1), $3, $4)
+	RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query, p.RoomID, p.Path, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+func (m *postgresDbRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM room_photos WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RoomRates returns the rate overrides of a room, yearly seasons first
+func (m *postgresDbRepo) RoomRates(roomID int) ([]models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var rates []models.RoomRate
+	query := `SELECT id, room_id, name, start_date, end_date, nightly_rate, min_nights, yearly, created_at, updated_at
+	FROM room_rates WHERE room_id = $1 ORDER BY yearly DESC, start_date`
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RoomRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyRate,
+			&r.MinNights,
+			&r.Yearly,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+// InsertRoomRate adds a rate override to a room and returns its id
+func (m *postgresDbRepo) InsertRoomRate(r models.RoomRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var newID int
+	query := `INSERT INTO room_rates (room_id, name, start_date, end_date, nightly_rate, min_nights, yearly, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query,
+		r.RoomID,
+		r.Name,
+		r.StartDate,
+		r.EndDate,
+		r.NightlyRate,
+		r.MinNights,
+		r.Yearly,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+func (m *postgresDbRepo) DeleteRoomRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM room_rates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Returns a models.User object containing the information from the database
 func (m *postgresDbRepo) GetUserById(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	query := `SELECT first_name, last_name, email, password, access_level, created_at, updated_at
+	query := `SELECT id, first_name, last_name, email, password, access_level, active, created_at, updated_at
 	FROM users where id = $1`
 	row := m.DB.QueryRowContext(ctx, query, id)
 	var u models.User
-	err := row.Scan(&u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.Active, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// GetUserByEmail returns the user with the given email address
+func (m *postgresDbRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	query := `SELECT id, first_name, last_name, email, password, access_level, active, created_at, updated_at
+	FROM users where email = $1`
+	row := m.DB.QueryRowContext(ctx, query, email)
+	var u models.User
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.Active, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return u, err
 	}
@@ -148,6 +565,9 @@ func (m *postgresDbRepo) UpdateUser(u models.User) error {
 		time.Now(),
 		u.ID,
 	)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateEmail
+	}
 	if err != nil {
 		return err
 	}
@@ -160,8 +580,9 @@ func (m *postgresDbRepo) Authenticate(email, testPassword string) (int, string,
 	defer cancel()
 	var id int
 	var hashPass string
-	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email=$1", email)
-	err := row.Scan(&id, &hashPass)
+	var active bool
+	row := m.DB.QueryRowContext(ctx, "SELECT id, password, active FROM users WHERE email=$1", email)
+	err := row.Scan(&id, &hashPass, &active)
 	if err != nil {
 		return 0, "", err
 	}
@@ -171,6 +592,9 @@ func (m *postgresDbRepo) Authenticate(email, testPassword string) (int, string,
 	} else if err != nil {
 		return 0, "", err
 	}
+	if !active {
+		return 0, "", repository.ErrUserInactive
+	}
 	return id, hashPass, nil
 }
 
@@ -180,8 +604,10 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
 	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-	r.processed, rm.id, rm.room_name FROM reservations r
-	LEFT JOIN rooms rm ON (r.room_id = rm.id) ORDER BY r.start_date DESC`
+	r.status, rm.id, rm.room_name FROM reservations r
+	LEFT JOIN rooms rm ON (r.room_id = rm.id)
+	WHERE r.deleted_at IS NULL
+	ORDER BY r.start_date DESC`
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
 		return reservations, err
@@ -200,7 +626,7 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 			&res.RoomID,
 			&res.CreatedAt,
 			&res.UpdatedAt,
-			&res.Processed,
+			&res.Status,
 			&res.Room.ID,
 			&res.Room.RoomName,
 		)
@@ -216,18 +642,131 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// reservationSorts maps the sort orders of the reservation lists to their columns
+var reservationSorts = map[string][]string{
+	models.SortStartDate: {"r.start_date"},
+	models.SortCreatedAt: {"r.created_at"},
+	models.SortGuestName: {"lower(r.last_name)", "lower(r.first_name)"},
+}
+
+// SearchReservations returns the page of reservations that f selects, with the number of
+// reservations matching it on every page
+func (m *postgresDbRepo) SearchReservations(f models.ReservationFilter) (models.ReservationPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	var reservations []models.Reservation
+	page := models.ReservationPage{Page: f.Page, PerPage: f.PerPage}
 
-	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-	rm.id, rm.room_name FROM reservations r
-	LEFT JOIN rooms rm ON (r.room_id = rm.id)
-	WHERE processed = 0
-	ORDER BY r.start_date DESC`
-	rows, err := m.DB.QueryContext(ctx, query)
-	if err != nil {
+	where := []string{"r.deleted_at IS NULL"}
+	var args []interface{}
+	// arg adds a query argument and returns its placeholder
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Query != "" {
+		p := arg("%" + likeEscaper.Replace(f.Query) + "%")
+		where = append(where, fmt.Sprintf(`(r.first_name || ' ' || r.last_name ILIKE %[1]s OR r.email ILIKE %[1]s
+		OR r.phone ILIKE %[1]s)`, p))
+	}
+	if f.RoomID > 0 {
+		where = append(where, "r.room_id = "+arg(f.RoomID))
+	}
+	if f.Status != "" {
+		where = append(where, "r.status = "+arg(f.Status))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "r.end_date > "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "r.start_date <= "+arg(f.To))
+	}
+	conditions := strings.Join(where, " AND ")
+
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM reservations r WHERE `+conditions, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	columns, ok := reservationSorts[f.Sort]
+	if !ok {
+		columns = reservationSorts[models.SortStartDate]
+	}
+	direction := " ASC"
+	if f.Desc {
+		direction = " DESC"
+	}
+	var order []string
+	for _, c := range append(columns, "r.id") {
+		order = append(order, c+direction)
+	}
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.status, coalesce(r.confirmation_code, ''), r.total_price, r.payment_status, r.amount_paid, rm.id, rm.room_name FROM reservations r
+	LEFT JOIN rooms rm ON (r.room_id = rm.id)
+	WHERE ` + conditions + `
+	ORDER BY ` + strings.Join(order, ", ")
+	if f.PerPage > 0 {
+		query += " LIMIT " + arg(f.PerPage) + " OFFSET " + arg(f.Offset())
+	}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(
+			&res.ID,
+			&res.FirstName,
+			&res.LastName,
+			&res.Email,
+			&res.Phone,
+			&res.StartDate,
+			&res.EndDate,
+			&res.RoomID,
+			&res.CreatedAt,
+			&res.UpdatedAt,
+			&res.Status,
+			&res.ConfirmationCode,
+			&res.TotalPrice,
+			&res.PaymentStatus,
+			&res.AmountPaid,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return page, err
+		}
+		page.Reservations = append(page.Reservations, res)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// guestSearchDocument is the text a guest search matches: the guest's name, email and phone, and
+// the phone's digits on their own so that numbers match however they were typed. It must stay the
+// same as the expression of the reservations_guest_search_idx trigram index, or the index is not used.
+const guestSearchDocument = `lower(r.first_name || ' ' || r.last_name || ' ' || r.email || ' ' || r.phone || ' ' ||
+	regexp_replace(r.phone, '[^0-9]', '', 'g'))`
+
+// SearchGuests returns up to limit reservations, not in the trash, whose guest matches query. Guests
+// containing query come first, then those that only resemble it, such as a misspelt name, each
+// ranked by their trigram similarity to it.
+func (m *postgresDbRepo) SearchGuests(query string, limit int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var reservations []models.Reservation
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	stmt := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.status, rm.id, rm.room_name FROM reservations r
+	LEFT JOIN rooms rm ON (r.room_id = rm.id)
+	WHERE r.deleted_at IS NULL AND (` + guestSearchDocument + ` LIKE $2 OR $1 <% ` + guestSearchDocument + `)
+	ORDER BY ` + guestSearchDocument + ` LIKE $2 DESC, word_similarity($1, ` + guestSearchDocument + `) DESC, r.start_date DESC, r.id DESC
+	LIMIT $3`
+	rows, err := m.DB.QueryContext(ctx, stmt, query, "%"+likeEscaper.Replace(query)+"%", limit)
+	if err != nil {
 		return reservations, err
 	}
 	defer rows.Close()
@@ -244,6 +783,7 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 			&res.RoomID,
 			&res.CreatedAt,
 			&res.UpdatedAt,
+			&res.Status,
 			&res.Room.ID,
 			&res.Room.RoomName,
 		)
@@ -255,19 +795,34 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 	if err = rows.Err(); err != nil {
 		return reservations, err
 	}
-
 	return reservations, nil
 }
 
 func (m *postgresDbRepo) FetchReservationById(id int) (models.Reservation, error) {
+	return m.fetchReservation("r.id = $1", id)
+}
+
+// FetchReservationByCode returns the reservation with the given confirmation code
+func (m *postgresDbRepo) FetchReservationByCode(code string) (models.Reservation, error) {
+	return m.fetchReservation("r.confirmation_code = $1", code)
+}
+
+// FetchReservationByPaymentID returns the reservation paid for by the given provider payment
+func (m *postgresDbRepo) FetchReservationByPaymentID(paymentID string) (models.Reservation, error) {
+	return m.fetchReservation("r.payment_id = $1 AND r.payment_id <> ''", paymentID)
+}
+
+func (m *postgresDbRepo) fetchReservation(where string, arg interface{}) (models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 	var res models.Reservation
+	var cancelledAt sql.NullTime
 	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
-	r.created_at, r.updated_at, r.processed, rm.id, rm.room_name FROM reservations r
+	r.created_at, r.updated_at, r.status, coalesce(r.confirmation_code, ''), r.total_price, r.payment_status, r.payment_id,
+	r.amount_paid, r.cancelled_at, coalesce(r.guest_id, 0), rm.id, rm.room_name FROM reservations r
 	LEFT JOIN rooms rm ON r.room_id = rm.id 
-	WHERE r.id = $1`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	WHERE r.deleted_at IS NULL AND ` + where
+	row := m.DB.QueryRowContext(ctx, query, arg)
 	err := row.Scan(
 		&res.ID,
 		&res.FirstName,
@@ -279,28 +834,247 @@ func (m *postgresDbRepo) FetchReservationById(id int) (models.Reservation, error
 		&res.RoomID,
 		&res.CreatedAt,
 		&res.UpdatedAt,
-		&res.Processed,
+		&res.Status,
+		&res.ConfirmationCode,
+		&res.TotalPrice,
+		&res.PaymentStatus,
+		&res.PaymentID,
+		&res.AmountPaid,
+		&cancelledAt,
+		&res.GuestID,
 		&res.Room.ID,
 		&res.Room.RoomName,
 	)
 	if err != nil {
 		return res, err
 	}
+	res.CancelledAt = cancelledAt.Time
 
 	return res, nil
 }
 
-func (m *postgresDbRepo) UpdateReservation(r models.Reservation) error {
+// ChangeReservationDates moves a reservation and its room restriction to new dates in a single
+// transaction. Like BookReservation, the room is locked while availability is re-checked; the
+// reservation's own restriction is ignored so a stay can be shortened or extended. The total price
+// is replaced with the quote for the new dates.
+func (m *postgresDbRepo) ChangeReservationDates(id int, start, end time.Time, totalPrice int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	query := `SELECT rm.id FROM rooms rm JOIN reservations r ON r.room_id = rm.id
+	WHERE r.id = $1 AND r.cancelled_at IS NULL AND r.deleted_at IS NULL FOR UPDATE OF rm`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query = `select count(id) from room_restrictions 
+			where room_id = $1 and $2 < end_date and $3 > start_date and (reservation_id is null or reservation_id <> $4)
+			and ` + notDeletedRestriction
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+	closed, err := recurringBlockClash(ctx, tx, roomID, start, end)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return repository.ErrRoomUnavailable
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET start_date = $1, end_date = $2, total_price = $3, updated_at = $4 WHERE id = $5`,
+		start, end, totalPrice, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3 WHERE reservation_id = $4`,
+		start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	// a reminder sent for the old dates is sent again for the new ones
+	_, err = tx.ExecContext(ctx, `DELETE FROM reservation_notifications WHERE reservation_id = $1 AND kind = $2`, id, models.NotificationReminder)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateReservationPayment records the payment state of a reservation
+func (m *postgresDbRepo) UpdateReservationPayment(id int, status, paymentID string, amountPaid int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	query := `UPDATE reservations SET first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5 
-	WHERE id = $6`
-	_, err := m.DB.ExecContext(ctx, query,
+	query := `UPDATE reservations SET payment_status = $1, payment_id = $2, amount_paid = $3, updated_at = $4 WHERE id = $5`
+	_, err := m.DB.ExecContext(ctx, query, status, paymentID, amountPaid, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeReservationPayment updates the payment of a reservation like UpdateReservationPayment, but
+// only while its payment status is still from. Otherwise it changes nothing and returns
+// repository.ErrPaymentChanged, so two requests cannot both act on the same payment.
+func (m *postgresDbRepo) ChangeReservationPayment(id int, from, to, paymentID string, amountPaid int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	query := `UPDATE reservations SET payment_status = $1, payment_id = $2, amount_paid = $3, updated_at = $4
+	WHERE id = $5 AND payment_status = $6`
+	result, err := m.DB.ExecContext(ctx, query, to, paymentID, amountPaid, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrPaymentChanged
+	}
+	return nil
+}
+
+// UpdateReservationStatus moves a reservation to a new status and records the change, made by
+// userID or by the guest if it is 0. The reservation is locked while its current status is checked,
+// and a move models.CanTransition does not allow fails with repository.ErrInvalidTransition.
+// Cancelling releases the room restriction, so the dates become available again.
+func (m *postgresDbRepo) UpdateReservationStatus(id int, status string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM reservations WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !models.CanTransition(current, status) {
+		return repository.ErrInvalidTransition
+	}
+
+	now := time.Now()
+	if status == models.StatusCancelled {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE reservations SET cancelled_at = $1 WHERE id = $2`, now, id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET status = $1, updated_at = $2 WHERE id = $3`, status, now, id)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO reservation_status_changes (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+	VALUES ($1, $2, $3, nullif($4, 0), $5, $5)`
+	_, err = tx.ExecContext(ctx, query, id, current, status, userID, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseExpiredHolds cancels the pending reservations made before the given time whose deposit is
+// still unpaid, releasing their dates, and returns how many there were. Reservations staff have
+// confirmed keep their dates whether or not the deposit is paid.
+func (m *postgresDbRepo) ReleaseExpiredHolds(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	query := `WITH released AS (
+		UPDATE reservations SET status = $1, cancelled_at = $2, updated_at = $2
+		WHERE status = $3 AND payment_status IN ($4, $5) AND created_at < $6 AND deleted_at IS NULL
+		RETURNING id
+	), freed AS (
+		DELETE FROM room_restrictions WHERE reservation_id IN (SELECT id FROM released)
+	)
+	INSERT INTO reservation_status_changes (reservation_id, from_status, to_status, created_at, updated_at)
+	SELECT id, $3, $1, $2, $2 FROM released`
+	result, err := m.DB.ExecContext(ctx, query, models.StatusCancelled, time.Now(), models.StatusPending,
+		models.PaymentPending, models.PaymentDeclined, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// ReservationStatusHistory returns the status changes of a reservation, oldest first, with the
+// name of the user who made each one
+func (m *postgresDbRepo) ReservationStatusHistory(id int) ([]models.StatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var changes []models.StatusChange
+	query := `SELECT c.id, c.reservation_id, c.from_status, c.to_status, coalesce(c.user_id, 0), c.created_at,
+	coalesce(u.first_name, ''), coalesce(u.last_name, '')
+	FROM reservation_status_changes c LEFT JOIN users u ON u.id = c.user_id
+	WHERE c.reservation_id = $1 ORDER BY c.created_at, c.id`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.CreatedAt,
+			&c.User.FirstName,
+			&c.User.LastName,
+		)
+		if err != nil {
+			return changes, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// UpdateReservation saves the guest details of a reservation, moving it to another guest if the
+// email has changed
+func (m *postgresDbRepo) UpdateReservation(r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	guestID, err := linkGuest(ctx, m.DB, r)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE reservations SET first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5, guest_id=$6
+	WHERE id = $7`
+	_, err = m.DB.ExecContext(ctx, query,
 		r.FirstName,
 		r.LastName,
 		r.Email,
 		r.Phone,
 		time.Now(),
+		guestID,
 		r.ID,
 	)
 	if err != nil {
@@ -309,50 +1083,187 @@ func (m *postgresDbRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
-func (m *postgresDbRepo) DeleteReservation(id int) error {
+// notDeletedRestriction is a condition on room_restrictions that leaves out the restrictions of
+// deleted reservations, so their dates can be booked again
+const notDeletedRestriction = `coalesce(reservation_id, 0) NOT IN (SELECT id FROM reservations WHERE deleted_at IS NOT NULL)`
+
+// DeleteReservation moves a reservation to the trash on behalf of userID. It keeps its room
+// restriction, which availability ignores until the reservation is restored.
+func (m *postgresDbRepo) DeleteReservation(id, userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	query := `DELETE FROM reservations WHERE id = $1`
-	_, err := m.DB.ExecContext(ctx, query, id)
+	query := `UPDATE reservations SET deleted_at = $1, deleted_by = nullif($2, 0) WHERE id = $3 AND deleted_at IS NULL`
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), userID, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *postgresDbRepo) UpdateProcessedReservation(id, processed int) error {
+// DeletedReservations returns the reservations in the trash, most recently deleted first, with
+// the user who deleted each one
+func (m *postgresDbRepo) DeletedReservations() ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	query := `UPDATE reservations SET processed=$1 WHERE id = $2`
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.start_date, r.end_date, r.status, r.deleted_at,
+	coalesce(r.deleted_by, 0), coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(rm.id, 0), coalesce(rm.room_name, '')
+	FROM reservations r
+	LEFT JOIN rooms rm ON r.room_id = rm.id
+	LEFT JOIN users u ON r.deleted_by = u.id
+	WHERE r.deleted_at IS NOT NULL
+	ORDER BY r.deleted_at DESC`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(
+			&res.ID,
+			&res.FirstName,
+			&res.LastName,
+			&res.Email,
+			&res.StartDate,
+			&res.EndDate,
+			&res.Status,
+			&res.DeletedAt,
+			&res.DeletedBy.ID,
+			&res.DeletedBy.FirstName,
+			&res.DeletedBy.LastName,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		res.RoomID = res.Room.ID
+		reservations = append(reservations, res)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// RestoreReservation takes a reservation out of the trash. Its dates may have been booked,
+// blocked or closed by a recurring block in the meantime, so like BookReservation the room is
+// locked while they are re-checked, and a clash fails with repository.ErrRoomUnavailable.
+func (m *postgresDbRepo) RestoreReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	var roomID int
+	query := `SELECT rm.id FROM rooms rm JOIN reservations r ON r.room_id = rm.id
+	WHERE r.id = $1 AND r.deleted_at IS NOT NULL FOR UPDATE OF rm`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	// a cancelled reservation has no restriction left, so there is nothing to clash
+	var numRows int
+	query = `select count(o.id) from room_restrictions own
+			join room_restrictions o on o.room_id = own.room_id and o.start_date < own.end_date and o.end_date > own.start_date
+			where own.reservation_id = $1 and o.id <> own.id
+			and coalesce(o.reservation_id, 0) NOT IN (SELECT id FROM reservations WHERE deleted_at IS NOT NULL)`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+	// the room may also have been closed by a recurring block added since
+	var start, end time.Time
+	err = tx.QueryRowContext(ctx, `SELECT start_date, end_date FROM room_restrictions WHERE reservation_id = $1`, id).Scan(&start, &end)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		closed, err := recurringBlockClash(ctx, tx, roomID, start, end)
+		if err != nil {
+			return err
+		}
+		if closed {
+			return repository.ErrRoomUnavailable
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET deleted_at = NULL, deleted_by = NULL, updated_at = $1 WHERE id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeletedReservations permanently removes the reservations deleted before the given time,
+// together with their room restrictions, and returns how many there were
+func (m *postgresDbRepo) PurgeDeletedReservations(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM reservations WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
 }
 
 func (m *postgresDbRepo) AllRooms() ([]models.Room, error) {
+	return m.listRooms("")
+}
+
+// ActiveRooms returns the rooms shown to guests, each with its cover photo
+func (m *postgresDbRepo) ActiveRooms() ([]models.Room, error) {
+	return m.listRooms("WHERE r.active")
+}
+
+func (m *postgresDbRepo) listRooms(where string, args ...interface{}) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
-	query := `SELECT id, room_name, created_at, updated_at FROM rooms ORDER BY room_name`
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := `SELECT r.id, r.room_name, r.slug, r.description, r.max_occupancy, r.base_rate, r.weekend_surcharge, r.min_nights,
+	r.active, r.created_at, r.updated_at,
+	(SELECT p.path FROM room_photos p WHERE p.room_id = r.id ORDER BY p.sort_order, p.id LIMIT 1)
+	FROM rooms r ` + where + ` ORDER BY r.room_name`
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return rooms, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var rm models.Room
+		var cover sql.NullString
 		err := rows.Scan(
 			&rm.ID,
 			&rm.RoomName,
+			&rm.Slug,
+			&rm.Description,
+			&rm.MaxOccupancy,
+			&rm.BaseRate,
+			&rm.WeekendSurcharge,
+			&rm.MinNights,
+			&rm.Active,
 			&rm.CreatedAt,
 			&rm.UpdatedAt,
+			&cover,
 		)
 		if err != nil {
 			return rooms, err
 		}
+		if cover.Valid {
+			rm.Photos = []models.RoomPhoto{{RoomID: rm.ID, Path: cover.String}}
+		}
 		rooms = append(rooms, rm)
 	}
 	if err := rows.Err(); err != nil {
@@ -361,13 +1272,19 @@ func (m *postgresDbRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
+// FetchRestrictionsForRoomByDay returns the restrictions of a room that overlap start to end.
+// Restrictions that belong to a reservation carry the guest's name and confirmation code. The
+// room's recurring blocks are expanded into owner blocks for the nights from start to end.
 func (m *postgresDbRepo) FetchRestrictionsForRoomByDay(id int, start, end time.Time) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 	var restrictions []models.RoomRestriction
-	query := `SELECT id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date 
-	FROM room_restrictions 
-	WHERE $1 < end_date AND $2 >= start_date AND room_id = $3`
+	query := `SELECT rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+	rr.note, rr.created_at, rr.updated_at, coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.confirmation_code, '')
+	FROM room_restrictions rr
+	LEFT JOIN reservations r ON r.id = rr.reservation_id
+	WHERE $1 < rr.end_date AND $2 >= rr.start_date AND rr.room_id = $3 AND ` + notDeletedRestriction + `
+	ORDER BY rr.start_date`
 	rows, err := m.DB.QueryContext(ctx, query, start, end, id)
 	if err != nil {
 		return restrictions, err
@@ -382,49 +1299,1151 @@ func (m *postgresDbRepo) FetchRestrictionsForRoomByDay(id int, start, end time.T
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.Note,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+			&r.Reservation.ConfirmationCode,
 		)
 		if err != nil {
 			return restrictions, err
 		}
+		r.Reservation.ID = r.ReservationID
 		restrictions = append(restrictions, r)
 	}
 	if err = rows.Err(); err != nil {
 		return restrictions, err
 	}
+
+	blocks, err := listRecurringBlocks(ctx, m.DB, `WHERE b.room_id = $1`, id)
+	if err != nil {
+		return restrictions, err
+	}
+	for _, b := range blocks {
+		restrictions = append(restrictions, b.Occurrences(start, end.AddDate(0, 0, 1))...)
+	}
+	sort.SliceStable(restrictions, func(i, j int) bool {
+		return restrictions[i].StartDate.Before(restrictions[j].StartDate)
+	})
 	return restrictions, nil
 }
 
-func (m *postgresDbRepo) InsertBlockForRoom(id int, start time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// InsertBlockForRoom blocks a room from start up to, but not including, end. Like BookReservation,
+// the room is locked while it is checked for overlapping restrictions, which make the block fail
+// with repository.ErrRoomUnavailable. It returns the id of the new block.
+func (m *postgresDbRepo) InsertBlockForRoom(id int, start, end time.Time, note string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	
-	query := `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
-	VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := m.DB.ExecContext(ctx, query,
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = checkBlockOverlap(ctx, tx, id, start, end, 0)
+	if err != nil {
+		return 0, err
+	}
+	var newID int
+	query := `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, note, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err = tx.QueryRowContext(ctx, query,
 		start,
-		start.AddDate(0, 0, 1),
+		end,
 		id,
-		2,
+		models.RestrictionOwnerBlock,
+		note,
 		time.Now(),
 		time.Now(),
-
-	)
+	).Scan(&newID)
 	if err != nil {
 		log.Println(err)
-		return err
+		return 0, err
 	}
-	return nil
+	return newID, tx.Commit()
 }
 
-func (m *postgresDbRepo) DeleteBlockById (id int) error {
+// GetBlockById returns an owner block
+func (m *postgresDbRepo) GetBlockById(id int) (models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	
-	query := `DELETE FROM  room_restrictions WHERE id=$1`
-	_, err := m.DB.ExecContext(ctx, query, id)
+
+	var r models.RoomRestriction
+	query := `SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.note, rr.created_at, rr.updated_at,
+	rm.room_name
+	FROM room_restrictions rr JOIN rooms rm ON rm.id = rr.room_id
+	WHERE rr.id = $1 AND rr.restriction_id = $2`
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&r.ID,
+		&r.RoomID,
+		&r.RestrictionID,
+		&r.StartDate,
+		&r.EndDate,
+		&r.Note,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Room.RoomName,
+	)
+	r.Room.ID = r.RoomID
+	return r, err
+}
+
+// UpdateBlock moves an owner block to new dates or another room and changes its note. It fails
+// with repository.ErrRoomUnavailable if the new dates overlap another restriction.
+func (m *postgresDbRepo) UpdateBlock(r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkBlockOverlap(ctx, tx, r.RoomID, r.StartDate, r.EndDate, r.ID)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE room_restrictions SET room_id = $1, start_date = $2, end_date = $3, note = $4, updated_at = $5
+	WHERE id = $6 AND restriction_id = $7`
+	_, err = tx.ExecContext(ctx, query, r.RoomID, r.StartDate, r.EndDate, r.Note, time.Now(), r.ID, models.RestrictionOwnerBlock)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkBlockOverlap locks a room and returns repository.ErrRoomUnavailable if any restriction other
+// than exceptID overlaps start to end
+func checkBlockOverlap(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, exceptID int) error {
+	var id int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&id)
+	if err != nil {
+		return err
+	}
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and id <> $4 and ` + notDeletedRestriction
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, exceptID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+func (m *postgresDbRepo) DeleteBlockById (id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	
+	query := `DELETE FROM  room_restrictions WHERE id=$1`
+	_, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (m *postgresDbRepo) InsertAPIToken(t models.APIToken) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var newID int
+	query := `INSERT INTO api_tokens (user_id, room_id, name, token_hash, created_at, updated_at)
+	VALUES ($1, nullif($2, 0), $3, $4, $5, $6) RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query,
+		t.UserID,
+		t.RoomID,
+		t.Name,
+		t.TokenHash,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// AllAPITokens returns every issued token, including revoked ones, newest first
+func (m *postgresDbRepo) AllAPITokens() ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var apiTokens []models.APIToken
+	query := `SELECT t.id, t.user_id, coalesce(t.room_id, 0), t.name, t.last_used_at, t.revoked_at, t.created_at, t.updated_at,
+	u.id, u.first_name, u.last_name, u.email, coalesce(rm.room_name, '') FROM api_tokens t
+	LEFT JOIN users u ON (t.user_id = u.id)
+	LEFT JOIN rooms rm ON (t.room_id = rm.id)
+	ORDER BY t.created_at DESC`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return apiTokens, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.APIToken
+		var lastUsed, revoked sql.NullTime
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.RoomID,
+			&t.Name,
+			&lastUsed,
+			&revoked,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+			&t.User.ID,
+			&t.User.FirstName,
+			&t.User.LastName,
+			&t.User.Email,
+			&t.Room.RoomName,
+		)
+		if err != nil {
+			return apiTokens, err
+		}
+		t.Room.ID = t.RoomID
+		t.LastUsedAt = lastUsed.Time
+		t.RevokedAt = revoked.Time
+		apiTokens = append(apiTokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return apiTokens, err
+	}
+	return apiTokens, nil
+}
+
+func (m *postgresDbRepo) RevokeAPIToken(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	query := `UPDATE api_tokens SET revoked_at = $1, updated_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the owner of an active token and records that the token was used.
+// sql.ErrNoRows is returned for unknown or revoked tokens, and for feed tokens.
+func (m *postgresDbRepo) AuthenticateAPIToken(tokenHash string) (models.User, error) {
+	return m.authenticateToken(`t.room_id IS NULL`, tokenHash)
+}
+
+// AuthenticateFeedToken is AuthenticateAPIToken for the feed tokens of a room
+func (m *postgresDbRepo) AuthenticateFeedToken(tokenHash string, roomID int) (models.User, error) {
+	return m.authenticateToken(`t.room_id = $3`, tokenHash, roomID)
+}
+
+// authenticateToken authenticates an active token that also matches scope
+func (m *postgresDbRepo) authenticateToken(scope string, tokenHash string, args ...interface{}) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	var u models.User
+	query := `UPDATE api_tokens t SET last_used_at = $1
+	FROM users u
+	WHERE t.user_id = u.id AND t.token_hash = $2 AND t.revoked_at IS NULL AND u.active AND ` + scope + `
+	RETURNING u.id, u.first_name, u.last_name, u.email, u.access_level, u.active, u.created_at, u.updated_at`
+	row := m.DB.QueryRowContext(ctx, query, append([]interface{}{time.Now(), tokenHash}, args...)...)
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.AccessLevel, &u.Active, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+const calendarFeedColumns = `f.id, f.room_id, f.name, f.url, coalesce(f.last_synced_at, '0001-01-01'::timestamp), f.last_error,
+	f.event_count, f.created_at, f.updated_at, rm.room_name`
+
+func (m *postgresDbRepo) listCalendarFeeds(ctx context.Context, query string, args ...interface{}) ([]models.CalendarFeed, error) {
+	var feeds []models.CalendarFeed
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f models.CalendarFeed
+		err := rows.Scan(
+			&f.ID,
+			&f.RoomID,
+			&f.Name,
+			&f.URL,
+			&f.LastSyncedAt,
+			&f.LastError,
+			&f.EventCount,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+			&f.Room.RoomName,
+		)
+		if err != nil {
+			return feeds, err
+		}
+		f.Room.ID = f.RoomID
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		return feeds, err
+	}
+	return feeds, nil
+}
+
+// AllCalendarFeeds returns the external calendar feeds of every room
+func (m *postgresDbRepo) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + calendarFeedColumns + `
+	FROM calendar_feeds f JOIN rooms rm ON rm.id = f.room_id
+	ORDER BY rm.room_name, f.name`
+	return m.listCalendarFeeds(ctx, query)
+}
+
+// CalendarFeedsForRoom returns the external calendar feeds imported into a room
+func (m *postgresDbRepo) CalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + calendarFeedColumns + `
+	FROM calendar_feeds f JOIN rooms rm ON rm.id = f.room_id
+	WHERE f.room_id = $1 ORDER BY f.name`
+	return m.listCalendarFeeds(ctx, query, roomID)
+}
+
+// GetCalendarFeedById returns one external calendar feed
+func (m *postgresDbRepo) GetCalendarFeedById(id int) (models.CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + calendarFeedColumns + `
+	FROM calendar_feeds f JOIN rooms rm ON rm.id = f.room_id
+	WHERE f.id = $1`
+	feeds, err := m.listCalendarFeeds(ctx, query, id)
+	if err != nil {
+		return models.CalendarFeed{}, err
+	}
+	if len(feeds) == 0 {
+		return models.CalendarFeed{}, sql.ErrNoRows
+	}
+	return feeds[0], nil
+}
+
+// InsertCalendarFeed adds an external calendar feed to a room and returns its id
+func (m *postgresDbRepo) InsertCalendarFeed(f models.CalendarFeed) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var id int
+	query := `INSERT INTO calendar_feeds (room_id, name, url, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query, f.RoomID, f.Name, f.URL, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteCalendarFeed removes an external calendar feed together with the bookings imported from it
+func (m *postgresDbRepo) DeleteCalendarFeed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM calendar_feeds WHERE id = $1`, id)
+	return err
+}
+
+// UpdateCalendarFeedSync records the outcome of the last sync of a feed
+func (m *postgresDbRepo) UpdateCalendarFeedSync(f models.CalendarFeed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	query := `UPDATE calendar_feeds SET last_synced_at = $1, last_error = $2, event_count = $3, updated_at = $4
+	WHERE id = $5`
+	_, err := m.DB.ExecContext(ctx, query, f.LastSyncedAt, f.LastError, f.EventCount, time.Now(), f.ID)
+	return err
+}
+
+// ExternalRestrictions returns the bookings imported from a feed
+func (m *postgresDbRepo) ExternalRestrictions(feedID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+	query := `SELECT id, room_id, restriction_id, start_date, end_date, feed_id, external_uid, created_at, updated_at
+	FROM room_restrictions WHERE feed_id = $1`
+	rows, err := m.DB.QueryContext(ctx, query, feedID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.FeedID,
+			&r.ExternalUID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// SaveExternalBooking inserts a booking imported from a calendar feed, or moves the one with r.ID
+// to r's dates, and returns the confirmation codes of the reservations it overlaps. The room is
+// locked as in BookReservation, so a guest cannot book the dates while they are imported. The
+// booking is saved even when it overlaps, since the room is taken at the outside site either way.
+func (m *postgresDbRepo) SaveExternalBooking(r models.RoomRestriction) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, r.RoomID).Scan(&roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if r.ID == 0 {
+		stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at, feed_id, external_uid)
+		VALUES ($1, $2, $3, $4, $5, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.RestrictionID, now, r.FeedID, r.ExternalUID)
+	} else {
+		stmt := `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3 WHERE id = $4`
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, now, r.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	query := `SELECT coalesce(res.confirmation_code, '') FROM room_restrictions rr
+	JOIN reservations res ON res.id = rr.reservation_id
+	WHERE rr.room_id = $1 AND $2 < rr.end_date AND $3 > rr.start_date AND res.deleted_at IS NULL
+	ORDER BY rr.start_date`
+	rows, err := tx.QueryContext(ctx, query, r.RoomID, r.StartDate, r.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so rules can be read inside a booking transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const recurringBlockColumns = `b.id, b.room_id, b.note, b.repeats, b.weekday, b.start_month, b.start_day, b.end_month, b.end_day,
+	b.created_at, b.updated_at, rm.room_name`
+
+func listRecurringBlocks(ctx context.Context, q querier, where string, args ...interface{}) ([]models.RecurringBlock, error) {
+	var blocks []models.RecurringBlock
+	query := `SELECT ` + recurringBlockColumns + `
+	FROM recurring_blocks b JOIN rooms rm ON rm.id = b.room_id
+	` + where + ` ORDER BY rm.room_name, b.id`
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.RecurringBlock
+		err := rows.Scan(
+			&b.ID,
+			&b.RoomID,
+			&b.Note,
+			&b.Repeat,
+			&b.Weekday,
+			&b.StartMonth,
+			&b.StartDay,
+			&b.EndMonth,
+			&b.EndDay,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+			&b.Room.RoomName,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		b.Room.ID = b.RoomID
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return blocks, err
+	}
+	return blocks, nil
+}
+
+// recurringBlockClash reports whether a recurring block closes a room on any night from start up
+// to, but not including, end
+func recurringBlockClash(ctx context.Context, q querier, roomID int, start, end time.Time) (bool, error) {
+	blocks, err := listRecurringBlocks(ctx, q, `WHERE b.room_id = $1`, roomID)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range blocks {
+		if b.Blocks(start, end) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AllRecurringBlocks returns the recurring blocks of every room
+func (m *postgresDbRepo) AllRecurringBlocks() ([]models.RecurringBlock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return listRecurringBlocks(ctx, m.DB, ``)
+}
+
+// GetRecurringBlockById returns one recurring block
+func (m *postgresDbRepo) GetRecurringBlockById(id int) (models.RecurringBlock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	blocks, err := listRecurringBlocks(ctx, m.DB, `WHERE b.id = $1`, id)
+	if err != nil {
+		return models.RecurringBlock{}, err
+	}
+	if len(blocks) == 0 {
+		return models.RecurringBlock{}, sql.ErrNoRows
+	}
+	return blocks[0], nil
+}
+
+// InsertRecurringBlock adds a recurring block to a room and returns its id. Reservations already
+// made for nights the rule closes are kept.
+func (m *postgresDbRepo) InsertRecurringBlock(b models.RecurringBlock) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var id int
+	query := `INSERT INTO recurring_blocks (room_id, note, repeats, weekday, start_month, start_day, end_month, end_day,
+	created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err := m.DB.QueryRowContext(ctx, query,
+		b.RoomID,
+		b.Note,
+		b.Repeat,
+		int(b.Weekday),
+		int(b.StartMonth),
+		b.StartDay,
+		int(b.EndMonth),
+		b.EndDay,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateRecurringBlock changes the room, schedule or note of a recurring block
+func (m *postgresDbRepo) UpdateRecurringBlock(b models.RecurringBlock) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	query := `UPDATE recurring_blocks SET room_id = $1, note = $2, repeats = $3, weekday = $4, start_month = $5,
+	start_day = $6, end_month = $7, end_day = $8, updated_at = $9
+	WHERE id = $10`
+	_, err := m.DB.ExecContext(ctx, query,
+		b.RoomID,
+		b.Note,
+		b.Repeat,
+		int(b.Weekday),
+		int(b.StartMonth),
+		b.StartDay,
+		int(b.EndMonth),
+		b.EndDay,
+		time.Now(),
+		b.ID,
+	)
+	return err
+}
+
+// DeleteRecurringBlock removes a recurring block, reopening every night it closed
+func (m *postgresDbRepo) DeleteRecurringBlock(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM recurring_blocks WHERE id = $1`, id)
+	return err
+}
+
+// InsertAuditEntry adds an entry to the audit log. The changes are stored as JSON.
+func (m *postgresDbRepo) InsertAuditEntry(e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	if e.Changes == nil {
+		changes = []byte("[]")
+	}
+	query := `INSERT INTO audit_log (user_id, action, entity_type, entity_id, changes, created_at, updated_at)
+	VALUES (nullif($1, 0), $2, $3, $4, $5, $6, $7)`
+	_, err = m.DB.ExecContext(ctx, query,
+		e.UserID,
+		e.Action,
+		e.EntityType,
+		e.EntityID,
+		string(changes),
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so searches match them literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// AuditEntries returns the audit log entries matching f, newest first
+func (m *postgresDbRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	// arg adds a query argument and returns its placeholder
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Query != "" {
+		p := arg("%" + likeEscaper.Replace(f.Query) + "%")
+		where = append(where, fmt.Sprintf(`(a.action ILIKE %[1]s OR a.entity_type ILIKE %[1]s OR a.changes ILIKE %[1]s
+		OR u.first_name || ' ' || u.last_name ILIKE %[1]s OR u.email ILIKE %[1]s)`, p))
+	}
+	if f.UserID > 0 {
+		where = append(where, "a.user_id = "+arg(f.UserID))
+	}
+	if f.EntityType != "" {
+		where = append(where, "a.entity_type = "+arg(f.EntityType))
+	}
+	if f.EntityID > 0 {
+		where = append(where, "a.entity_id = "+arg(f.EntityID))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "a.created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "a.created_at < "+arg(f.To.AddDate(0, 0, 1)))
+	}
+
+	query := `SELECT a.id, coalesce(a.user_id, 0), a.action, a.entity_type, a.entity_id, a.changes, a.created_at,
+	coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+	FROM audit_log a LEFT JOIN users u ON u.id = a.user_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY a.created_at DESC, a.id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + arg(f.Limit)
+	}
+
+	var entries []models.AuditEntry
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.AuditEntry
+		var changes string
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Action,
+			&e.EntityType,
+			&e.EntityID,
+			&changes,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+		)
+		if err != nil {
+			return entries, err
+		}
+		err = json.Unmarshal([]byte(changes), &e.Changes)
+		if err != nil {
+			return entries, err
+		}
+		e.User.ID = e.UserID
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// rowQuerier runs a query for a single row, on the database or in a transaction
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// linkGuest returns the guest that a reservation belongs to by its email, adding one with the
+// reservation's details if the email is new. The email of a guest merged into another one leads
+// to the other guest.
+func linkGuest(ctx context.Context, q rowQuerier, res models.Reservation) (int, error) {
+	var id int
+	stmt := `INSERT INTO guests (first_name, last_name, email, phone, created_at, updated_at)
+	VALUES ($1, $2, lower($3), $4, $5, $5)
+	ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+	RETURNING coalesce(merged_into, id)`
+	err := q.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, strings.TrimSpace(res.Email), res.Phone, time.Now()).Scan(&id)
+	return id, err
+}
+
+const guestColumns = `g.id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.tags, coalesce(g.merged_into, 0),
+	g.created_at, g.updated_at`
+
+// scanGuest reads guestColumns, followed by the extra columns of the query into extra
+func scanGuest(row interface{ Scan(...interface{}) error }, g *models.Guest, extra ...interface{}) error {
+	var tags string
+	dest := []interface{}{&g.ID, &g.FirstName, &g.LastName, &g.Email, &g.Phone, &g.Notes, &tags, &g.MergedInto,
+		&g.CreatedAt, &g.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	g.Tags = models.ParseTags(tags)
+	return nil
+}
+
+// listGuests returns the guests matching where, with their stays, ordered by name. Arguments to
+// where are numbered from $1; a limit of 0 returns every match.
+func (m *postgresDbRepo) listGuests(ctx context.Context, where string, limit int, args ...interface{}) ([]models.Guest, error) {
+	// the same reservations as models.Reservation.CountsAsStay
+	stay := fmt.Sprintf("r.status NOT IN ('%s', '%s')", models.StatusCancelled, models.StatusNoShow)
+	query := `SELECT ` + guestColumns + `, count(r.id) FILTER (WHERE ` + stay + `),
+	coalesce(sum(r.end_date - r.start_date) FILTER (WHERE ` + stay + `), 0), max(r.start_date) FILTER (WHERE ` + stay + `)
+	FROM guests g LEFT JOIN reservations r ON r.guest_id = g.id AND r.deleted_at IS NULL
+	WHERE ` + where + `
+	GROUP BY g.id
+	ORDER BY lower(g.last_name), lower(g.first_name), g.id`
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var guests []models.Guest
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return guests, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g models.Guest
+		var lastStay sql.NullTime
+		if err := scanGuest(rows, &g, &g.Stays, &g.Nights, &lastStay); err != nil {
+			return guests, err
+		}
+		g.LastStay = lastStay.Time
+		guests = append(guests, g)
+	}
+	if err := rows.Err(); err != nil {
+		return guests, err
+	}
+	return guests, nil
+}
+
+// ListGuests returns up to limit guests whose name, email, phone or tags contain query, or every
+// guest for an empty query. Guests merged into another are left out.
+func (m *postgresDbRepo) ListGuests(query string, limit int) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return m.listGuests(ctx, "g.merged_into IS NULL", limit)
+	}
+	return m.listGuests(ctx, `g.merged_into IS NULL AND (g.first_name || ' ' || g.last_name ILIKE $1 OR g.email ILIKE $1
+	OR g.phone ILIKE $1 OR g.tags ILIKE $1)`, limit, "%"+likeEscaper.Replace(query)+"%")
+}
+
+// GetGuestById returns a guest with their stays, including a guest merged into another
+func (m *postgresDbRepo) GetGuestById(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	guests, err := m.listGuests(ctx, "g.id = $1", 0, id)
+	if err != nil {
+		return models.Guest{}, err
+	}
+	if len(guests) == 0 {
+		return models.Guest{}, sql.ErrNoRows
+	}
+	return guests[0], nil
+}
+
+// GuestDuplicates returns the other guests that may be the same person as g: those with the same
+// name, or the same phone number however it was typed
+func (m *postgresDbRepo) GuestDuplicates(g models.Guest) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return m.listGuests(ctx, `g.merged_into IS NULL AND g.id <> $1 AND (
+	(lower(g.first_name) = lower($2) AND lower(g.last_name) = lower($3))
+	OR (regexp_replace($4, '[^0-9]', '', 'g') <> '' AND regexp_replace(g.phone, '[^0-9]', '', 'g') = regexp_replace($4, '[^0-9]', '', 'g')))`,
+		20, g.ID, g.FirstName, g.LastName, g.Phone)
+}
+
+// GuestReservations returns the reservations of a guest that are not in the trash, latest arrival
+// first
+func (m *postgresDbRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.status, r.total_price, rm.id, rm.room_name FROM reservations r
+	LEFT JOIN rooms rm ON (r.room_id = rm.id)
+	WHERE r.guest_id = $1 AND r.deleted_at IS NULL
+	ORDER BY r.start_date DESC, r.id DESC`
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		res := models.Reservation{GuestID: guestID}
+		err := rows.Scan(
+			&res.ID,
+			&res.FirstName,
+			&res.LastName,
+			&res.Email,
+			&res.Phone,
+			&res.StartDate,
+			&res.EndDate,
+			&res.RoomID,
+			&res.CreatedAt,
+			&res.UpdatedAt,
+			&res.Status,
+			&res.TotalPrice,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, res)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// UpdateGuest saves the name, phone, notes and tags staff have on file for a guest. The email
+// identifies the guest and does not change.
+func (m *postgresDbRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stmt := `UPDATE guests SET first_name = $1, last_name = $2, phone = $3, notes = $4, tags = $5, updated_at = $6
+	WHERE id = $7 AND merged_into IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, g.FirstName, g.LastName, g.Phone, g.Notes, strings.Join(g.Tags, ","), time.Now(), g.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MergeGuests folds the guest mergeID into keepID, as in models.Guest.Merge, in a single
+// transaction. The duplicate's reservations move to keepID, and its email, along with those of the
+// guests merged into it before, leads to keepID from then on. It returns sql.ErrNoRows unless both
+// are different guests that have not been merged already.
+func (m *postgresDbRepo) MergeGuests(keepID, mergeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+guestColumns+` FROM guests g
+	WHERE g.id IN ($1, $2) AND g.merged_into IS NULL FOR UPDATE`, keepID, mergeID)
+	if err != nil {
+		return err
+	}
+	var keep, dup models.Guest
+	found := 0
+	for rows.Next() {
+		var g models.Guest
+		if err := scanGuest(rows, &g); err != nil {
+			rows.Close()
+			return err
+		}
+		if g.ID == keepID {
+			keep = g
+		} else {
+			dup = g
+		}
+		found++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found != 2 {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	merged := keep.Merge(dup)
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET guest_id = $1, updated_at = $2 WHERE guest_id = $3`, keepID, now, mergeID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE guests SET merged_into = $1, updated_at = $2 WHERE merged_into = $3 OR id = $3`, keepID, now, mergeID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE guests SET phone = $1, notes = $2, tags = $3, updated_at = $4 WHERE id = $5`,
+		merged.Phone, merged.Notes, strings.Join(merged.Tags, ","), now, keepID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execer runs a statement on the database or in a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queueEmail puts an email in the outbox, due to be sent straight away
+func queueEmail(ctx context.Context, db execer, e models.MailData) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `INSERT INTO emails (to_address, from_address, subject, content, text_content, status, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $7)`,
+		e.To, e.From, e.Subject, e.Content, e.Text, models.EmailQueued, now)
+	return err
+}
+
+// QueueEmail puts an email in the outbox for the mail worker to send
+func (m *postgresDbRepo) QueueEmail(e models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return queueEmail(ctx, m.DB, e)
+}
+
+const emailColumns = `id, to_address, from_address, subject, content, text_content, status, attempts, last_error,
+	next_attempt_at, coalesce(sent_at, '0001-01-01'::timestamp), created_at, updated_at`
+
+// scanEmails reads the rows of a query selecting emailColumns
+func scanEmails(rows *sql.Rows) ([]models.Email, error) {
+	defer rows.Close()
+	var emails []models.Email
+	for rows.Next() {
+		var e models.Email
+		err := rows.Scan(&e.ID, &e.To, &e.From, &e.Subject, &e.Content, &e.Text, &e.Status, &e.Attempts, &e.LastError,
+			&e.NextAttemptAt, &e.SentAt, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+// ClaimDueEmails returns up to limit queued emails that are due by now, oldest first, and holds
+// them for lease by moving their next attempt on. Rows another worker is claiming are skipped, so
+// no email is handed to two workers.
+func (m *postgresDbRepo) ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.Email, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	query := `UPDATE emails SET next_attempt_at = $1, updated_at = $2
+		WHERE id IN (
+			SELECT id FROM emails WHERE status = $3 AND next_attempt_at <= $2
+			ORDER BY next_attempt_at, id LIMIT $4 FOR UPDATE SKIP LOCKED)
+		RETURNING ` + emailColumns
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, models.EmailQueued, limit)
+	if err != nil {
+		return nil, err
+	}
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ID < emails[j].ID })
+	return emails, nil
+}
+
+// UpdateEmailDelivery records an attempt to send an email
+func (m *postgresDbRepo) UpdateEmailDelivery(e models.Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var sentAt interface{}
+	if !e.SentAt.IsZero() {
+		sentAt = e.SentAt
+	}
+	_, err := m.DB.ExecContext(ctx, `UPDATE emails SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4,
+		sent_at = $5, updated_at = $6 WHERE id = $7`,
+		e.Status, e.Attempts, e.LastError, e.NextAttemptAt, sentAt, time.Now(), e.ID)
+	return err
+}
+
+// FailedEmails returns the emails that were given up on, most recent first
+func (m *postgresDbRepo) FailedEmails() ([]models.Email, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+emailColumns+` FROM emails WHERE status = $1 ORDER BY updated_at DESC, id DESC`,
+		models.EmailFailed)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmails(rows)
+}
+
+// ResendEmail queues a failed email again with a fresh set of attempts. It returns sql.ErrNoRows
+// unless the email exists and has failed.
+func (m *postgresDbRepo) ResendEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	now := time.Now()
+	result, err := m.DB.ExecContext(ctx, `UPDATE emails SET status = $1, attempts = 0, last_error = '', next_attempt_at = $2, updated_at = $2
+		WHERE id = $3 AND status = $4`,
+		models.EmailQueued, now, id, models.EmailFailed)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReservationsArriving returns the reservations starting between from and to, both included, that
+// have not had the notification kind
+func (m *postgresDbRepo) ReservationsArriving(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsToNotify("r.start_date", from, to, kind)
+}
+
+// ReservationsDeparted returns the reservations that ended between from and to, both included,
+// that have not had the notification kind
+func (m *postgresDbRepo) ReservationsDeparted(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsToNotify("r.end_date", from, to, kind)
+}
+
+// reservationsToNotify lists the stays with dateColumn between from and to that were neither
+// cancelled nor missed and have no notification kind yet
+func (m *postgresDbRepo) reservationsToNotify(dateColumn string, from, to time.Time, kind string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.status, coalesce(r.confirmation_code, ''), r.total_price, r.payment_status, rm.id, rm.room_name FROM reservations r
+	LEFT JOIN rooms rm ON r.room_id = rm.id
+	WHERE r.deleted_at IS NULL AND r.status NOT IN ($1, $2) AND r.email <> ''
+	AND ` + dateColumn + ` BETWEEN $3 AND $4
+	AND NOT EXISTS (SELECT 1 FROM reservation_notifications n WHERE n.reservation_id = r.id AND n.kind = $5)
+	ORDER BY ` + dateColumn + `, r.id`
+	rows, err := m.DB.QueryContext(ctx, query, models.StatusCancelled, models.StatusNoShow, from, to, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reservations []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(
+			&res.ID,
+			&res.FirstName,
+			&res.LastName,
+			&res.Email,
+			&res.StartDate,
+			&res.EndDate,
+			&res.RoomID,
+			&res.CreatedAt,
+			&res.UpdatedAt,
+			&res.Status,
+			&res.ConfirmationCode,
+			&res.TotalPrice,
+			&res.PaymentStatus,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+	return reservations, rows.Err()
+}
+
+// QueueNotification records the notification kind for a reservation and queues its email in the
+// same transaction. It returns false, queueing nothing, when kind was already recorded, so the
+// email goes out once however many times it is queued.
+func (m *postgresDbRepo) QueueNotification(reservationID int, kind string, e models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `INSERT INTO reservation_notifications (reservation_id, kind, created_at, updated_at)
+		VALUES ($1, $2, $3, $3) ON CONFLICT (reservation_id, kind) DO NOTHING`, reservationID, kind, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	err = queueEmail(ctx, tx, e)
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
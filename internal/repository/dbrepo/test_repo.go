@@ -1,14 +1,37 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/models"
+	"github.com/Ed-cred/bookings/internal/repository"
+	"github.com/Ed-cred/bookings/internal/tokens"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+func (m *testDBRepo) AllUsers() ([]models.User, error) {
+	return []models.User{
+		{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@here.com", AccessLevel: models.AccessLevelOwner, Active: true},
+		{ID: 2, FirstName: "Front", LastName: "Desk", Email: "desk@here.com", AccessLevel: models.AccessLevelStaff},
+	}, nil
+}
+
+func (m *testDBRepo) InsertUser(u models.User, password string) (int, error) {
+	if u.Email == "taken@here.com" {
+		return 0, repository.ErrDuplicateEmail
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdatePassword(id int, password string) error {
+	return nil
+}
+
+func (m *testDBRepo) SetUserActive(id int, active bool) error {
+	return nil
 }
 
 func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
@@ -25,12 +48,25 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+func (m *testDBRepo) BookReservation(res models.Reservation, emails []models.MailData) (int, error) {
+	switch res.RoomID {
+	case 3:
+		return 0, errors.New("failed to insert room restriction into database")
+	case 4:
+		return 0, errors.New("failed to insert reservation into database")
+	case 5:
+		return 0, repository.ErrRoomUnavailable
+	}
+	return 1, nil
+}
+
 // Returns true if the date range is available for specified roomID,otherwise false
 func (m *testDBRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error) {
 	if roomID == 1000{
 		return false, errors.New("failed to search availability")
 	}
-	return false, nil
+	// rooms are free for any stay starting in 2050
+	return start.Year() == 2050, nil
 }
 
 func (m *testDBRepo) SearchAvailabilityAllRooms(start, end time.Time) ([]models.Room, error) {
@@ -53,20 +89,109 @@ func (m *testDBRepo) SearchAvailabilityAllRooms(start, end time.Time) ([]models.
 	return rooms, nil
 }
 
+// testRooms are the rooms known to the test repo
+var testRooms = []models.Room{
+	{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", MaxOccupancy: 2, BaseRate: 12000, Active: true,
+		Photos: []models.RoomPhoto{{ID: 1, RoomID: 1, Path: "/static/images/generals-quarters.png"}}},
+	{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", MaxOccupancy: 4, BaseRate: 18000, Active: false},
+}
+
+// GetRoomById also knows rooms 3 to 5, which exist only so that bookings for them get as far
+// as BookReservation
 func (m *testDBRepo) GetRoomById(id int) (models.Room, error) {
 	var room models.Room
-	if id > 2 {
-		return room, errors.New("some error")
+	if id > 5 {
+		return room, sql.ErrNoRows
+	}
+	if id > len(testRooms) {
+		return models.Room{ID: id, RoomName: fmt.Sprintf("Room %d", id), MaxOccupancy: 2, BaseRate: 10000, Active: true}, nil
+	}
+	if id > 0 {
+		room = testRooms[id-1]
 	}
 	return room, nil
 }
 
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	for _, room := range testRooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) ActiveRooms() ([]models.Room, error) {
+	return testRooms[:1], nil
+}
+
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	if room.Slug == "generals-quarters" {
+		return 0, repository.ErrDuplicateSlug
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	for _, other := range testRooms {
+		if other.Slug == room.Slug && other.ID != room.ID {
+			return repository.ErrDuplicateSlug
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteRoomPhoto(id int) error {
+	return nil
+}
+
+// RoomRates gives room 1 a festival in July 2050 with a three night minimum stay
+func (m *testDBRepo) RoomRates(roomID int) ([]models.RoomRate, error) {
+	if roomID != 1 {
+		return nil, nil
+	}
+	return []models.RoomRate{
+		{ID: 1, RoomID: 1, Name: "Festival", StartDate: time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC),
+			EndDate: time.Date(2050, 7, 10, 0, 0, 0, 0, time.UTC), NightlyRate: 20000, MinNights: 3},
+	}, nil
+}
+
+func (m *testDBRepo) InsertRoomRate(r models.RoomRate) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteRoomRate(id int) error {
+	return nil
+}
+
 func (m *testDBRepo) GetUserById (id int) (models.User, error) {
 	var u models.User
+	if id > 1000 {
+		return u, sql.ErrNoRows
+	}
+	u.ID = id
+	u.Active = true
 	return u, nil
 }
 
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch email {
+	case "me@sosmart.com":
+		return models.User{ID: 1, Email: email, Active: true}, nil
+	case "gone@sosmart.com":
+		return models.User{ID: 2, Email: email, Active: false}, nil
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
 func (m *testDBRepo) UpdateUser (u models.User) (error) {
+	if u.Email == "taken@here.com" {
+		return repository.ErrDuplicateEmail
+	}
 	return nil
 }
 
@@ -74,6 +199,9 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 	if email == "me@sosmart.com" {
 		return 1, "", nil
 	}
+	if email == "gone@sosmart.com" {
+		return 0, "", repository.ErrUserInactive
+	}
 	return 0, "", errors.New("no way mate")
 }
 
@@ -81,39 +209,642 @@ func (m *testDBRepo) AllReservations () ([]models.Reservation, error) {
 	return []models.Reservation{}, nil
 }
 
-func (m *testDBRepo) AllNewReservations () ([]models.Reservation, error) {
-	return []models.Reservation{}, nil
+// testReservationList is 30 reservations: every third one is for Jane Doe in room 2 and pending,
+// the others for John Smith in room 1 and confirmed
+func testReservationList() []models.Reservation {
+	var list []models.Reservation
+	for i := 1; i <= 30; i++ {
+		res := models.Reservation{
+			ID:        i,
+			RoomID:    1,
+			GuestID:   1,
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			Status:    models.StatusConfirmed,
+			StartDate: time.Date(2050, 1, i, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, i+1, 0, 0, 0, 0, time.UTC),
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		}
+		if i%3 == 0 {
+			res.RoomID, res.GuestID, res.FirstName, res.LastName, res.Email = 2, 2, "Jane", "Doe", "jane@doe.com"
+			res.Status = models.StatusPending
+			res.Room = models.Room{ID: 2, RoomName: "Major's Suite"}
+		}
+		list = append(list, res)
+	}
+	return list
+}
+
+// SearchReservations filters testReservationList, but does not sort it. Room 1000 fails.
+func (m *testDBRepo) SearchReservations(f models.ReservationFilter) (models.ReservationPage, error) {
+	page := models.ReservationPage{Page: f.Page, PerPage: f.PerPage}
+	if f.RoomID == 1000 {
+		return page, errors.New("failed to search reservations")
+	}
+	var matches []models.Reservation
+	for _, res := range testReservationList() {
+		name := strings.ToLower(res.FirstName + " " + res.LastName + " " + res.Email)
+		switch {
+		case f.Query != "" && !strings.Contains(name, strings.ToLower(f.Query)),
+			f.RoomID > 0 && res.RoomID != f.RoomID,
+			f.Status != "" && res.Status != f.Status,
+			!f.From.IsZero() && !res.EndDate.After(f.From),
+			!f.To.IsZero() && res.StartDate.After(f.To):
+			continue
+		}
+		matches = append(matches, res)
+	}
+	page.Total = len(matches)
+	if start := f.Offset(); start < len(matches) {
+		end := start + f.PerPage
+		if f.PerPage < 1 || end > len(matches) {
+			end = len(matches)
+		}
+		page.Reservations = matches[start:end]
+	}
+	return page, nil
+}
+
+// SearchGuests returns up to limit reservations from testReservationList whose guest's name or
+// email contains query, in list order. The query "fail" fails.
+func (m *testDBRepo) SearchGuests(query string, limit int) ([]models.Reservation, error) {
+	var matches []models.Reservation
+	if query == "fail" {
+		return matches, errors.New("failed to search guests")
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, res := range testReservationList() {
+		if len(matches) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(res.FirstName+" "+res.LastName+" "+res.Email), query) {
+			matches = append(matches, res)
+		}
+	}
+	return matches, nil
+}
+
+// testGuests are John Smith, the VIP who made the John Smith reservations of testReservationList;
+// Jane Doe, who made the others; Jon Smith, who has John's phone number but no reservations; and
+// a guest merged into John
+func testGuests() []models.Guest {
+	return []models.Guest{
+		{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-0100", Notes: "Late arrival", Tags: []string{models.TagVIP}},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com"},
+		{ID: 3, FirstName: "Jon", LastName: "Smith", Email: "jon@smith.com", Phone: "(555) 0100"},
+		{ID: 4, FirstName: "J", LastName: "Smith", Email: "j@smith.com", MergedInto: 1},
+	}
+}
+
+// ListGuests returns up to limit testGuests, not merged, whose name or email contains query. The
+// query "fail" fails.
+func (m *testDBRepo) ListGuests(query string, limit int) ([]models.Guest, error) {
+	var guests []models.Guest
+	if query == "fail" {
+		return guests, errors.New("failed to list guests")
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, g := range testGuests() {
+		if len(guests) == limit {
+			break
+		}
+		if g.MergedInto == 0 && strings.Contains(strings.ToLower(g.FirstName+" "+g.LastName+" "+g.Email), query) {
+			g, _ = m.GetGuestById(g.ID)
+			guests = append(guests, g)
+		}
+	}
+	return guests, nil
+}
+
+// GetGuestById returns one of testGuests with their stays. Other ids are not found.
+func (m *testDBRepo) GetGuestById(id int) (models.Guest, error) {
+	for _, g := range testGuests() {
+		if g.ID == id {
+			reservations, _ := m.GuestReservations(id)
+			g.Stays, g.Nights = models.StayTotals(reservations)
+			if len(reservations) > 0 {
+				g.LastStay = reservations[0].StartDate
+			}
+			return g, nil
+		}
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// GuestDuplicates returns Jon Smith as the duplicate of John Smith
+func (m *testDBRepo) GuestDuplicates(g models.Guest) ([]models.Guest, error) {
+	if g.ID != 1 {
+		return nil, nil
+	}
+	jon, _ := m.GetGuestById(3)
+	return []models.Guest{jon}, nil
+}
+
+// GuestReservations returns the reservations of testReservationList made by the guest, latest
+// arrival first
+func (m *testDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	for _, res := range testReservationList() {
+		if res.GuestID == guestID {
+			reservations = append([]models.Reservation{res}, reservations...)
+		}
+	}
+	return reservations, nil
+}
+
+// UpdateGuest fails for guests that are not in testGuests
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	if _, err := m.GetGuestById(g.ID); err != nil {
+		return err
+	}
+	return nil
 }
 
+// MergeGuests fails unless both guests are in testGuests, different and not merged already
+func (m *testDBRepo) MergeGuests(keepID, mergeID int) error {
+	keep, err := m.GetGuestById(keepID)
+	if err != nil {
+		return err
+	}
+	dup, err := m.GetGuestById(mergeID)
+	if err != nil {
+		return err
+	}
+	if keepID == mergeID || keep.MergedInto != 0 || dup.MergedInto != 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FetchReservationById returns the paid "PAID" reservation for id 13
 func (m *testDBRepo) FetchReservationById(id int) (models.Reservation, error) {
-	return models.Reservation{}, nil
+	if id > 1000 {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	if id == 13 {
+		return m.FetchReservationByCode("PAID")
+	}
+	if id == 21 {
+		// its deposit was not paid in time, so its dates were released
+		return models.Reservation{ID: id, Status: models.StatusCancelled, CreatedAt: time.Now().Add(-time.Hour)}, nil
+	}
+	return models.Reservation{ID: id, Status: models.StatusPending, CreatedAt: time.Now()}, nil
 }
 
 
-func (m *testDBRepo) UpdateReservation (r models.Reservation) (error) {
+func (m *testDBRepo) FetchReservationByCode(code string) (models.Reservation, error) {
+	res := models.Reservation{
+		RoomID:           1,
+		ConfirmationCode: code,
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Status:           models.StatusConfirmed,
+		Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	switch code {
+	case "UPCOMING":
+		res.ID = 10
+	case "STARTED":
+		res.ID = 11
+		res.StartDate = time.Now().AddDate(0, 0, -1)
+		res.EndDate = time.Now().AddDate(0, 0, 1)
+	case "CANCELLED":
+		res.ID = 12
+		res.Status = models.StatusCancelled
+		res.CancelledAt = time.Now()
+	case "PAID":
+		res.ID = 13
+		res.TotalPrice = 24000
+		res.PaymentStatus = models.PaymentPaid
+		res.PaymentID = "fake_PAID"
+		res.AmountPaid = 4800
+	case "UNPAID":
+		res.ID = 20
+		res.Status = models.StatusPending
+		res.TotalPrice = 24000
+		res.PaymentStatus = models.PaymentPending
+	default:
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	return res, nil
+}
+
+// ChangeReservationDates fails as if the room were taken for any stay starting in 2060
+func (m *testDBRepo) ChangeReservationDates(id int, start, end time.Time, totalPrice int) error {
+	if start.Year() == 2060 {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// UpdateReservationStatus checks the move against the status FetchReservationById gives
+func (m *testDBRepo) UpdateReservationStatus(id int, status string, userID int) error {
+	res, err := m.FetchReservationById(id)
+	if err != nil {
+		return err
+	}
+	if !models.CanTransition(res.Status, status) {
+		return repository.ErrInvalidTransition
+	}
+	return nil
+}
+
+func (m *testDBRepo) ReleaseExpiredHolds(before time.Time) (int, error) {
+	return 0, nil
+}
+
+// ReservationStatusHistory has reservation 13 confirmed by the admin user
+func (m *testDBRepo) ReservationStatusHistory(id int) ([]models.StatusChange, error) {
+	if id != 13 {
+		return []models.StatusChange{}, nil
+	}
+	return []models.StatusChange{
+		{
+			ID:            1,
+			ReservationID: 13,
+			FromStatus:    models.StatusPending,
+			ToStatus:      models.StatusConfirmed,
+			UserID:        1,
+			CreatedAt:     time.Date(2049, 12, 1, 9, 0, 0, 0, time.UTC),
+			User:          models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+		},
+	}, nil
+}
+
+func (m *testDBRepo) FetchReservationByPaymentID(paymentID string) (models.Reservation, error) {
+	if paymentID == "fake_PAID" {
+		return m.FetchReservationByCode("PAID")
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) UpdateReservationPayment(id int, status, paymentID string, amountPaid int) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation (id int) error {
+// ChangeReservationPayment finds the deposit of reservation 22 already claimed by another request
+func (m *testDBRepo) ChangeReservationPayment(id int, from, to, paymentID string, amountPaid int) error {
+	if id == 22 {
+		return repository.ErrPaymentChanged
+	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedReservation (id, processed int) error {
+func (m *testDBRepo) UpdateReservation (r models.Reservation) (error) {
+	return nil
+}
+
+func (m *testDBRepo) DeleteReservation(id, userID int) error {
 	return nil
 }
 
+// DeletedReservations has reservation 14 in the trash, deleted by the admin user
+func (m *testDBRepo) DeletedReservations() ([]models.Reservation, error) {
+	return []models.Reservation{
+		{
+			ID:        14,
+			FirstName: "Jane",
+			LastName:  "Trashed",
+			StartDate: time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 3, 4, 0, 0, 0, 0, time.UTC),
+			Status:    models.StatusConfirmed,
+			DeletedAt: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC),
+			DeletedBy: models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+	}, nil
+}
+
+// RestoreReservation restores reservation 14, fails for 15 as if its dates were taken since and
+// knows no other reservation in the trash
+func (m *testDBRepo) RestoreReservation(id int) error {
+	switch id {
+	case 14:
+		return nil
+	case 15:
+		return repository.ErrRoomUnavailable
+	}
+	return sql.ErrNoRows
+}
+
+func (m *testDBRepo) PurgeDeletedReservations(before time.Time) (int, error) {
+	return 0, nil
+}
+
 func (m *testDBRepo) AllRooms () ([]models.Room, error) {
 	return []models.Room{}, nil
 }
 
 func (m *testDBRepo) FetchRestrictionsForRoomByDay(id int, start, end time.Time) ([]models.RoomRestriction, error) {
-	return []models.RoomRestriction{}, nil
+	if id == 2 {
+		// room 2 has only its recurring blocks
+		var restrictions []models.RoomRestriction
+		for _, b := range testRecurringBlocks {
+			restrictions = append(restrictions, b.Occurrences(start, end.AddDate(0, 0, 1))...)
+		}
+		return restrictions, nil
+	}
+	if id != 1 {
+		return []models.RoomRestriction{}, nil
+	}
+	// room 1 has a reservation and a three night owner block right after it
+	changed := time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC)
+	arrival := start.AddDate(0, 0, 7)
+	return []models.RoomRestriction{
+		{
+			ID:            1,
+			RoomID:        1,
+			ReservationID: 10,
+			RestrictionID: 1,
+			StartDate:     arrival,
+			EndDate:       arrival.AddDate(0, 0, 2),
+			CreatedAt:     changed,
+			UpdatedAt:     changed,
+			Reservation:   models.Reservation{ID: 10, FirstName: "John", LastName: "Smith", ConfirmationCode: "UPCOMING"},
+		},
+		{
+			ID:            2,
+			RoomID:        1,
+			RestrictionID: 2,
+			StartDate:     arrival.AddDate(0, 0, 2),
+			EndDate:       arrival.AddDate(0, 0, 5),
+			Note:          "Painting",
+			CreatedAt:     changed,
+			UpdatedAt:     changed,
+		},
+	}, nil
+}
+func (m *testDBRepo) InsertBlockForRoom(id int, start, end time.Time, note string) (int, error) {
+	if start.Year() == 2060 {
+		return 0, repository.ErrRoomUnavailable
+	}
+	return 3, nil
 }
-func (m *testDBRepo) InsertBlockForRoom(id int, start time.Time) error {
+
+func (m *testDBRepo) GetBlockById(id int) (models.RoomRestriction, error) {
+	if id != 2 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+	return models.RoomRestriction{
+		ID:            2,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC),
+		Note:          "Painting",
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters"},
+	}, nil
+}
+
+func (m *testDBRepo) UpdateBlock(r models.RoomRestriction) error {
+	if r.StartDate.Year() == 2060 {
+		return repository.ErrRoomUnavailable
+	}
 	return nil
 }
 
 
 func (m *testDBRepo) DeleteBlockById (id int) error {
 	return nil
-}
\ No newline at end of file
+}
+
+// testRecurringBlocks close room 2 every Monday and for the first week of every February
+var testRecurringBlocks = []models.RecurringBlock{
+	{
+		ID:      1,
+		RoomID:  2,
+		Note:    "Deep clean",
+		Repeat:  models.RepeatWeekly,
+		Weekday: time.Monday,
+		Room:    models.Room{ID: 2, RoomName: "Major's Suite"},
+	},
+	{
+		ID:         2,
+		RoomID:     2,
+		Note:       "Owner's holiday",
+		Repeat:     models.RepeatYearly,
+		StartMonth: time.February,
+		StartDay:   1,
+		EndMonth:   time.February,
+		EndDay:     7,
+		Room:       models.Room{ID: 2, RoomName: "Major's Suite"},
+	},
+}
+
+func (m *testDBRepo) AllRecurringBlocks() ([]models.RecurringBlock, error) {
+	return testRecurringBlocks, nil
+}
+
+func (m *testDBRepo) GetRecurringBlockById(id int) (models.RecurringBlock, error) {
+	for _, b := range testRecurringBlocks {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return models.RecurringBlock{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertRecurringBlock(b models.RecurringBlock) (int, error) {
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRecurringBlock(b models.RecurringBlock) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRecurringBlock(id int) error {
+	return nil
+}
+
+// testCalendarFeeds are the external calendars of room 1: one synced, one failing
+var testCalendarFeeds = []models.CalendarFeed{
+	{
+		ID:           1,
+		RoomID:       1,
+		Name:         "Outside Stays",
+		URL:          "http://127.0.0.1:1/outside.ics",
+		LastSyncedAt: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC),
+		EventCount:   2,
+		Room:         models.Room{ID: 1, RoomName: "General's Quarters"},
+	},
+	{
+		ID:           2,
+		RoomID:       1,
+		Name:         "Broken Site",
+		URL:          "http://127.0.0.1:1/broken.ics",
+		LastSyncedAt: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC),
+		LastError:    "unexpected status 404 Not Found",
+		Room:         models.Room{ID: 1, RoomName: "General's Quarters"},
+	},
+}
+
+func (m *testDBRepo) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	return testCalendarFeeds, nil
+}
+
+func (m *testDBRepo) CalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error) {
+	if roomID != 1 {
+		return []models.CalendarFeed{}, nil
+	}
+	return testCalendarFeeds, nil
+}
+
+func (m *testDBRepo) GetCalendarFeedById(id int) (models.CalendarFeed, error) {
+	for _, f := range testCalendarFeeds {
+		if f.ID == id {
+			return f, nil
+		}
+	}
+	return models.CalendarFeed{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertCalendarFeed(f models.CalendarFeed) (int, error) {
+	return 3, nil
+}
+
+func (m *testDBRepo) DeleteCalendarFeed(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateCalendarFeedSync(f models.CalendarFeed) error {
+	return nil
+}
+
+func (m *testDBRepo) ExternalRestrictions(feedID int) ([]models.RoomRestriction, error) {
+	return []models.RoomRestriction{}, nil
+}
+
+func (m *testDBRepo) SaveExternalBooking(r models.RoomRestriction) ([]string, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) InsertAPIToken(t models.APIToken) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) AllAPITokens() ([]models.APIToken, error) {
+	return []models.APIToken{}, nil
+}
+
+func (m *testDBRepo) RevokeAPIToken(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) AuthenticateAPIToken(tokenHash string) (models.User, error) {
+	switch tokenHash {
+	case tokens.Hash("staff-token"):
+		return models.User{ID: 1, AccessLevel: models.AccessLevelStaff, Active: true}, nil
+	case tokens.Hash("auditor-token"):
+		return models.User{ID: 2, AccessLevel: models.AccessLevelAuditor, Active: true}, nil
+	case tokens.Hash("deactivated-token"):
+		// a deactivated user's token, as if the database had not left it out
+		return models.User{ID: 3, AccessLevel: models.AccessLevelStaff}, nil
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// AuthenticateFeedToken knows the auditor's feed token for room 1
+func (m *testDBRepo) AuthenticateFeedToken(tokenHash string, roomID int) (models.User, error) {
+	if tokenHash == tokens.Hash("room-1-feed-token") && roomID == 1 {
+		return models.User{ID: 2, AccessLevel: models.AccessLevelAuditor, Active: true}, nil
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	return nil
+}
+
+// testAuditEntries are an edit of reservation 10 by the owner and the removal of block 2 by the
+// front desk
+var testAuditEntries = []models.AuditEntry{
+	{
+		ID:         2,
+		UserID:     2,
+		Action:     models.AuditDelete,
+		EntityType: models.EntityBlock,
+		EntityID:   2,
+		Changes:    []models.AuditChange{{Field: "note", Before: "Painting"}},
+		CreatedAt:  time.Date(2050, 1, 3, 9, 0, 0, 0, time.UTC),
+		User:       models.User{ID: 2, FirstName: "Front", LastName: "Desk", Email: "desk@here.com"},
+	},
+	{
+		ID:         1,
+		UserID:     1,
+		Action:     models.AuditUpdate,
+		EntityType: models.EntityReservation,
+		EntityID:   10,
+		Changes:    []models.AuditChange{{Field: "first_name", Before: "John", After: "Jon, Jr."}},
+		CreatedAt:  time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC),
+		User:       models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@here.com"},
+	},
+}
+
+func (m *testDBRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	for _, e := range testAuditEntries {
+		if f.EntityType != "" && e.EntityType != f.EntityType || f.UserID > 0 && e.UserID != f.UserID {
+			continue
+		}
+		if f.Query != "" && !strings.Contains(strings.ToLower(fmt.Sprint(e.Action, e.EntityType, e.Changes, e.User.FirstName)), strings.ToLower(f.Query)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (m *testDBRepo) QueueEmail(e models.MailData) error {
+	return nil
+}
+
+func (m *testDBRepo) ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.Email, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) UpdateEmailDelivery(e models.Email) error {
+	return nil
+}
+
+// FailedEmails has the confirmation for reservation 1, which the mail server kept refusing
+func (m *testDBRepo) FailedEmails() ([]models.Email, error) {
+	return []models.Email{
+		{
+			ID: 1,
+			MailData: models.MailData{
+				To:      "j@smith.com",
+				From:    "me@here.com",
+				Subject: "Reservation confirmation",
+			},
+			Status:    models.EmailFailed,
+			Attempts:  8,
+			LastError: "dial tcp 127.0.0.1:1025: connect: connection refused",
+			CreatedAt: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2050, 1, 1, 16, 0, 0, 0, time.UTC),
+		},
+	}, nil
+}
+
+// ResendEmail knows only failed email 1
+func (m *testDBRepo) ResendEmail(id int) error {
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) ReservationsArriving(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) ReservationsDeparted(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) QueueNotification(reservationID int, kind string, e models.MailData) (bool, error) {
+	return true, nil
+}
@@ -1,29 +1,111 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/Ed-cred/bookings/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a booking overlaps an existing room restriction
+var ErrRoomUnavailable = errors.New("room is no longer available for the selected dates")
+
+// ErrDuplicateEmail is returned when a user is saved with an email that belongs to another user
+var ErrDuplicateEmail = errors.New("email address is already in use")
+
+// ErrDuplicateSlug is returned when a room is saved with a slug that belongs to another room
+var ErrDuplicateSlug = errors.New("slug is already in use")
+
+// ErrInvalidTransition is returned when a reservation cannot move from its current status to the
+// one asked for
+var ErrInvalidTransition = errors.New("reservation cannot move to that status")
+
+// ErrUserInactive is returned when a deactivated user tries to log in
+var ErrUserInactive = errors.New("user account is deactivated")
+
+// ErrPaymentChanged is returned when the payment of a reservation is no longer in the status a
+// change was made from, because another request or webhook changed it first
+var ErrPaymentChanged = errors.New("reservation payment has changed")
+
 type DbRepo interface {
-	AllUsers() bool
+	AllUsers() ([]models.User, error)
+	InsertUser(u models.User, password string) (int, error)
+	UpdatePassword(id int, password string) error
+	SetUserActive(id int, active bool) error
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction (r models.RoomRestriction) error
+	BookReservation(res models.Reservation, emails []models.MailData) (int, error)
 	SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error)
 	SearchAvailabilityAllRooms(start, end time.Time) ([]models.Room, error)
 	GetRoomById (id int) (models.Room, error)
 	GetUserById (id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
 	UpdateUser (u models.User) (error)
 	Authenticate(email, testPassword string) (int, string, error)
 	AllReservations () ([]models.Reservation, error)
-	AllNewReservations () ([]models.Reservation, error)
+	SearchReservations(f models.ReservationFilter) (models.ReservationPage, error)
+	SearchGuests(query string, limit int) ([]models.Reservation, error)
+	ListGuests(query string, limit int) ([]models.Guest, error)
+	GetGuestById(id int) (models.Guest, error)
+	GuestDuplicates(g models.Guest) ([]models.Guest, error)
+	GuestReservations(guestID int) ([]models.Reservation, error)
+	UpdateGuest(g models.Guest) error
+	MergeGuests(keepID, mergeID int) error
 	FetchReservationById(id int) (models.Reservation, error)
+	FetchReservationByCode(code string) (models.Reservation, error)
+	FetchReservationByPaymentID(paymentID string) (models.Reservation, error)
+	UpdateReservationPayment(id int, status, paymentID string, amountPaid int) error
+	ChangeReservationPayment(id int, from, to, paymentID string, amountPaid int) error
+	ChangeReservationDates(id int, start, end time.Time, totalPrice int) error
+	UpdateReservationStatus(id int, status string, userID int) error
+	ReservationStatusHistory(id int) ([]models.StatusChange, error)
+	ReleaseExpiredHolds(before time.Time) (int, error)
 	UpdateReservation (r models.Reservation) (error)
-	DeleteReservation (id int) error
-	UpdateProcessedReservation (id, processed int) error
+	DeleteReservation(id, userID int) error
+	DeletedReservations() ([]models.Reservation, error)
+	RestoreReservation(id int) error
+	PurgeDeletedReservations(before time.Time) (int, error)
 	AllRooms () ([]models.Room, error)
+	ActiveRooms() ([]models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	DeleteRoomPhoto(id int) error
+	RoomRates(roomID int) ([]models.RoomRate, error)
+	InsertRoomRate(r models.RoomRate) (int, error)
+	DeleteRoomRate(id int) error
 	FetchRestrictionsForRoomByDay(id int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, start time.Time) error
+	InsertBlockForRoom(id int, start, end time.Time, note string) (int, error)
+	GetBlockById(id int) (models.RoomRestriction, error)
+	UpdateBlock(r models.RoomRestriction) error
 	DeleteBlockById (id int) error
+	AllRecurringBlocks() ([]models.RecurringBlock, error)
+	GetRecurringBlockById(id int) (models.RecurringBlock, error)
+	InsertRecurringBlock(b models.RecurringBlock) (int, error)
+	UpdateRecurringBlock(b models.RecurringBlock) error
+	DeleteRecurringBlock(id int) error
+	AllCalendarFeeds() ([]models.CalendarFeed, error)
+	CalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error)
+	GetCalendarFeedById(id int) (models.CalendarFeed, error)
+	InsertCalendarFeed(f models.CalendarFeed) (int, error)
+	DeleteCalendarFeed(id int) error
+	UpdateCalendarFeedSync(f models.CalendarFeed) error
+	ExternalRestrictions(feedID int) ([]models.RoomRestriction, error)
+	SaveExternalBooking(r models.RoomRestriction) ([]string, error)
+	InsertAPIToken(t models.APIToken) (int, error)
+	AllAPITokens() ([]models.APIToken, error)
+	RevokeAPIToken(id int) error
+	AuthenticateAPIToken(tokenHash string) (models.User, error)
+	AuthenticateFeedToken(tokenHash string, roomID int) (models.User, error)
+	InsertAuditEntry(e models.AuditEntry) error
+	AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error)
+	QueueEmail(m models.MailData) error
+	ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.Email, error)
+	UpdateEmailDelivery(e models.Email) error
+	FailedEmails() ([]models.Email, error)
+	ResendEmail(id int) error
+	ReservationsArriving(from, to time.Time, kind string) ([]models.Reservation, error)
+	ReservationsDeparted(from, to time.Time, kind string) ([]models.Reservation, error)
+	QueueNotification(reservationID int, kind string, m models.MailData) (bool, error)
 }
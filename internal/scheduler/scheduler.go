@@ -0,0 +1,145 @@
+// Package scheduler queues the emails sent to guests around their stay: a reminder a few days
+// before they arrive and a thank-you a few days after they leave. Each is recorded against the
+// reservation when it is queued, so running the scheduler again, or several of them at once, does
+// not send it twice.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+// catchUp is how long after it was due a thank-you is still sent, so one missed while the app
+// was down goes out late, but past stays are not all thanked when the emails are first turned on
+const catchUp = 7 * 24 * time.Hour
+
+// Store is the part of the repository the scheduler needs
+type Store interface {
+	ReservationsArriving(from, to time.Time, kind string) ([]models.Reservation, error)
+	ReservationsDeparted(from, to time.Time, kind string) ([]models.Reservation, error)
+	QueueNotification(reservationID int, kind string, m models.MailData) (bool, error)
+}
+
+// Scheduler finds the reservations that are due an email and queues it
+type Scheduler struct {
+	Store     Store
+	Templates *mailer.Templates
+	// From sends the emails
+	From string
+	// ManageURL is the link to where the guest can change or cancel the reservation
+	ManageURL func(models.Reservation) string
+	// ReminderDays is how many days before arrival the reminder is sent and ThankYouDays how many
+	// days after departure the thank-you is; 0 turns either off
+	ReminderDays int
+	ThankYouDays int
+	// ReviewURL is where the thank-you asks guests to review their stay, if set
+	ReviewURL string
+	InfoLog   *log.Logger
+	ErrorLog  *log.Logger
+	// Now returns the current time
+	Now func() time.Time
+}
+
+// New returns a scheduler that reminds guests 3 days before they arrive and thanks them the day
+// after they leave
+func New(store Store, templates *mailer.Templates, from string, manageURL func(models.Reservation) string, infoLog, errorLog *log.Logger) *Scheduler {
+	return &Scheduler{
+		Store:        store,
+		Templates:    templates,
+		From:         from,
+		ManageURL:    manageURL,
+		ReminderDays: 3,
+		ThankYouDays: 1,
+		InfoLog:      infoLog,
+		ErrorLog:     errorLog,
+		Now:          time.Now,
+	}
+}
+
+// Run queues the emails that are due straight away and then every interval, until ctx is done
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n := s.QueueDue(); n > 0 {
+			s.InfoLog.Printf("Queued %d reminder and thank-you emails", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// QueueDue queues the reminders and thank-yous that are due and returns how many it queued. A
+// reservation that fails is logged and tried again the next time.
+func (s *Scheduler) QueueDue() int {
+	now := s.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	queued := 0
+	if s.ReminderDays > 0 {
+		queued += s.queueReminders(today)
+	}
+	if s.ThankYouDays > 0 {
+		queued += s.queueThankYous(today)
+	}
+	return queued
+}
+
+// queueReminders reminds the guests arriving in the next ReminderDays days
+func (s *Scheduler) queueReminders(today time.Time) int {
+	reservations, err := s.Store.ReservationsArriving(today.AddDate(0, 0, 1), today.AddDate(0, 0, s.ReminderDays), models.NotificationReminder)
+	if err != nil {
+		s.ErrorLog.Println("Cannot load the reservations due a reminder:", err)
+		return 0
+	}
+	queued := 0
+	for _, res := range reservations {
+		// a guest who booked after the reminder was due has just had their confirmation, and
+		// one who has not paid yet may never come
+		if res.CreatedAt.After(res.StartDate.AddDate(0, 0, -s.ReminderDays)) || res.AwaitingPayment() {
+			continue
+		}
+		if s.queue(res, models.NotificationReminder, mailer.Reminder{Reservation: res, ManageURL: s.ManageURL(res)}) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// queueThankYous thanks the guests who left ThankYouDays days ago, or up to catchUp before that
+func (s *Scheduler) queueThankYous(today time.Time) int {
+	due := today.AddDate(0, 0, -s.ThankYouDays)
+	reservations, err := s.Store.ReservationsDeparted(due.Add(-catchUp), due, models.NotificationThankYou)
+	if err != nil {
+		s.ErrorLog.Println("Cannot load the reservations due a thank-you:", err)
+		return 0
+	}
+	queued := 0
+	for _, res := range reservations {
+		if s.queue(res, models.NotificationThankYou, mailer.ThankYou{Reservation: res, ReviewURL: s.ReviewURL}) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// queue writes the email kind for res and queues it, reporting whether it was queued now
+func (s *Scheduler) queue(res models.Reservation, kind string, e mailer.Email) bool {
+	m, err := s.Templates.Render(res.Email, s.From, e)
+	if err != nil {
+		s.ErrorLog.Printf("Cannot write the %s email for reservation %d: %v", kind, res.ID, err)
+		return false
+	}
+	queued, err := s.Store.QueueNotification(res.ID, kind, m)
+	if err != nil {
+		s.ErrorLog.Printf("Cannot queue the %s email for reservation %d: %v", kind, res.ID, err)
+		return false
+	}
+	return queued
+}
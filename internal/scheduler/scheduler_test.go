@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ed-cred/bookings/internal/mailer"
+	"github.com/Ed-cred/bookings/internal/models"
+)
+
+var now = time.Date(2050, 6, 10, 9, 0, 0, 0, time.UTC)
+
+func day(offset int) time.Time {
+	return time.Date(2050, 6, 10+offset, 0, 0, 0, 0, time.UTC)
+}
+
+// memoryStore keeps the reservations, the notifications recorded for them and the emails queued
+type memoryStore struct {
+	reservations []models.Reservation
+	notified     map[int]map[string]bool
+	queued       []models.MailData
+	failing      bool
+}
+
+func newStore(reservations ...models.Reservation) *memoryStore {
+	return &memoryStore{reservations: reservations, notified: make(map[int]map[string]bool)}
+}
+
+func (s *memoryStore) list(date func(models.Reservation) time.Time, from, to time.Time, kind string) ([]models.Reservation, error) {
+	if s.failing {
+		return nil, errors.New("database is down")
+	}
+	var found []models.Reservation
+	for _, res := range s.reservations {
+		d := date(res)
+		if res.Status == models.StatusCancelled || res.Status == models.StatusNoShow || d.Before(from) || d.After(to) || s.notified[res.ID][kind] {
+			continue
+		}
+		found = append(found, res)
+	}
+	return found, nil
+}
+
+func (s *memoryStore) ReservationsArriving(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return s.list(func(res models.Reservation) time.Time { return res.StartDate }, from, to, kind)
+}
+
+func (s *memoryStore) ReservationsDeparted(from, to time.Time, kind string) ([]models.Reservation, error) {
+	return s.list(func(res models.Reservation) time.Time { return res.EndDate }, from, to, kind)
+}
+
+func (s *memoryStore) QueueNotification(reservationID int, kind string, m models.MailData) (bool, error) {
+	if s.notified[reservationID][kind] {
+		return false, nil
+	}
+	if s.notified[reservationID] == nil {
+		s.notified[reservationID] = make(map[string]bool)
+	}
+	s.notified[reservationID][kind] = true
+	s.queued = append(s.queued, m)
+	return true, nil
+}
+
+func newScheduler(t *testing.T, store Store) *Scheduler {
+	t.Helper()
+	templates, err := mailer.Load("./../../email_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manageURL := func(res models.Reservation) string {
+		return "http://localhost:8080/reservations/" + res.ConfirmationCode
+	}
+	s := New(store, templates, "me@here.com", manageURL, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	s.ReviewURL = "https://reviews.example.com/fort-dowry"
+	s.Now = func() time.Time { return now }
+	return s
+}
+
+// stay is a confirmed reservation booked a month before it starts
+func stay(id, start, end int) models.Reservation {
+	return models.Reservation{
+		ID:               id,
+		FirstName:        "John",
+		Email:            "john@smith.com",
+		Status:           models.StatusConfirmed,
+		ConfirmationCode: fmt.Sprintf("CODE%04d", id),
+		StartDate:        day(start),
+		EndDate:          day(end),
+		CreatedAt:        day(start - 30),
+		Room:             models.Room{RoomName: "General's Quarters"},
+	}
+}
+
+func TestQueueDue(t *testing.T) {
+	lateBooking := stay(5, 2, 4)
+	lateBooking.CreatedAt = now // a day after its reminder was due
+	unpaid := stay(6, 3, 5)
+	unpaid.PaymentStatus = models.PaymentPending
+	cancelled := stay(7, 1, 2)
+	cancelled.Status = models.StatusCancelled
+	store := newStore(
+		stay(1, 3, 5),    // arrives in 3 days: reminded
+		stay(2, 4, 6),    // arrives in 4 days: too early
+		stay(3, -4, -1),  // left yesterday: thanked
+		stay(4, -20, -9), // left too long ago to be thanked
+		lateBooking,
+		unpaid,
+		cancelled,
+		stay(8, -3, 0), // leaves today
+	)
+	s := newScheduler(t, store)
+
+	if n := s.QueueDue(); n != 2 {
+		t.Fatalf("expected 2 emails queued, got %d", n)
+	}
+	if !store.notified[1][models.NotificationReminder] || !store.notified[3][models.NotificationThankYou] {
+		t.Errorf("expected reservation 1 reminded and 3 thanked, got %v", store.notified)
+	}
+	reminder, thanks := store.queued[0], store.queued[1]
+	if reminder.To != "john@smith.com" || reminder.From != "me@here.com" || !strings.Contains(reminder.Text, "/reservations/CODE0001") {
+		t.Errorf("unexpected reminder %+v", reminder)
+	}
+	if !strings.Contains(thanks.Subject, "Thank you") || !strings.Contains(thanks.Content, "https://reviews.example.com/fort-dowry") {
+		t.Errorf("unexpected thank-you %+v", thanks)
+	}
+
+	if n := s.QueueDue(); n != 0 || len(store.queued) != 2 {
+		t.Errorf("expected nothing queued the second time, got %d", n)
+	}
+
+	// two days on, reservation 2 is due its reminder and 8 its thank-you
+	now = now.AddDate(0, 0, 2)
+	defer func() { now = now.AddDate(0, 0, -2) }()
+	if n := s.QueueDue(); n != 2 || !store.notified[2][models.NotificationReminder] || !store.notified[8][models.NotificationThankYou] {
+		t.Errorf("expected reservation 2 reminded and 8 thanked, got %d queued and %v", n, store.notified)
+	}
+}
+
+func TestQueueDueTurnedOff(t *testing.T) {
+	store := newStore(stay(1, 3, 5), stay(2, -4, -1))
+	s := newScheduler(t, store)
+	s.ReminderDays, s.ThankYouDays = 0, 0
+
+	if n := s.QueueDue(); n != 0 || len(store.queued) != 0 {
+		t.Errorf("expected nothing queued, got %d", n)
+	}
+}
+
+func TestQueueDueCustomOffsets(t *testing.T) {
+	store := newStore(stay(1, 7, 9), stay(2, 3, 5), stay(3, -6, -3))
+	s := newScheduler(t, store)
+	s.ReminderDays, s.ThankYouDays = 7, 3
+
+	if n := s.QueueDue(); n != 3 {
+		t.Errorf("expected 3 emails queued, got %d", n)
+	}
+}
+
+func TestQueueDueStoreDown(t *testing.T) {
+	store := newStore(stay(1, 3, 5))
+	store.failing = true
+	s := newScheduler(t, store)
+
+	if n := s.QueueDue(); n != 0 {
+		t.Errorf("expected nothing queued, got %d", n)
+	}
+	store.failing = false
+	if n := s.QueueDue(); n != 1 {
+		t.Errorf("expected the reminder queued once the store is back, got %d", n)
+	}
+}
@@ -0,0 +1,54 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSigned(t *testing.T) {
+	key := []byte("test-key")
+	now := time.Now()
+	token := NewSigned(key, 7, now.Add(time.Hour), "hash")
+
+	s, err := ParseSigned(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserID != 7 {
+		t.Errorf("expected user id 7, got %d", s.UserID)
+	}
+	if err := s.Verify(key, "hash", now); err != nil {
+		t.Errorf("expected a valid token, got %v", err)
+	}
+	if err := s.Verify(key, "new-hash", now); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken after the state changed, got %v", err)
+	}
+	if err := s.Verify([]byte("other-key"), "hash", now); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for a different key, got %v", err)
+	}
+	if err := s.Verify(key, "hash", now.Add(2*time.Hour)); err != ErrExpiredToken {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestParseSignedTampered(t *testing.T) {
+	key := []byte("test-key")
+	token := NewSigned(key, 7, time.Now().Add(time.Hour), "hash")
+	forged := NewSigned([]byte("other-key"), 1, time.Now().Add(time.Hour), "hash")
+
+	// swap the payload of a valid token for another user id
+	tampered := forged[:len(forged)-43] + token[len(token)-43:]
+	s, err := ParseSigned(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(key, "hash", time.Now()); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for a tampered token, got %v", err)
+	}
+
+	for _, bad := range []string{"", "abc", "abc.def", "!!!.abc"} {
+		if _, err := ParseSigned(bad); err != ErrInvalidToken {
+			t.Errorf("expected ErrInvalidToken for %q, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// tokenBytes is the amount of randomness in a generated token
+const tokenBytes = 32
+
+// codeBytes is the amount of randomness in a confirmation code
+const codeBytes = 10
+
+// New generates a random token and returns it together with the hash that should be stored.
+// Only the hash is ever persisted, the plain token is shown to the user once.
+func New() (string, string, error) {
+	b := make([]byte, tokenBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(b)
+	return plain, Hash(plain), nil
+}
+
+// Hash returns the hex encoded SHA-256 hash of a plain token
+func Hash(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewCode generates a short, unguessable code that is easy to read out or type,
+// such as a reservation confirmation code. Codes are upper case.
+func NewCode() (string, error) {
+	b := make([]byte, codeBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
@@ -0,0 +1,60 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	plain, hash, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain == "" {
+		t.Error("got an empty token")
+	}
+	if hash != Hash(plain) {
+		t.Error("returned hash does not match the hash of the plain token")
+	}
+
+	other, _, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == plain {
+		t.Error("two generated tokens are identical")
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("abc") != Hash("abc") {
+		t.Error("hash is not deterministic")
+	}
+	if Hash("abc") == Hash("abd") {
+		t.Error("different tokens produced the same hash")
+	}
+	if len(Hash("abc")) != 64 {
+		t.Errorf("expected a 64 character hash, got %d", len(Hash("abc")))
+	}
+}
+
+func TestNewCode(t *testing.T) {
+	code, err := NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 16 {
+		t.Errorf("expected a 16 character code, got %q", code)
+	}
+	if code != strings.ToUpper(code) {
+		t.Errorf("expected an upper case code, got %q", code)
+	}
+
+	other, err := NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == code {
+		t.Error("two generated codes are identical")
+	}
+}
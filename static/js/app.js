@@ -140,6 +140,7 @@ function PopUp(token, roomId){
                             attention.custom({
                                 icon: 'success',
                                 msg: '<p>Room is available</p>'
+                                + '<p>Total for your stay: ' + data.price + '</p>'
                                 + '<p><a href="/book_room?id=' + data.room_id + '&s=' + data.start_date + '&e=' + data.end_date +  
                                 '"class="btn btn-primary">' + 'Book now</a></p>',
                                 showConfirmButton: false